@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/greytabby/meowapi/lib/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const adminUsage = `Usage: meowapi admin <command> [options]
+
+Commands:
+  create-admin  Create an admin user, or promote an existing user to admin.
+`
+
+// runAdmin "meowapi admin" サブコマンドを実行する
+func runAdmin(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, adminUsage)
+		return 2
+	}
+	switch args[0] {
+	case "create-admin":
+		return createAdmin(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+		fmt.Fprint(os.Stderr, adminUsage)
+		return 2
+	}
+}
+
+// createAdmin 管理者ユーザを作成する
+// 既に存在するユーザの場合は管理者に変更する
+func createAdmin(args []string) int {
+	fs := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	name := fs.String("name", "", "user name (required)")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from stdin")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *name == "" {
+		fmt.Fprintln(os.Stderr, "-name is required")
+		fs.Usage()
+		return 2
+	}
+
+	// Prefer stdin or environment variable so the password does not remain in shell history.
+	pw := os.Getenv("MEOWAPI_ADMIN_PASSWORD")
+	if *passwordStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintf(os.Stderr, "Can not read password. %v\n", err)
+			return 1
+		}
+		pw = strings.TrimRight(line, "\r\n")
+	}
+
+	dbAccessor, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can not create db accessor. %v\n", err)
+		return 1
+	}
+	defer dbAccessor.Db.Db.Close()
+
+	user, err := dbAccessor.FindUser(*name)
+	if err == nil {
+		user.Role = model.UserRoleAdmin
+		user.Disabled = false
+		if err := dbAccessor.UpdateUser(user); err != nil {
+			fmt.Fprintf(os.Stderr, "Can not update user. %v\n", err)
+			return 1
+		}
+		fmt.Printf("User %s is now an admin.\n", user.Name)
+		return 0
+	}
+
+	if pw == "" {
+		fmt.Fprintln(os.Stderr, "Password is required to create a new user. Use -password-stdin or MEOWAPI_ADMIN_PASSWORD.")
+		return 2
+	}
+	if len(pw) > 72 {
+		fmt.Fprintln(os.Stderr, "Password length is 72 or less.")
+		return 2
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can not hash password. %v\n", err)
+		return 1
+	}
+	user = model.User{Name: *name, Password: string(hash), Role: model.UserRoleAdmin}
+	if err := dbAccessor.AddUser(user); err != nil {
+		fmt.Fprintf(os.Stderr, "Can not create user. %v\n", err)
+		return 1
+	}
+	fmt.Printf("Admin user %s is created.\n", user.Name)
+	return 0
+}
@@ -0,0 +1,51 @@
+package blob
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrNotFound keyに対応するblobが存在しない
+	ErrNotFound = errors.New("blob not found")
+	// ErrInvalidKey keyとして使えない文字列
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Info 保存したblobの情報
+type Info struct {
+	ContentType string
+	Size        int64
+}
+
+// Store 画像などのblobの保存先
+// keyは "/" 区切りのパスで、英数字と "-_./" のみを使う
+type Store interface {
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Get 呼び出し元はReadCloserを閉じること
+	Get(key string) (io.ReadCloser, Info, error)
+	// Delete 存在しないkeyの削除はエラーにしない
+	Delete(key string) error
+}
+
+// ValidKey keyが使用できる文字列か判定する
+func ValidKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-' || r == '_' || r == '.' || r == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package blob
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// contentTypeSuffix Content-Typeを保存するファイルの拡張子
+const contentTypeSuffix = ".type"
+
+// FileStore ローカルのファイルシステムにblobを保存するStore
+type FileStore struct {
+	Dir string
+}
+
+// NewFileStore dirを作成しFileStoreを返す
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	return &FileStore{Dir: dir}, nil
+}
+
+func (fs *FileStore) path(key string) (string, error) {
+	if !ValidKey(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(fs.Dir, filepath.FromSlash(key)), nil
+}
+
+// Put 一時ファイルに書き込んでからrenameする
+func (fs *FileStore) Put(key string, r io.Reader, size int64, contentType string) error {
+	p, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(p+contentTypeSuffix, []byte(contentType), 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// Get keyのファイルを開く
+func (fs *FileStore) Get(key string) (io.ReadCloser, Info, error) {
+	p, err := fs.path(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, Info{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Info{}, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Info{}, err
+	}
+	info := Info{Size: st.Size(), ContentType: "application/octet-stream"}
+	if ct, err := ioutil.ReadFile(p + contentTypeSuffix); err == nil {
+		info.ContentType = string(ct)
+	}
+	return f, info, nil
+}
+
+// Delete keyのファイルを削除する
+func (fs *FileStore) Delete(key string) error {
+	p, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{p, p + contentTypeSuffix} {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
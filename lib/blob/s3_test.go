@@ -0,0 +1,162 @@
+package blob
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// s3Stub path-styleのPUT, GET, DELETE Objectに応答するS3互換のサーバ
+// 署名はstoreと同じ鍵で計算し直して確認する
+type s3Stub struct {
+	t       *testing.T
+	store   *S3Store
+	mu      sync.Mutex
+	objects map[string]s3Object
+}
+
+type s3Object struct {
+	body        []byte
+	contentType string
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.verify(r) {
+		http.Error(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>", http.StatusForbidden)
+		return
+	}
+	prefix := "/" + s.store.Bucket + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, prefix)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.objects[key] = s3Object{body: body, contentType: r.Header.Get("Content-Type")}
+	case http.MethodGet:
+		o, ok := s.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", o.contentType)
+		w.Write(o.body)
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// verify リクエストの署名をX-Amz-Dateの時刻で計算し直して比較する
+func (s *s3Stub) verify(r *http.Request) bool {
+	now, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		s.t.Errorf("%s %s: invalid X-Amz-Date %q", r.Method, r.URL, r.Header.Get("X-Amz-Date"))
+		return false
+	}
+	req, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	if err != nil {
+		s.t.Error(err)
+		return false
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+	s.store.sign(req, now)
+	return r.Header.Get("Authorization") == req.Header.Get("Authorization")
+}
+
+func newS3Stub(t *testing.T) (*S3Store, *httptest.Server, *s3Stub) {
+	store := &S3Store{
+		Region:          "ap-northeast-1",
+		Bucket:          "meowapi",
+		AccessKeyId:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	stub := &s3Stub{t: t, store: store, objects: map[string]s3Object{}}
+	srv := httptest.NewServer(stub)
+	store.Endpoint = srv.URL
+	return store, srv, stub
+}
+
+func TestS3StorePutGetDelete(t *testing.T) {
+	store, srv, stub := newS3Stub(t)
+	defer srv.Close()
+
+	const key = "photos/1/abc.jpg"
+	body := "jpeg bytes"
+	if err := store.Put(key, strings.NewReader(body), int64(len(body)), "image/jpeg"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if o := stub.objects[key]; string(o.body) != body || o.contentType != "image/jpeg" {
+		t.Fatalf("stored object = %q %q", o.body, o.contentType)
+	}
+
+	r, info, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != body || info.ContentType != "image/jpeg" || info.Size != int64(len(body)) {
+		t.Errorf("Get = %q %#v", got, info)
+	}
+
+	if err := store.Delete(key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok := stub.objects[key]; ok {
+		t.Error("object was not deleted")
+	}
+	if _, _, err := store.Get(key); err != ErrNotFound {
+		t.Errorf("Get after delete: err = %v, want ErrNotFound", err)
+	}
+	// deleting a missing object is not an error.
+	if err := store.Delete(key); err != nil {
+		t.Errorf("Delete missing: %v", err)
+	}
+}
+
+func TestS3StoreRejectsInvalidKey(t *testing.T) {
+	store, srv, stub := newS3Stub(t)
+	defer srv.Close()
+
+	for _, key := range []string{"", "/abs", "dir/", "../escape"} {
+		if err := store.Put(key, strings.NewReader("x"), 1, "text/plain"); err != ErrInvalidKey {
+			t.Errorf("Put(%q): err = %v, want ErrInvalidKey", key, err)
+		}
+	}
+	if len(stub.objects) != 0 {
+		t.Errorf("stored %v", stub.objects)
+	}
+}
+
+func TestS3StoreReportsSignatureError(t *testing.T) {
+	store, srv, _ := newS3Stub(t)
+	defer srv.Close()
+
+	wrong := *store
+	wrong.SecretAccessKey = "other"
+	err := (&wrong).Put("photos/1/abc.jpg", strings.NewReader("x"), 1, "image/jpeg")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Put with a wrong key: err = %v", err)
+	}
+}
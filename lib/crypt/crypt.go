@@ -0,0 +1,66 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+// ErrEmptyKey 鍵が設定されていない
+var ErrEmptyKey = errors.New("crypt: encryption key is empty")
+
+// ErrInvalidCiphertext 復号できない値が渡された
+var ErrInvalidCiphertext = errors.New("crypt: invalid ciphertext")
+
+// Cipher 秘密情報をDBに保存するためのAES-256-GCMによる暗号化を行う
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher keyからCipherを作成する
+// keyは任意長の文字列で、SHA-256で256bitの鍵に変換して使用する
+func NewCipher(key string) (*Cipher, error) {
+	if key == "" {
+		return nil, ErrEmptyKey
+	}
+	k := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(k[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Encrypt plainを暗号化し、nonceを先頭に付けてbase64で返す
+func (c *Cipher) Encrypt(plain string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(plain), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt Encryptで暗号化された値を復号する
+func (c *Cipher) Decrypt(s string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+	n := c.aead.NonceSize()
+	if len(sealed) < n {
+		return "", ErrInvalidCiphertext
+	}
+	plain, err := c.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+	return string(plain), nil
+}
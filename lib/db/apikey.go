@@ -0,0 +1,65 @@
+package db
+
+import (
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// GetAllApiKeys apikeyテーブルからユーザの全てのキーを取得する
+func (mda *MysqlDbAccessor) GetAllApiKeys(uid int64) ([]model.ApiKey, error) {
+	var keys []model.ApiKey
+	_, err := mda.Db.Select(&keys,
+		"SELECT * FROM apikey WHERE uid = ? ORDER BY created", uid)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// GetApiKey apikeyテーブルからidに合致するキーを1つ返す
+func (mda *MysqlDbAccessor) GetApiKey(id, uid int64) (model.ApiKey, error) {
+	var k model.ApiKey
+	err := mda.Db.SelectOne(&k, "SELECT * FROM apikey WHERE id = ? AND uid = ?", id, uid)
+	if err != nil {
+		return model.ApiKey{}, err
+	}
+	return k, nil
+}
+
+// FindApiKeyByHash apikeyテーブルからハッシュに合致するキーを1つ返す
+func (mda *MysqlDbAccessor) FindApiKeyByHash(hash string) (model.ApiKey, error) {
+	var k model.ApiKey
+	err := mda.Db.SelectOne(&k, "SELECT * FROM apikey WHERE hash = ?", hash)
+	if err != nil {
+		return model.ApiKey{}, err
+	}
+	return k, nil
+}
+
+// AddApiKey apikeyテーブルへデータを1件追加する
+func (mda *MysqlDbAccessor) AddApiKey(key model.ApiKey) (model.ApiKey, error) {
+	err := mda.Db.Insert(&key)
+	if err != nil {
+		return model.ApiKey{}, err
+	}
+	return key, nil
+}
+
+// DeleteApiKey apikeyテーブルのデータを1件削除する
+func (mda *MysqlDbAccessor) DeleteApiKey(key model.ApiKey) error {
+	_, err := mda.Db.Delete(&key)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// TouchApiKey キーの最終利用日時を更新する
+func (mda *MysqlDbAccessor) TouchApiKey(id int64, t time.Time) error {
+	_, err := mda.Db.Exec("UPDATE apikey SET lastused = ? WHERE id = ?", t, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
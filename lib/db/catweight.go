@@ -0,0 +1,44 @@
+package db
+
+import (
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// GetCatWeights catweightテーブルからcatの全ての体重の記録を日付順に取得する
+func (mda *MysqlDbAccessor) GetCatWeights(catid, hid int64) ([]model.CatWeight, error) {
+	var ws []model.CatWeight
+	_, err := mda.Db.Select(&ws,
+		"SELECT * FROM catweight WHERE catid = ? AND householdid = ? ORDER BY date, id", catid, hid)
+	if err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+// GetCatWeight catweightテーブルからidに合致する記録を1つ返す
+func (mda *MysqlDbAccessor) GetCatWeight(id, hid int64) (model.CatWeight, error) {
+	var w model.CatWeight
+	err := mda.Db.SelectOne(&w, "SELECT * FROM catweight WHERE id = ? AND householdid = ?", id, hid)
+	if err != nil {
+		return model.CatWeight{}, err
+	}
+	return w, nil
+}
+
+// AddCatWeight catweightテーブルへデータを1件追加する
+func (mda *MysqlDbAccessor) AddCatWeight(w model.CatWeight) error {
+	err := mda.Db.Insert(&w)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteCatWeight catweightテーブルのデータを1件削除する
+func (mda *MysqlDbAccessor) DeleteCatWeight(w model.CatWeight) error {
+	_, err := mda.Db.Delete(&w)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"github.com/go-gorp/gorp"
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// GetHouseholdMemberships ユーザが所属する全てのhouseholdと役割を取得する
+func (mda *MysqlDbAccessor) GetHouseholdMemberships(uid int64) ([]model.HouseholdMembership, error) {
+	var ms []model.HouseholdMembership
+	_, err := mda.Db.Select(&ms,
+		`SELECT h.id AS householdid, h.name AS name, m.role AS role
+		FROM householdmember m JOIN household h ON h.id = m.householdid
+		WHERE m.uid = ? ORDER BY m.created`, uid)
+	if err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// GetHouseholdMember householdに所属するユーザの情報を取得する
+// 所属していない場合は空のHouseholdMemberとerrorを返す
+func (mda *MysqlDbAccessor) GetHouseholdMember(hid, uid int64) (model.HouseholdMember, error) {
+	var m model.HouseholdMember
+	err := mda.Db.SelectOne(&m,
+		"SELECT * FROM householdmember WHERE householdid = ? AND uid = ?", hid, uid)
+	if err != nil {
+		return model.HouseholdMember{}, err
+	}
+	return m, nil
+}
+
+// GetDefaultHouseholdMember ユーザが最初に所属したhouseholdの情報を取得する
+func (mda *MysqlDbAccessor) GetDefaultHouseholdMember(uid int64) (model.HouseholdMember, error) {
+	var m model.HouseholdMember
+	err := mda.Db.SelectOne(&m,
+		"SELECT * FROM householdmember WHERE uid = ? ORDER BY created, id LIMIT 1", uid)
+	if err != nil {
+		return model.HouseholdMember{}, err
+	}
+	return m, nil
+}
+
+// GetHouseholdMembers householdに所属する全てのユーザを取得する
+func (mda *MysqlDbAccessor) GetHouseholdMembers(hid int64) ([]model.HouseholdMember, error) {
+	var ms []model.HouseholdMember
+	_, err := mda.Db.Select(&ms,
+		"SELECT * FROM householdmember WHERE householdid = ? ORDER BY created", hid)
+	if err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// CountHouseholdOwners householdのownerの人数を返す
+func (mda *MysqlDbAccessor) CountHouseholdOwners(hid int64) (int64, error) {
+	return mda.Db.SelectInt(
+		"SELECT COUNT(*) FROM householdmember WHERE householdid = ? AND role = ?", hid, model.RoleOwner)
+}
+
+// AddHousehold householdを作成し、ownerとしてユーザを所属させる
+func (mda *MysqlDbAccessor) AddHousehold(h model.Household, owner int64) (model.Household, error) {
+	tx, err := mda.Db.Begin()
+	if err != nil {
+		return model.Household{}, err
+	}
+	if err := tx.Insert(&h); err != nil {
+		tx.Rollback()
+		return model.Household{}, err
+	}
+	m := model.HouseholdMember{HouseholdId: h.Id, UID: owner, Role: model.RoleOwner}
+	if err := tx.Insert(&m); err != nil {
+		tx.Rollback()
+		return model.Household{}, err
+	}
+	return h, tx.Commit()
+}
+
+// AddHouseholdMember householdmemberテーブルへデータを1件追加する
+func (mda *MysqlDbAccessor) AddHouseholdMember(m model.HouseholdMember) error {
+	err := mda.Db.Insert(&m)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateHouseholdMember householdmemberテーブルのデータを1件更新する
+func (mda *MysqlDbAccessor) UpdateHouseholdMember(m model.HouseholdMember) error {
+	_, err := mda.Db.Update(&m)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteHouseholdMember householdmemberテーブルのデータを1件削除する
+func (mda *MysqlDbAccessor) DeleteHouseholdMember(m model.HouseholdMember) error {
+	_, err := mda.Db.Delete(&m)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// AddHouseholdInvitation householdinvitationテーブルへデータを1件追加する
+func (mda *MysqlDbAccessor) AddHouseholdInvitation(inv model.HouseholdInvitation) error {
+	err := mda.Db.Insert(&inv)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// AcceptHouseholdInvitation 招待コードを使用してユーザをhouseholdに所属させる
+// 招待コードは1度しか使用できない
+func (mda *MysqlDbAccessor) AcceptHouseholdInvitation(code string, uid int64) (model.HouseholdMember, error) {
+	tx, err := mda.Db.Begin()
+	if err != nil {
+		return model.HouseholdMember{}, err
+	}
+	var inv model.HouseholdInvitation
+	err = tx.SelectOne(&inv,
+		"SELECT * FROM householdinvitation WHERE code = ? AND expires > NOW() FOR UPDATE", code)
+	if err != nil {
+		tx.Rollback()
+		return model.HouseholdMember{}, err
+	}
+	if _, err := tx.Delete(&inv); err != nil {
+		tx.Rollback()
+		return model.HouseholdMember{}, err
+	}
+	m := model.HouseholdMember{HouseholdId: inv.HouseholdId, UID: uid, Role: inv.Role}
+	if err := tx.Insert(&m); err != nil {
+		tx.Rollback()
+		return model.HouseholdMember{}, err
+	}
+	return m, tx.Commit()
+}
+
+// addPersonalHousehold ユーザ個人用のhouseholdを作成する
+func addPersonalHousehold(s gorp.SqlExecutor, user model.User) (model.Household, error) {
+	h := model.Household{Name: user.Name}
+	if err := s.Insert(&h); err != nil {
+		return model.Household{}, err
+	}
+	m := model.HouseholdMember{HouseholdId: h.Id, UID: user.Id, Role: model.RoleOwner}
+	if err := s.Insert(&m); err != nil {
+		return model.Household{}, err
+	}
+	return h, nil
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// GetFeedings feedingテーブルから条件に合う食事の記録を取得する
+func (mda *MysqlDbAccessor) GetFeedings(hid int64, f model.RecordFilter) ([]model.Feeding, error) {
+	var fs []model.Feeding
+	where, args := recordWhere(hid, f, "occurredat")
+	_, err := mda.Db.Select(&fs, "SELECT * FROM feeding WHERE "+where+" ORDER BY occurredat", args...)
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// GetFeeding feedingテーブルからidに合致する食事の記録を1つ返す
+func (mda *MysqlDbAccessor) GetFeeding(id, hid int64) (model.Feeding, error) {
+	var f model.Feeding
+	err := mda.Db.SelectOne(&f, "SELECT * FROM feeding WHERE id = ? AND householdid = ?", id, hid)
+	if err != nil {
+		return model.Feeding{}, err
+	}
+	return f, nil
+}
+
+// AddFeeding feedingテーブルへデータを1件追加する
+func (mda *MysqlDbAccessor) AddFeeding(f model.Feeding) error {
+	err := mda.Db.Insert(&f)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateFeeding feedingテーブルのデータを1件更新する
+func (mda *MysqlDbAccessor) UpdateFeeding(f model.Feeding) error {
+	_, err := mda.Db.Update(&f)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteFeeding feedingテーブルのデータを1件削除する
+func (mda *MysqlDbAccessor) DeleteFeeding(f model.Feeding) error {
+	_, err := mda.Db.Delete(&f)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetWaterIntakes waterintakeテーブルから条件に合う飲水の記録を取得する
+func (mda *MysqlDbAccessor) GetWaterIntakes(hid int64, f model.RecordFilter) ([]model.WaterIntake, error) {
+	var ws []model.WaterIntake
+	where, args := recordWhere(hid, f, "occurredat")
+	_, err := mda.Db.Select(&ws, "SELECT * FROM waterintake WHERE "+where+" ORDER BY occurredat", args...)
+	if err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+// GetWaterIntake waterintakeテーブルからidに合致する飲水の記録を1つ返す
+func (mda *MysqlDbAccessor) GetWaterIntake(id, hid int64) (model.WaterIntake, error) {
+	var w model.WaterIntake
+	err := mda.Db.SelectOne(&w, "SELECT * FROM waterintake WHERE id = ? AND householdid = ?", id, hid)
+	if err != nil {
+		return model.WaterIntake{}, err
+	}
+	return w, nil
+}
+
+// AddWaterIntake waterintakeテーブルへデータを1件追加する
+func (mda *MysqlDbAccessor) AddWaterIntake(w model.WaterIntake) error {
+	err := mda.Db.Insert(&w)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateWaterIntake waterintakeテーブルのデータを1件更新する
+func (mda *MysqlDbAccessor) UpdateWaterIntake(w model.WaterIntake) error {
+	_, err := mda.Db.Update(&w)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteWaterIntake waterintakeテーブルのデータを1件削除する
+func (mda *MysqlDbAccessor) DeleteWaterIntake(w model.WaterIntake) error {
+	_, err := mda.Db.Delete(&w)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// GetJobs jobテーブルから全ての定期処理の実行状況を取得する
+func (mda *MysqlDbAccessor) GetJobs() ([]model.Job, error) {
+	var js []model.Job
+	_, err := mda.Db.Select(&js, "SELECT * FROM job ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	return js, nil
+}
+
+// RegisterJob 定期処理をjobテーブルに登録する
+// 既に登録されていて予定が変わった場合は、次回の実行時刻をnextRunに変える
+func (mda *MysqlDbAccessor) RegisterJob(name, schedule string, nextRun time.Time) error {
+	var j model.Job
+	err := mda.Db.SelectOne(&j, "SELECT * FROM job WHERE name = ?", name)
+	if err == sql.ErrNoRows {
+		err := mda.Db.Insert(&model.Job{Name: name, Schedule: schedule, NextRun: nextRun})
+		if isDupEntry(err) {
+			// another server has registered it at the same time.
+			return nil
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if j.Schedule == schedule {
+		return nil
+	}
+	_, err = mda.Db.Exec("UPDATE job SET schedule = ?, nextrun = ? WHERE name = ?", schedule, nextRun, name)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// AcquireJob 実行時刻がnow以前で、他のサーバが実行中でない定期処理の実行権を得る
+// 実行権はownerがuntilまで持ち、次回の実行時刻はnextRunになる
+// 実行権を得られなかった場合はfalseを返す
+func (mda *MysqlDbAccessor) AcquireJob(name, owner string, now, until, nextRun time.Time) (bool, error) {
+	res, err := mda.Db.Exec(`UPDATE job SET lockowner = ?, lockeduntil = ?, nextrun = ?, laststatus = ?
+		WHERE name = ? AND nextrun <= ? AND (lockeduntil IS NULL OR lockeduntil < ?)`,
+		owner, until, nextRun, model.JobStatusRunning, name, now, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// FinishJob ownerが実行した定期処理の結果を記録し、実行権を手放す
+func (mda *MysqlDbAccessor) FinishJob(name, owner string, started time.Time, duration time.Duration, status, lastError string) error {
+	_, err := mda.Db.Exec(`UPDATE job SET lastrun = ?, laststatus = ?, lasterror = ?, durationms = ?,
+		lockowner = '', lockeduntil = NULL WHERE name = ? AND lockowner = ?`,
+		started, status, lastError, int64(duration/time.Millisecond), name, owner)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// PurgeNotifications notificationテーブルからbefore以前に作られた送信済み、送信失敗の通知を削除する
+func (mda *MysqlDbAccessor) PurgeNotifications(before time.Time) (int64, error) {
+	res, err := mda.Db.Exec("DELETE FROM notification WHERE status <> ? AND created < ?",
+		model.NotificationStatusPending, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-gorp/gorp"
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// useLitter 砂を入れる掃除の場合、入れた量を砂の在庫から減らし、減らした量をwashのLitterTakenにする
+// 在庫より多く入れた場合は在庫の分だけ減らす
+// washで砂の在庫を指定しない場合はtoiletの砂の在庫を使い、washに記録する
+func useLitter(s gorp.SqlExecutor, wash *model.Wash) error {
+	wash.LitterTaken = 0
+	if !wash.AddsLitter() {
+		return nil
+	}
+	if wash.LitterId == 0 && wash.ToiletId != 0 {
+		id, err := s.SelectNullInt("SELECT litterid FROM toilet WHERE id = ? AND householdid = ?",
+			wash.ToiletId, wash.HouseholdId)
+		if err != nil {
+			return err
+		}
+		wash.LitterId = id.Int64
+	}
+	if wash.LitterId == 0 || wash.LitterGrams == 0 {
+		return nil
+	}
+	stock, err := s.SelectNullInt("SELECT stockgrams FROM litter WHERE id = ? AND householdid = ? FOR UPDATE",
+		wash.LitterId, wash.HouseholdId)
+	if err != nil {
+		return err
+	}
+	taken := wash.LitterGrams
+	if taken > stock.Int64 {
+		taken = stock.Int64
+	}
+	if taken <= 0 {
+		return nil
+	}
+	_, err = s.Exec(`UPDATE litter SET stockgrams = stockgrams - ?, updated = ?
+		WHERE id = ? AND householdid = ?`, taken, time.Now(), wash.LitterId, wash.HouseholdId)
+	if err != nil {
+		return err
+	}
+	wash.LitterTaken = taken
+	return nil
+}
+
+// restoreLitter useLitterで砂の在庫から減らした量を在庫に戻す
+// 掃除を更新、削除する際に使う
+func restoreLitter(s gorp.SqlExecutor, wash model.Wash) error {
+	if wash.LitterId == 0 || wash.LitterTaken <= 0 {
+		return nil
+	}
+	_, err := s.Exec(`UPDATE litter SET stockgrams = stockgrams + ?, updated = ?
+		WHERE id = ? AND householdid = ?`, wash.LitterTaken, time.Now(), wash.LitterId, wash.HouseholdId)
+	return err
+}
+
+// GetLitters litterテーブルからhouseholdの砂の在庫を全て取得する
+func (mda *MysqlDbAccessor) GetLitters(hid int64) ([]model.Litter, error) {
+	var ls []model.Litter
+	_, err := mda.Db.Select(&ls, "SELECT * FROM litter WHERE householdid = ? ORDER BY id", hid)
+	if err != nil {
+		return nil, err
+	}
+	return ls, nil
+}
+
+// GetLitter litterテーブルからidに合致するhouseholdの砂の在庫を1つ返す
+func (mda *MysqlDbAccessor) GetLitter(id, hid int64) (model.Litter, error) {
+	var l model.Litter
+	err := mda.Db.SelectOne(&l, "SELECT * FROM litter WHERE id = ? AND householdid = ?", id, hid)
+	if err != nil {
+		return model.Litter{}, err
+	}
+	return l, nil
+}
+
+// AddLitter litterテーブルへデータを1件追加する
+func (mda *MysqlDbAccessor) AddLitter(l model.Litter) error {
+	err := mda.Db.Insert(&l)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateLitter litterテーブルのデータを1件更新する
+func (mda *MysqlDbAccessor) UpdateLitter(l model.Litter) error {
+	_, err := mda.Db.Update(&l)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteLitter litterテーブルのデータを1件削除し、その砂を使うtoiletの設定を外す
+func (mda *MysqlDbAccessor) DeleteLitter(l model.Litter) error {
+	tx, err := mda.Db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE toilet SET litterid = 0 WHERE litterid = ? AND householdid = ?", l.Id, l.HouseholdId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Delete(&l); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// RestockLitter 砂の在庫をgrams増やし、補充した日時をnowにする
+func (mda *MysqlDbAccessor) RestockLitter(id, hid, grams int64, now time.Time) error {
+	_, err := mda.Db.Exec(`UPDATE litter SET stockgrams = stockgrams + ?, restocked = ?, updated = ?
+		WHERE id = ? AND householdid = ?`, grams, now, now, id, hid)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetLitterUsage washテーブルからsince以降に砂の在庫ごとに入れた砂の量を集計する
+func (mda *MysqlDbAccessor) GetLitterUsage(hid int64, since time.Time) ([]model.LitterUsage, error) {
+	args := []interface{}{hid, since}
+	for _, k := range model.LitterWashKinds {
+		args = append(args, k)
+	}
+	marks := strings.TrimSuffix(strings.Repeat("?, ", len(model.LitterWashKinds)), ", ")
+	var us []model.LitterUsage
+	_, err := mda.Db.Select(&us, `SELECT litterid, SUM(littergrams) AS grams FROM wash
+		WHERE householdid = ? AND created >= ? AND litterid <> 0 AND kind IN (`+marks+`)
+		GROUP BY litterid`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return us, nil
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-gorp/gorp"
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// fakeLitterExecutor useLitterとrestoreLitterが発行するクエリにだけ応答するSqlExecutor
+// 砂の在庫とtoiletの砂の在庫の設定をメモリに持つ
+type fakeLitterExecutor struct {
+	gorp.SqlExecutor
+	stock   map[int64]int64
+	toilets map[int64]int64
+}
+
+func (f *fakeLitterExecutor) SelectNullInt(query string, args ...interface{}) (sql.NullInt64, error) {
+	id := args[0].(int64)
+	switch {
+	case strings.HasPrefix(query, "SELECT litterid FROM toilet"):
+		l, ok := f.toilets[id]
+		return sql.NullInt64{Int64: l, Valid: ok}, nil
+	case strings.HasPrefix(query, "SELECT stockgrams FROM litter"):
+		g, ok := f.stock[id]
+		return sql.NullInt64{Int64: g, Valid: ok}, nil
+	}
+	return sql.NullInt64{}, fmt.Errorf("unexpected query %q", query)
+}
+
+func (f *fakeLitterExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	grams, id := args[0].(int64), args[2].(int64)
+	switch {
+	case strings.HasPrefix(query, "UPDATE litter SET stockgrams = stockgrams - ?"):
+		f.stock[id] -= grams
+	case strings.HasPrefix(query, "UPDATE litter SET stockgrams = stockgrams + ?"):
+		f.stock[id] += grams
+	default:
+		return nil, fmt.Errorf("unexpected query %q", query)
+	}
+	return nil, nil
+}
+
+// updateLitter UpdateWashと同じ順に、oldで減らした量を戻してからwashの量を減らす
+func updateLitter(t *testing.T, f *fakeLitterExecutor, old model.Wash, wash *model.Wash) {
+	if err := restoreLitter(f, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := useLitter(f, wash); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUseLitterMoreThanStock(t *testing.T) {
+	f := &fakeLitterExecutor{stock: map[int64]int64{1: 500, 2: 3000}, toilets: map[int64]int64{10: 1}}
+	wash := model.Wash{HouseholdId: 1, ToiletId: 10, Kind: model.WashKindFullChange, LitterGrams: 2000}
+	if err := useLitter(f, &wash); err != nil {
+		t.Fatal(err)
+	}
+	if wash.LitterId != 1 || wash.LitterTaken != 500 || f.stock[1] != 0 {
+		t.Fatalf("after adding: litter %d taken %d, stock %d", wash.LitterId, wash.LitterTaken, f.stock[1])
+	}
+
+	for _, tt := range []struct {
+		name      string
+		kind      string
+		grams     int64
+		litterid  int64
+		wantTaken int64
+		wantStock map[int64]int64
+	}{
+		{"less litter", model.WashKindFullChange, 300, 1, 300, map[int64]int64{1: 200, 2: 3000}},
+		{"more litter again", model.WashKindFullChange, 2000, 1, 500, map[int64]int64{1: 0, 2: 3000}},
+		{"other litter", model.WashKindFullChange, 2000, 2, 2000, map[int64]int64{1: 500, 2: 1000}},
+		{"scoop only", model.WashKindScoop, 2000, 2, 0, map[int64]int64{1: 500, 2: 3000}},
+		{"top up the first litter", model.WashKindTopUp, 700, 1, 500, map[int64]int64{1: 0, 2: 3000}},
+	} {
+		old := wash
+		wash.Kind, wash.LitterGrams, wash.LitterId = tt.kind, tt.grams, tt.litterid
+		updateLitter(t, f, old, &wash)
+		if wash.LitterTaken != tt.wantTaken {
+			t.Errorf("%s: taken = %d, want %d", tt.name, wash.LitterTaken, tt.wantTaken)
+		}
+		for id, want := range tt.wantStock {
+			if f.stock[id] != want {
+				t.Errorf("%s: stock of litter %d = %d, want %d", tt.name, id, f.stock[id], want)
+			}
+		}
+	}
+
+	// deleting restores only what was taken.
+	if err := restoreLitter(f, wash); err != nil {
+		t.Fatal(err)
+	}
+	if f.stock[1] != 500 || f.stock[2] != 3000 {
+		t.Errorf("after deleting: stock = %v, want 500 and 3000", f.stock)
+	}
+}
+
+func TestUseLitterWithoutStock(t *testing.T) {
+	f := &fakeLitterExecutor{stock: map[int64]int64{1: 0}, toilets: map[int64]int64{10: 0}}
+	for _, wash := range []model.Wash{
+		{ToiletId: 10, Kind: model.WashKindTopUp, LitterGrams: 500},
+		{ToiletId: 10, Kind: model.WashKindTopUp, LitterGrams: 500, LitterId: 1},
+		{ToiletId: 10, Kind: model.WashKindTopUp, LitterGrams: 500, LitterId: 9},
+	} {
+		wash.LitterTaken = 100
+		if err := useLitter(f, &wash); err != nil {
+			t.Fatal(err)
+		}
+		if wash.LitterTaken != 0 {
+			t.Errorf("litter %d: taken = %d, want 0", wash.LitterId, wash.LitterTaken)
+		}
+		if err := restoreLitter(f, wash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if f.stock[1] != 0 {
+		t.Errorf("stock = %d, want 0", f.stock[1])
+	}
+}
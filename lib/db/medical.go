@@ -0,0 +1,198 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// recordWhere RecordFilterからWHERE句の条件とパラメータを組み立てる
+func recordWhere(hid int64, f model.RecordFilter, dateColumn string) (string, []interface{}) {
+	conds := []string{"householdid = ?"}
+	args := []interface{}{hid}
+	if f.CatId != 0 {
+		conds = append(conds, "catid = ?")
+		args = append(args, f.CatId)
+	}
+	if f.From != nil {
+		conds = append(conds, dateColumn+" >= ?")
+		args = append(args, *f.From)
+	}
+	if f.To != nil {
+		conds = append(conds, dateColumn+" < ?")
+		args = append(args, *f.To)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// GetVetVisits vetvisitテーブルから条件に合う受診記録を取得する
+func (mda *MysqlDbAccessor) GetVetVisits(hid int64, f model.RecordFilter) ([]model.VetVisit, error) {
+	var vs []model.VetVisit
+	where, args := recordWhere(hid, f, "date")
+	_, err := mda.Db.Select(&vs, "SELECT * FROM vetvisit WHERE "+where+" ORDER BY date DESC, id DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	return vs, nil
+}
+
+// GetVetVisit vetvisitテーブルからidに合致する受診記録を1つ返す
+func (mda *MysqlDbAccessor) GetVetVisit(id, hid int64) (model.VetVisit, error) {
+	var v model.VetVisit
+	err := mda.Db.SelectOne(&v, "SELECT * FROM vetvisit WHERE id = ? AND householdid = ?", id, hid)
+	if err != nil {
+		return model.VetVisit{}, err
+	}
+	return v, nil
+}
+
+// AddVetVisit vetvisitテーブルへデータを1件追加する
+func (mda *MysqlDbAccessor) AddVetVisit(v model.VetVisit) error {
+	err := mda.Db.Insert(&v)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateVetVisit vetvisitテーブルのデータを1件更新する
+func (mda *MysqlDbAccessor) UpdateVetVisit(v model.VetVisit) error {
+	_, err := mda.Db.Update(&v)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteVetVisit vetvisitテーブルのデータを1件削除する
+func (mda *MysqlDbAccessor) DeleteVetVisit(v model.VetVisit) error {
+	_, err := mda.Db.Delete(&v)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetVaccinations vaccinationテーブルから条件に合う接種記録を取得する
+func (mda *MysqlDbAccessor) GetVaccinations(hid int64, f model.RecordFilter) ([]model.Vaccination, error) {
+	var vs []model.Vaccination
+	where, args := recordWhere(hid, f, "date")
+	_, err := mda.Db.Select(&vs, "SELECT * FROM vaccination WHERE "+where+" ORDER BY date DESC, id DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	return vs, nil
+}
+
+// GetUpcomingVaccinations 次回接種日がuntilより前の接種記録を取得する
+// 同じcatの同じワクチンは最新の接種記録のみを対象にする
+func (mda *MysqlDbAccessor) GetUpcomingVaccinations(hid int64, until time.Time) ([]model.Vaccination, error) {
+	var vs []model.Vaccination
+	_, err := mda.Db.Select(&vs, `SELECT v.* FROM vaccination v
+		JOIN cat c ON c.id = v.catid AND c.householdid = v.householdid
+		WHERE v.householdid = ? AND v.nextdue IS NOT NULL AND v.nextdue < ?
+		AND NOT EXISTS (
+			SELECT 1 FROM vaccination n
+			WHERE n.catid = v.catid AND n.vaccine = v.vaccine
+			AND (n.date > v.date OR (n.date = v.date AND n.id > v.id))
+		)
+		ORDER BY v.nextdue`, hid, until)
+	if err != nil {
+		return nil, err
+	}
+	return vs, nil
+}
+
+// GetVaccination vaccinationテーブルからidに合致する接種記録を1つ返す
+func (mda *MysqlDbAccessor) GetVaccination(id, hid int64) (model.Vaccination, error) {
+	var v model.Vaccination
+	err := mda.Db.SelectOne(&v, "SELECT * FROM vaccination WHERE id = ? AND householdid = ?", id, hid)
+	if err != nil {
+		return model.Vaccination{}, err
+	}
+	return v, nil
+}
+
+// AddVaccination vaccinationテーブルへデータを1件追加する
+func (mda *MysqlDbAccessor) AddVaccination(v model.Vaccination) error {
+	err := mda.Db.Insert(&v)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateVaccination vaccinationテーブルのデータを1件更新する
+func (mda *MysqlDbAccessor) UpdateVaccination(v model.Vaccination) error {
+	_, err := mda.Db.Update(&v)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteVaccination vaccinationテーブルのデータを1件削除する
+func (mda *MysqlDbAccessor) DeleteVaccination(v model.Vaccination) error {
+	_, err := mda.Db.Delete(&v)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetConditions catconditionテーブルから条件に合う持病、アレルギーを取得する
+// kindが空でなければ種類で、activeOnlyがtrueなら継続中のもので絞り込む
+func (mda *MysqlDbAccessor) GetConditions(hid int64, f model.RecordFilter, kind string, activeOnly bool) ([]model.Condition, error) {
+	var cs []model.Condition
+	where, args := recordWhere(hid, f, "diagnosed")
+	if kind != "" {
+		where += " AND kind = ?"
+		args = append(args, kind)
+	}
+	if activeOnly {
+		where += " AND resolved IS NULL"
+	}
+	_, err := mda.Db.Select(&cs, "SELECT * FROM catcondition WHERE "+where+" ORDER BY created", args...)
+	if err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// GetCondition catconditionテーブルからidに合致するデータを1つ返す
+func (mda *MysqlDbAccessor) GetCondition(id, hid int64) (model.Condition, error) {
+	var cd model.Condition
+	err := mda.Db.SelectOne(&cd, "SELECT * FROM catcondition WHERE id = ? AND householdid = ?", id, hid)
+	if err != nil {
+		return model.Condition{}, err
+	}
+	return cd, nil
+}
+
+// AddCondition catconditionテーブルへデータを1件追加する
+func (mda *MysqlDbAccessor) AddCondition(cd model.Condition) error {
+	err := mda.Db.Insert(&cd)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateCondition catconditionテーブルのデータを1件更新する
+func (mda *MysqlDbAccessor) UpdateCondition(cd model.Condition) error {
+	_, err := mda.Db.Update(&cd)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteCondition catconditionテーブルのデータを1件削除する
+func (mda *MysqlDbAccessor) DeleteCondition(cd model.Condition) error {
+	_, err := mda.Db.Delete(&cd)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// GetMedications medicationテーブルから条件に合う投薬計画を取得する
+// activeがnilでなければその時点で継続中の計画のみを返す
+// 削除されたcatの計画は返さない
+func (mda *MysqlDbAccessor) GetMedications(hid int64, f model.RecordFilter, active *time.Time) ([]model.Medication, error) {
+	var ms []model.Medication
+	where, args := recordWhere(hid, f, "startdate")
+	where += " AND catid IN (SELECT id FROM cat WHERE householdid = ?)"
+	args = append(args, hid)
+	if active != nil {
+		where += " AND startdate <= ? AND (enddate IS NULL OR enddate > ?)"
+		args = append(args, *active, *active)
+	}
+	_, err := mda.Db.Select(&ms, "SELECT * FROM medication WHERE "+where+" ORDER BY startdate, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// GetMedication medicationテーブルからidに合致する投薬計画を1つ返す
+func (mda *MysqlDbAccessor) GetMedication(id, hid int64) (model.Medication, error) {
+	var m model.Medication
+	err := mda.Db.SelectOne(&m, "SELECT * FROM medication WHERE id = ? AND householdid = ?", id, hid)
+	if err != nil {
+		return model.Medication{}, err
+	}
+	return m, nil
+}
+
+// AddMedication medicationテーブルへデータを1件追加する
+func (mda *MysqlDbAccessor) AddMedication(m model.Medication) error {
+	err := mda.Db.Insert(&m)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateMedication medicationテーブルのデータを1件更新する
+func (mda *MysqlDbAccessor) UpdateMedication(m model.Medication) error {
+	_, err := mda.Db.Update(&m)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteMedication 投薬計画をその投与記録とともに削除する
+func (mda *MysqlDbAccessor) DeleteMedication(m model.Medication) error {
+	tx, err := mda.Db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM medicationdose WHERE medicationid = ?", m.Id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Delete(&m); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetMedicationDoses medicationdoseテーブルから投薬計画の投与記録を予定時刻順に取得する
+func (mda *MysqlDbAccessor) GetMedicationDoses(medid, hid int64, f model.RecordFilter) ([]model.MedicationDose, error) {
+	var ds []model.MedicationDose
+	where, args := recordWhere(hid, f, "scheduled")
+	where += " AND medicationid = ?"
+	args = append(args, medid)
+	_, err := mda.Db.Select(&ds, "SELECT * FROM medicationdose WHERE "+where+" ORDER BY scheduled", args...)
+	if err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// GetDosesSince 世帯の予定時刻がsince以降の全ての投与記録を取得する
+func (mda *MysqlDbAccessor) GetDosesSince(hid int64, since time.Time) ([]model.MedicationDose, error) {
+	var ds []model.MedicationDose
+	_, err := mda.Db.Select(&ds,
+		"SELECT * FROM medicationdose WHERE householdid = ? AND scheduled >= ?", hid, since)
+	if err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// GetMedicationDose medicationdoseテーブルから予定時刻に合致する投与記録を1つ返す
+func (mda *MysqlDbAccessor) GetMedicationDose(medid int64, scheduled time.Time) (model.MedicationDose, error) {
+	var d model.MedicationDose
+	err := mda.Db.SelectOne(&d,
+		"SELECT * FROM medicationdose WHERE medicationid = ? AND scheduled = ?", medid, scheduled)
+	if err != nil {
+		return model.MedicationDose{}, err
+	}
+	return d, nil
+}
+
+// AddMedicationDose medicationdoseテーブルへデータを1件追加する
+func (mda *MysqlDbAccessor) AddMedicationDose(d model.MedicationDose) error {
+	err := mda.Db.Insert(&d)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateMedicationDose medicationdoseテーブルのデータを1件更新する
+func (mda *MysqlDbAccessor) UpdateMedicationDose(d model.MedicationDose) error {
+	_, err := mda.Db.Update(&d)
+	if err != nil {
+		return err
+	}
+	return nil
+}
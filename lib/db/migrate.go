@@ -0,0 +1,270 @@
+package db
+
+import (
+	"github.com/go-gorp/gorp"
+	"github.com/go-sql-driver/mysql"
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// migration CreateTablesIfNotExistsでは反映されない既存テーブルへの変更
+// Stmtsは新規にテーブルを作成した環境でも実行されるため、
+// 既に反映済みの場合のエラー(カラム重複等)は無視する
+// FuncはStmtsの後に同じtransactionで実行する
+type migration struct {
+	Id    string
+	Stmts []string
+	Func  func(s gorp.SqlExecutor) error
+}
+
+var migrations = []migration{
+	{
+		Id: "0001_user_totp",
+		Stmts: []string{
+			"ALTER TABLE user ADD COLUMN totpsecret varchar(400)",
+			"ALTER TABLE user ADD COLUMN totpenabled boolean",
+		},
+	},
+	{
+		Id: "0002_households",
+		Stmts: []string{
+			"ALTER TABLE cat ADD COLUMN householdid bigint NOT NULL DEFAULT 0",
+			"ALTER TABLE toilet ADD COLUMN householdid bigint NOT NULL DEFAULT 0",
+			"ALTER TABLE usetoilet ADD COLUMN householdid bigint NOT NULL DEFAULT 0",
+			"ALTER TABLE wash ADD COLUMN householdid bigint NOT NULL DEFAULT 0",
+		},
+		Func: migratePersonalHouseholds,
+	},
+	{
+		Id: "0003_user_role",
+		Stmts: []string{
+			"ALTER TABLE user ADD COLUMN role varchar(50) NOT NULL DEFAULT 'user'",
+		},
+	},
+	{
+		Id: "0004_user_disabled",
+		Stmts: []string{
+			"ALTER TABLE user ADD COLUMN disabled boolean NOT NULL DEFAULT 0",
+			"ALTER TABLE user ADD COLUMN logoutat bigint NOT NULL DEFAULT 0",
+		},
+	},
+	{
+		Id: "0005_cat_birthdate",
+		Stmts: []string{
+			"ALTER TABLE cat ADD COLUMN birthdate datetime NULL",
+			"ALTER TABLE cat ADD COLUMN birthdateestimated boolean NOT NULL DEFAULT 0",
+		},
+		Func: migrateCatAge,
+	},
+	{
+		Id: "0006_user_timezone",
+		Stmts: []string{
+			"ALTER TABLE user ADD COLUMN timezone varchar(100)",
+		},
+	},
+	{
+		Id: "0007_cat_photo",
+		Stmts: []string{
+			"ALTER TABLE cat ADD COLUMN photoid bigint NOT NULL DEFAULT 0",
+		},
+	},
+	{
+		Id: "0008_usetoilet_observation",
+		Stmts: []string{
+			"ALTER TABLE usetoilet ADD COLUMN stoolscore int NULL",
+			"ALTER TABLE usetoilet ADD COLUMN color varchar(50)",
+			"ALTER TABLE usetoilet ADD COLUMN blood boolean NULL",
+			"ALTER TABLE usetoilet ADD COLUMN mucus boolean NULL",
+			"ALTER TABLE usetoilet ADD COLUMN clumpsize varchar(50)",
+			"ALTER TABLE usetoilet ADD COLUMN straining boolean NULL",
+			"ALTER TABLE usetoilet ADD COLUMN durationsec int NULL",
+		},
+	},
+	{
+		Id: "0009_toilet_usagecount",
+		Stmts: []string{
+			"ALTER TABLE toilet ADD COLUMN usagecount int NOT NULL DEFAULT 0",
+			// sandstate was free text; start every toilet from a known state.
+			"UPDATE toilet SET sandstate = 'clean' WHERE sandstate IS NULL OR sandstate NOT IN ('clean', 'used', 'dirty')",
+		},
+	},
+	{
+		Id: "0010_wash_kind",
+		Stmts: []string{
+			"ALTER TABLE wash ADD COLUMN kind varchar(50) NOT NULL DEFAULT 'scoop'",
+			"ALTER TABLE wash ADD COLUMN littergrams bigint NOT NULL DEFAULT 0",
+			"ALTER TABLE toilet ADD COLUMN sandchanged datetime NULL",
+		},
+	},
+	{
+		Id: "0011_toilet_cleaning_policy",
+		Stmts: []string{
+			"ALTER TABLE toilet ADD COLUMN scoophours int NOT NULL DEFAULT 0",
+			"ALTER TABLE toilet ADD COLUMN fullchangedays int NOT NULL DEFAULT 0",
+		},
+	},
+	{
+		Id: "0012_litter_inventory",
+		Stmts: []string{
+			"ALTER TABLE wash ADD COLUMN litterid bigint NOT NULL DEFAULT 0",
+			"ALTER TABLE toilet ADD COLUMN litterid bigint NOT NULL DEFAULT 0",
+		},
+	},
+	{
+		Id: "0013_user_totp_step",
+		Stmts: []string{
+			"ALTER TABLE user ADD COLUMN totpstep bigint NOT NULL DEFAULT 0",
+		},
+	},
+	{
+		Id: "0014_medication_timezone",
+		Stmts: []string{
+			"ALTER TABLE medication ADD COLUMN timezone varchar(100)",
+		},
+		Func: migrateMedicationTimeZone,
+	},
+	{
+		// records of deleted cats were left behind before DeleteCat removed them.
+		Id: "0015_purge_deleted_cat_records",
+		Stmts: []string{
+			"DELETE FROM vetvisit WHERE catid NOT IN (SELECT id FROM cat)",
+			"DELETE FROM vaccination WHERE catid NOT IN (SELECT id FROM cat)",
+			"DELETE FROM catcondition WHERE catid NOT IN (SELECT id FROM cat)",
+			"DELETE FROM catweight WHERE catid NOT IN (SELECT id FROM cat)",
+			"DELETE FROM feeding WHERE catid NOT IN (SELECT id FROM cat)",
+			"DELETE FROM waterintake WHERE catid NOT IN (SELECT id FROM cat)",
+		},
+	},
+	{
+		Id: "0016_purge_deleted_cat_medications",
+		Stmts: []string{
+			"DELETE FROM medicationdose WHERE catid NOT IN (SELECT id FROM cat)",
+			"DELETE FROM medication WHERE catid NOT IN (SELECT id FROM cat)",
+		},
+	},
+	{
+		Id: "0017_wash_litter_taken",
+		Stmts: []string{
+			"ALTER TABLE wash ADD COLUMN littertaken bigint NOT NULL DEFAULT 0",
+		},
+		Func: migrateWashLitterTaken,
+	},
+}
+
+// mysql error numbers which mean the statement was already applied
+var ignorableErrors = map[uint16]bool{
+	1060: true, // ER_DUP_FIELDNAME
+	1061: true, // ER_DUP_KEYNAME
+	1091: true, // ER_CANT_DROP_FIELD_OR_KEY
+}
+
+// Migrate 未適用のmigrationを順番に実行する
+// CreateTablesIfNotExistsの後に呼び出す
+func (mda *MysqlDbAccessor) Migrate() error {
+	_, err := mda.Db.Exec(`CREATE TABLE IF NOT EXISTS schema_migration (
+		id varchar(200) NOT NULL PRIMARY KEY,
+		applied datetime NOT NULL
+	) ENGINE=InnoDB DEFAULT CHARSET=UTF8`)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		n, err := mda.Db.SelectInt("SELECT COUNT(*) FROM schema_migration WHERE id = ?", m.Id)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		// mysql commits DDL implicitly, so only Func and the record share the transaction.
+		for _, stmt := range m.Stmts {
+			if _, err := mda.Db.Exec(stmt); err != nil && !isIgnorable(err) {
+				return err
+			}
+		}
+		tx, err := mda.Db.Begin()
+		if err != nil {
+			return err
+		}
+		if m.Func != nil {
+			if err := m.Func(tx); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migration (id, applied) VALUES (?, NOW())", m.Id); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isIgnorable(err error) bool {
+	me, ok := err.(*mysql.MySQLError)
+	return ok && ignorableErrors[me.Number]
+}
+
+// migratePersonalHouseholds householdに所属していないユーザに個人用のhouseholdを作成し、
+// ユーザのデータをそのhouseholdに移す
+func migratePersonalHouseholds(s gorp.SqlExecutor) error {
+	var users []model.User
+	_, err := s.Select(&users,
+		"SELECT * FROM user WHERE id NOT IN (SELECT uid FROM householdmember)")
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		h, err := addPersonalHousehold(s, u)
+		if err != nil {
+			return err
+		}
+		for _, table := range []string{"cat", "toilet", "usetoilet", "wash"} {
+			_, err := s.Exec("UPDATE "+table+" SET householdid = ? WHERE uid = ? AND householdid = 0", h.Id, u.Id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migrateCatAge 保存されていた年齢を推定の誕生日に変換し、ageカラムを削除する
+// 年齢は最後に更新された時点のものとみなす
+func migrateCatAge(s gorp.SqlExecutor) error {
+	n, err := s.SelectInt(`SELECT COUNT(*) FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = 'cat' AND column_name = 'age'`)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+	_, err = s.Exec(`UPDATE cat SET birthdate = DATE_SUB(updated, INTERVAL age YEAR), birthdateestimated = 1
+		WHERE birthdate IS NULL AND age > 0`)
+	if err != nil {
+		return err
+	}
+	_, err = s.Exec("ALTER TABLE cat DROP COLUMN age")
+	return err
+}
+
+// migrateMedicationTimeZone タイムゾーンの無い投薬計画に、登録したユーザのタイムゾーンを設定する
+// これまで予定は閲覧したユーザのタイムゾーンで解釈していたため、登録したユーザのものを引き継ぐ
+func migrateMedicationTimeZone(s gorp.SqlExecutor) error {
+	_, err := s.Exec(`UPDATE medication m JOIN user u ON u.id = m.uid SET m.timezone = u.timezone
+		WHERE m.timezone IS NULL OR m.timezone = ''`)
+	return err
+}
+
+// migrateWashLitterTaken これまでの砂を入れた掃除は、入れた量を全て砂の在庫から減らしたとみなす
+// 在庫が足りずに減らせなかった量は分からないため、戻す量が多くなりうる
+func migrateWashLitterTaken(s gorp.SqlExecutor) error {
+	_, err := s.Exec(`UPDATE wash SET littertaken = littergrams
+		WHERE litterid <> 0 AND kind IN (?, ?, ?)`,
+		model.LitterWashKinds[0], model.LitterWashKinds[1], model.LitterWashKinds[2])
+	return err
+}
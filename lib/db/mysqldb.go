@@ -2,6 +2,8 @@ package db
 
 import (
 	"database/sql"
+	"strings"
+	"time"
 
 	"github.com/go-gorp/gorp"
 	_ "github.com/go-sql-driver/mysql"
@@ -24,10 +26,10 @@ func NewMysqlDbAccessor(dsn string) (*MysqlDbAccessor, error) {
 }
 
 // GetAllCats DBからcatテーブルの全てのデータを取得する
-func (mda *MysqlDbAccessor) GetAllCats(uid int64) ([]model.Cat, error) {
+func (mda *MysqlDbAccessor) GetAllCats(hid int64) ([]model.Cat, error) {
 	var cats []model.Cat
 	_, err := mda.Db.Select(&cats,
-		"SELECT * FROM cat WHERE uid = ? ORDER BY created", uid)
+		"SELECT * FROM cat WHERE householdid = ? ORDER BY created", hid)
 	if err != nil {
 		return nil, err
 	}
@@ -36,9 +38,9 @@ func (mda *MysqlDbAccessor) GetAllCats(uid int64) ([]model.Cat, error) {
 
 // GetCat DBのcatテーブルからidに合致するcatを1つ返す
 // 見つからなかった場合は空のcatとerrorを返す
-func (mda *MysqlDbAccessor) GetCat(id, uid int64) (model.Cat, error) {
+func (mda *MysqlDbAccessor) GetCat(id, hid int64) (model.Cat, error) {
 	var cat model.Cat
-	err := mda.Db.SelectOne(&cat, "SELECT * FROM cat WHERE id = ? AND uid = ?", id, uid)
+	err := mda.Db.SelectOne(&cat, "SELECT * FROM cat WHERE id = ? AND householdid = ?", id, hid)
 	if err != nil {
 		return model.Cat{}, err
 	}
@@ -46,12 +48,13 @@ func (mda *MysqlDbAccessor) GetCat(id, uid int64) (model.Cat, error) {
 }
 
 // AddCat catテーブルへデータを1件追加する
-func (mda *MysqlDbAccessor) AddCat(cat model.Cat) error {
+// 追加したcatを返す
+func (mda *MysqlDbAccessor) AddCat(cat model.Cat) (model.Cat, error) {
 	err := mda.Db.Insert(&cat)
 	if err != nil {
-		return err
+		return model.Cat{}, err
 	}
-	return nil
+	return cat, nil
 }
 
 // UpdateCat catテーブルのデータを1件更新する
@@ -64,14 +67,28 @@ func (mda *MysqlDbAccessor) UpdateCat(cat model.Cat) error {
 }
 
 // DeleteCat catテーブルのデータを1件削除する
+// catの記録も削除する
 func (mda *MysqlDbAccessor) DeleteCat(cat model.Cat) error {
-	_, err := mda.Db.Delete(&cat)
+	tx, err := mda.Db.Begin()
 	if err != nil {
 		return err
 	}
-	return nil
+	for _, table := range catRecordTables {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE catid = ? AND householdid = ?", cat.Id, cat.HouseholdId); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.Delete(&cat); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
+// catRecordTables catを削除する際に一緒に削除するcatごとの記録のテーブル
+var catRecordTables = []string{"vetvisit", "vaccination", "catcondition", "catweight", "feeding", "waterintake", "medicationdose", "medication"}
+
 func newDbMap(dsn string) (*gorp.DbMap, error) {
 	db, err := open(dsn)
 	if err != nil {
@@ -90,10 +107,10 @@ func open(dsn string) (*sql.DB, error) {
 }
 
 // GetAllToilets DBからcatテーブルの全てのデータを取得する
-func (mda *MysqlDbAccessor) GetAllToilets(uid int64) ([]model.Toilet, error) {
+func (mda *MysqlDbAccessor) GetAllToilets(hid int64) ([]model.Toilet, error) {
 	var toilets []model.Toilet
 	_, err := mda.Db.Select(&toilets,
-		"SELECT * FROM toilet WHERE uid = ? ORDER BY created", uid)
+		"SELECT * FROM toilet WHERE householdid = ? ORDER BY created", hid)
 	if err != nil {
 		return nil, err
 	}
@@ -102,9 +119,9 @@ func (mda *MysqlDbAccessor) GetAllToilets(uid int64) ([]model.Toilet, error) {
 
 // GetToilet DBのtoiletテーブルからidに合致するtoiletを1つ返す
 // 見つからなかった場合は空のtoiletとerrorを返す
-func (mda *MysqlDbAccessor) GetToilet(id, uid int64) (model.Toilet, error) {
+func (mda *MysqlDbAccessor) GetToilet(id, hid int64) (model.Toilet, error) {
 	var toilet model.Toilet
-	err := mda.Db.SelectOne(&toilet, "SELECT * FROM toilet WHERE id = ? AND uid = ?", id, uid)
+	err := mda.Db.SelectOne(&toilet, "SELECT * FROM toilet WHERE id = ? AND householdid = ?", id, hid)
 	if err != nil {
 		return model.Toilet{}, err
 	}
@@ -130,30 +147,93 @@ func (mda *MysqlDbAccessor) UpdateToilet(toilet model.Toilet) error {
 }
 
 // DeleteToilet toiletテーブルのデータを1件削除する
+// 砂の状態の履歴も削除する
 func (mda *MysqlDbAccessor) DeleteToilet(toilet model.Toilet) error {
-	_, err := mda.Db.Delete(&toilet)
+	tx, err := mda.Db.Begin()
 	if err != nil {
 		return err
 	}
-	return nil
+	if _, err := tx.Exec("DELETE FROM sandstatetransition WHERE toiletid = ?", toilet.Id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Delete(&toilet); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 // GetAllUseToilets DBからcatテーブルの全てのデータを取得する
-func (mda *MysqlDbAccessor) GetAllUseToilets(uid int64) ([]model.UseToilet, error) {
+func (mda *MysqlDbAccessor) GetAllUseToilets(hid int64) ([]model.UseToilet, error) {
 	var usetoilets []model.UseToilet
 	_, err := mda.Db.Select(&usetoilets,
-		"SELECT * FROM usetoilet WHERE uid = ? ORDER BY created", uid)
+		"SELECT * FROM usetoilet WHERE householdid = ? ORDER BY created", hid)
 	if err != nil {
 		return nil, err
 	}
 	return usetoilets, nil
 }
 
+// GetUseToilets usetoiletテーブルから条件に合うデータを取得する
+// 日時の条件はcreatedに対して適用する
+func (mda *MysqlDbAccessor) GetUseToilets(hid int64, f model.UseToiletFilter) ([]model.UseToilet, error) {
+	var uts []model.UseToilet
+	where, args := recordWhere(hid, f.RecordFilter, "created")
+	cond := func(c string, v interface{}) {
+		where += " AND " + c
+		args = append(args, v)
+	}
+	if f.ToiletId != 0 {
+		cond("toiletid = ?", f.ToiletId)
+	}
+	if f.Type != "" {
+		cond("type = ?", f.Type)
+	}
+	if f.StoolScoreMin != 0 {
+		cond("stoolscore >= ?", f.StoolScoreMin)
+	}
+	if f.StoolScoreMax != 0 {
+		cond("stoolscore <= ?", f.StoolScoreMax)
+	}
+	if f.Color != "" {
+		cond("color = ?", f.Color)
+	}
+	if f.Blood != nil {
+		cond("blood = ?", *f.Blood)
+	}
+	if f.Mucus != nil {
+		cond("mucus = ?", *f.Mucus)
+	}
+	if f.ClumpSize != "" {
+		cond("clumpsize = ?", f.ClumpSize)
+	}
+	if f.Straining != nil {
+		cond("straining = ?", *f.Straining)
+	}
+	_, err := mda.Db.Select(&uts, "SELECT * FROM usetoilet WHERE "+where+" ORDER BY created", args...)
+	if err != nil {
+		return nil, err
+	}
+	return uts, nil
+}
+
+// GetLastUseToilets catごと、種類ごとの最後のusetoiletの日時を取得する
+func (mda *MysqlDbAccessor) GetLastUseToilets(hid int64) ([]model.LastUseToilet, error) {
+	var ls []model.LastUseToilet
+	_, err := mda.Db.Select(&ls, `SELECT catid, type, MAX(created) AS last FROM usetoilet
+		WHERE householdid = ? GROUP BY catid, type`, hid)
+	if err != nil {
+		return nil, err
+	}
+	return ls, nil
+}
+
 // GetUseToilet DBのusetoiletテーブルからidに合致するusetoiletを1つ返す
 // 見つからなかった場合は空のusetoiletとerrorを返す
-func (mda *MysqlDbAccessor) GetUseToilet(id, uid int64) (model.UseToilet, error) {
+func (mda *MysqlDbAccessor) GetUseToilet(id, hid int64) (model.UseToilet, error) {
 	var usetoilet model.UseToilet
-	err := mda.Db.SelectOne(&usetoilet, "SELECT * FROM usetoilet WHERE id = ? AND uid = ?", id, uid)
+	err := mda.Db.SelectOne(&usetoilet, "SELECT * FROM usetoilet WHERE id = ? AND householdid = ?", id, hid)
 	if err != nil {
 		return model.UseToilet{}, err
 	}
@@ -161,37 +241,89 @@ func (mda *MysqlDbAccessor) GetUseToilet(id, uid int64) (model.UseToilet, error)
 }
 
 // AddUseToilet usetoiletテーブルへデータを1件追加する
-func (mda *MysqlDbAccessor) AddUseToilet(usetoilet model.UseToilet) error {
-	err := mda.Db.Insert(&usetoilet)
+// 使われたtoiletの使用回数を1増やし、thに応じて砂の状態を更新する
+// 追加したusetoiletと、砂の状態が変わった場合はその履歴を返す
+func (mda *MysqlDbAccessor) AddUseToilet(usetoilet model.UseToilet, th model.SandThresholds) (model.UseToilet, *model.SandStateTransition, error) {
+	tx, err := mda.Db.Begin()
 	if err != nil {
-		return err
+		return model.UseToilet{}, nil, err
 	}
-	return nil
+	if err := tx.Insert(&usetoilet); err != nil {
+		tx.Rollback()
+		return model.UseToilet{}, nil, err
+	}
+	inc := func(t *model.Toilet) { t.UsageCount++ }
+	st, err := updateSandState(tx, usetoilet.ToiletId, usetoilet.HouseholdId, inc, th, model.SandReasonUseToilet)
+	if err != nil {
+		tx.Rollback()
+		return model.UseToilet{}, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return model.UseToilet{}, nil, err
+	}
+	return usetoilet, st, nil
 }
 
 // UpdateUseToilet usetoiletテーブルのデータを1件更新する
-func (mda *MysqlDbAccessor) UpdateUseToilet(usetoilet model.UseToilet) error {
-	_, err := mda.Db.Update(&usetoilet)
+// toiletが変わった場合は変更前後のtoiletの使用回数を数え直し、thに応じて砂の状態を更新する
+// 砂の状態が変わった場合はその履歴を返す
+func (mda *MysqlDbAccessor) UpdateUseToilet(usetoilet model.UseToilet, th model.SandThresholds) ([]model.SandStateTransition, error) {
+	tx, err := mda.Db.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	old, err := tx.SelectInt("SELECT toiletid FROM usetoilet WHERE id = ? AND householdid = ? FOR UPDATE",
+		usetoilet.Id, usetoilet.HouseholdId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if _, err := tx.Update(&usetoilet); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	var sts []model.SandStateTransition
+	if old != usetoilet.ToiletId {
+		sts, err = recountToilets(tx, []int64{old, usetoilet.ToiletId}, usetoilet.HouseholdId, th, model.SandReasonUseToilet)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return sts, nil
 }
 
 // DeleteUseToilet usetoiletテーブルのデータを1件削除する
-func (mda *MysqlDbAccessor) DeleteUseToilet(usetoilet model.UseToilet) error {
-	_, err := mda.Db.Delete(&usetoilet)
+// 使われたtoiletの使用回数を数え直し、thに応じて砂の状態を更新する
+// 砂の状態が変わった場合はその履歴を返す
+func (mda *MysqlDbAccessor) DeleteUseToilet(usetoilet model.UseToilet, th model.SandThresholds) ([]model.SandStateTransition, error) {
+	tx, err := mda.Db.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	if _, err := tx.Delete(&usetoilet); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	sts, err := recountToilets(tx, []int64{usetoilet.ToiletId}, usetoilet.HouseholdId, th, model.SandReasonUseToilet)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return sts, nil
 }
 
 // GetAllWashes washテーブルの全てのデータを取得する
-func (mda *MysqlDbAccessor) GetAllWashes(uid int64) ([]model.Wash, error) {
+func (mda *MysqlDbAccessor) GetAllWashes(hid int64) ([]model.Wash, error) {
 	var ws []model.Wash
 	_, err := mda.Db.Select(&ws,
-		"SELECT * FROM wash WHERE uid = ? ORDER BY created", uid)
+		"SELECT * FROM wash WHERE householdid = ? ORDER BY created", hid)
 	if err != nil {
 		return nil, err
 	}
@@ -199,10 +331,10 @@ func (mda *MysqlDbAccessor) GetAllWashes(uid int64) ([]model.Wash, error) {
 }
 
 // GetWashesByToiletId washテーブルから特定のToiletIdの全てのデータを取得する
-func (mda *MysqlDbAccessor) GetWashesByToiletId(toiletid, uid int64) ([]model.Wash, error) {
+func (mda *MysqlDbAccessor) GetWashesByToiletId(toiletid, hid int64) ([]model.Wash, error) {
 	var ws []model.Wash
 	_, err := mda.Db.Select(&ws,
-		"SELECT * FROM wash WHERE toiletid = ? AND uid = ? ORDER BY created", toiletid, uid)
+		"SELECT * FROM wash WHERE toiletid = ? AND householdid = ? ORDER BY created", toiletid, hid)
 	if err != nil {
 		return nil, err
 	}
@@ -211,9 +343,9 @@ func (mda *MysqlDbAccessor) GetWashesByToiletId(toiletid, uid int64) ([]model.Wa
 
 // GetWash DBのwashテーブルからidに合致するwashを1つ返す
 // 見つからなかった場合は空のwashとerrorを返す
-func (mda *MysqlDbAccessor) GetWash(id, uid int64) (model.Wash, error) {
+func (mda *MysqlDbAccessor) GetWash(id, hid int64) (model.Wash, error) {
 	var w model.Wash
-	err := mda.Db.SelectOne(&w, "SELECT * FROM wash WHERE id = ? AND uid = ?", id, uid)
+	err := mda.Db.SelectOne(&w, "SELECT * FROM wash WHERE id = ? AND householdid = ?", id, hid)
 	if err != nil {
 		return model.Wash{}, err
 	}
@@ -221,30 +353,115 @@ func (mda *MysqlDbAccessor) GetWash(id, uid int64) (model.Wash, error) {
 }
 
 // AddWash washテーブルへデータを1件追加する
-func (mda *MysqlDbAccessor) AddWash(wash model.Wash) error {
-	err := mda.Db.Insert(&wash)
+// 掃除したtoiletの使用回数を0に戻し、thに応じて砂の状態を更新する
+// 砂を全て入れ替えた場合は砂を入れ替えた日時も更新する
+// 砂を入れた場合はその量を砂の在庫から減らす
+// 追加したwashと、砂の状態が変わった場合はその履歴を返す
+func (mda *MysqlDbAccessor) AddWash(wash model.Wash, th model.SandThresholds) (model.Wash, *model.SandStateTransition, error) {
+	tx, err := mda.Db.Begin()
 	if err != nil {
-		return err
+		return model.Wash{}, nil, err
 	}
-	return nil
+	if err := useLitter(tx, &wash); err != nil {
+		tx.Rollback()
+		return model.Wash{}, nil, err
+	}
+	if err := tx.Insert(&wash); err != nil {
+		tx.Rollback()
+		return model.Wash{}, nil, err
+	}
+	reset := func(t *model.Toilet) {
+		t.UsageCount = 0
+		if wash.ReplacesSand() {
+			t.SandChanged = &wash.Created
+		}
+	}
+	st, err := updateSandState(tx, wash.ToiletId, wash.HouseholdId, reset, th, model.SandReasonWash)
+	if err != nil {
+		tx.Rollback()
+		return model.Wash{}, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return model.Wash{}, nil, err
+	}
+	return wash, st, nil
 }
 
 // UpdateWash washテーブルのデータを1件更新する
-func (mda *MysqlDbAccessor) UpdateWash(wash model.Wash) error {
-	_, err := mda.Db.Update(&wash)
+// 更新前に砂の在庫から減らした量を在庫に戻し、更新後に入れた量を減らす
+// toiletや砂を全て入れ替えたかが変わった場合は、変更前後のtoiletの砂を入れ替えた日時と使用回数を
+// 求め直し、thに応じて砂の状態を更新する。砂の状態が変わった場合はその履歴を返す
+func (mda *MysqlDbAccessor) UpdateWash(wash model.Wash, th model.SandThresholds) ([]model.SandStateTransition, error) {
+	tx, err := mda.Db.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	var old model.Wash
+	err = tx.SelectOne(&old, "SELECT * FROM wash WHERE id = ? AND householdid = ? FOR UPDATE", wash.Id, wash.HouseholdId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := restoreLitter(tx, old); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := useLitter(tx, &wash); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if _, err := tx.Update(&wash); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	var toiletids []int64
+	if old.ToiletId != wash.ToiletId {
+		toiletids = []int64{old.ToiletId, wash.ToiletId}
+	} else if old.ReplacesSand() != wash.ReplacesSand() {
+		toiletids = []int64{wash.ToiletId}
+	}
+	sts, err := recountToilets(tx, toiletids, wash.HouseholdId, th, model.SandReasonWash)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return sts, nil
 }
 
-// DeleteWash usetoiletテーブルのデータを1件削除する
-func (mda *MysqlDbAccessor) DeleteWash(wash model.Wash) error {
-	_, err := mda.Db.Delete(&wash)
+// DeleteWash washテーブルのデータを1件削除し、砂の在庫から減らした量を在庫に戻す
+// 掃除したtoiletの砂を入れ替えた日時と使用回数を求め直し、thに応じて砂の状態を更新する
+// 砂の状態が変わった場合はその履歴を返す
+func (mda *MysqlDbAccessor) DeleteWash(wash model.Wash, th model.SandThresholds) ([]model.SandStateTransition, error) {
+	tx, err := mda.Db.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	// use the stored wash, so that the litter is restored only once.
+	err = tx.SelectOne(&wash, "SELECT * FROM wash WHERE id = ? AND householdid = ? FOR UPDATE", wash.Id, wash.HouseholdId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := restoreLitter(tx, wash); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if _, err := tx.Delete(&wash); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	sts, err := recountToilets(tx, []int64{wash.ToiletId}, wash.HouseholdId, th, model.SandReasonWash)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return sts, nil
 }
 
 // FindUser userテーブルからnameに合致するデータを1件取得する
@@ -257,18 +474,161 @@ func (mda *MysqlDbAccessor) FindUser(name string) (model.User, error) {
 	return u, nil
 }
 
+// AddUser userテーブルへデータを1件追加し、ユーザ個人用のhouseholdを作成する
 func (mda *MysqlDbAccessor) AddUser(user model.User) error {
-	err := mda.Db.Insert(&user)
+	tx, err := mda.Db.Begin()
 	if err != nil {
 		return err
 	}
-	return nil
+	if err := tx.Insert(&user); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := addPersonalHousehold(tx, user); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
-func (mda *MysqlDbAccessor) DeleteUser(user model.User) error {
-	_, err := mda.Db.Delete(&user)
+// DeleteUser userテーブルのデータを1件削除する
+// ユーザの認証情報とhouseholdへの所属も削除し、メンバーがいなくなったhouseholdはデータごと削除する
+// ownerがいなくなったhouseholdは、残ったメンバーのうち最も早く所属したメンバーをownerにする
+// 削除したhouseholdの画像のBlobStoreのkeyを返す。BlobStoreからの削除は呼び出し元で行う
+func (mda *MysqlDbAccessor) DeleteUser(user model.User) ([]string, error) {
+	tx, err := mda.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	var hids []int64
+	_, err = tx.Select(&hids, "SELECT householdid FROM householdmember WHERE uid = ?", user.Id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for _, table := range []string{"recoverycode", "apikey", "useridentity", "householdmember", "notificationpref", "notification"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE uid = ?", user.Id); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	var keys []string
+	for _, hid := range hids {
+		n, err := tx.SelectInt("SELECT COUNT(*) FROM householdmember WHERE householdid = ?", hid)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if n > 0 {
+			if err := keepOwner(tx, hid); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			continue
+		}
+		var photos []model.Photo
+		if _, err := tx.Select(&photos, "SELECT * FROM photo WHERE householdid = ?", hid); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		for _, p := range photos {
+			keys = append(keys, p.Key, p.ThumbKey)
+		}
+		for _, table := range []string{"usetoilet", "wash", "catweight", "vetvisit", "vaccination", "catcondition", "medicationdose", "medication", "feeding", "waterintake", "usetoiletphoto", "photo", "sandstatetransition", "webhookdelivery", "webhook", "cat", "toilet", "litter", "householdinvitation"} {
+			if _, err := tx.Exec("DELETE FROM "+table+" WHERE householdid = ?", hid); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+		if _, err := tx.Exec("DELETE FROM household WHERE id = ?", hid); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Delete(&user); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// keepOwner householdにownerがいない場合、最も早く所属したメンバーをownerにする
+func keepOwner(tx *gorp.Transaction, hid int64) error {
+	n, err := tx.SelectInt("SELECT COUNT(*) FROM householdmember WHERE householdid = ? AND role = ? FOR UPDATE",
+		hid, model.RoleOwner)
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE householdmember SET role = ?, updated = ? WHERE householdid = ?
+		ORDER BY created, id LIMIT 1`, model.RoleOwner, time.Now(), hid)
+	return err
+}
+
+// GetUser userテーブルからidに合致するデータを1件取得する
+func (mda *MysqlDbAccessor) GetUser(id int64) (model.User, error) {
+	var u model.User
+	err := mda.Db.SelectOne(&u, "SELECT * FROM user WHERE id = ?", id)
+	if err != nil {
+		return model.User{}, err
+	}
+	return u, nil
+}
+
+// UpdateUser userテーブルのデータを1件更新する
+func (mda *MysqlDbAccessor) UpdateUser(user model.User) error {
+	_, err := mda.Db.Update(&user)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// UseTotpStep TOTPコードを受け付けたタイムステップを記録する
+// 既にstep以降のコードを受け付けていた場合はfalseを返す
+func (mda *MysqlDbAccessor) UseTotpStep(uid, step int64) (bool, error) {
+	res, err := mda.Db.Exec("UPDATE user SET totpstep = ? WHERE id = ? AND totpstep < ?", step, uid, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// SearchUsers userテーブルからnameに部分一致するデータを取得する
+func (mda *MysqlDbAccessor) SearchUsers(q string, limit, offset int) ([]model.User, error) {
+	var users []model.User
+	q = strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(q)
+	_, err := mda.Db.Select(&users,
+		"SELECT * FROM user WHERE name LIKE ? ORDER BY id LIMIT ? OFFSET ?", "%"+q+"%", limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// CountUserData ユーザが登録したデータの件数を取得する
+func (mda *MysqlDbAccessor) CountUserData(uid int64) (model.UserDataCounts, error) {
+	var counts model.UserDataCounts
+	err := mda.Db.SelectOne(&counts, `SELECT
+		(SELECT COUNT(*) FROM cat WHERE uid = ?) AS cats,
+		(SELECT COUNT(*) FROM toilet WHERE uid = ?) AS toilets,
+		(SELECT COUNT(*) FROM usetoilet WHERE uid = ?) AS usetoilets,
+		(SELECT COUNT(*) FROM wash WHERE uid = ?) AS washes,
+		(SELECT COUNT(*) FROM householdmember WHERE uid = ?) AS households,
+		(SELECT COUNT(*) FROM apikey WHERE uid = ?) AS apikeys`,
+		uid, uid, uid, uid, uid, uid)
+	if err != nil {
+		return model.UserDataCounts{}, err
+	}
+	return counts, nil
+}
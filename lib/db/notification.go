@@ -0,0 +1,119 @@
+package db
+
+import (
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// erDupEntry mysqlの一意制約違反のエラー番号
+const erDupEntry = 1062
+
+// isDupEntry errが一意制約違反か
+func isDupEntry(err error) bool {
+	me, ok := err.(*mysql.MySQLError)
+	return ok && me.Number == erDupEntry
+}
+
+// GetNotificationPreferences notificationprefテーブルからユーザの通知設定を全て取得する
+func (mda *MysqlDbAccessor) GetNotificationPreferences(uid int64) ([]model.NotificationPreference, error) {
+	var ps []model.NotificationPreference
+	_, err := mda.Db.Select(&ps, "SELECT * FROM notificationpref WHERE uid = ? ORDER BY id", uid)
+	if err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// GetNotificationPreference notificationprefテーブルからidに合致するユーザの通知設定を1つ返す
+func (mda *MysqlDbAccessor) GetNotificationPreference(id, uid int64) (model.NotificationPreference, error) {
+	var p model.NotificationPreference
+	err := mda.Db.SelectOne(&p, "SELECT * FROM notificationpref WHERE id = ? AND uid = ?", id, uid)
+	if err != nil {
+		return model.NotificationPreference{}, err
+	}
+	return p, nil
+}
+
+// AddNotificationPreference notificationprefテーブルへデータを1件追加する
+func (mda *MysqlDbAccessor) AddNotificationPreference(p model.NotificationPreference) error {
+	err := mda.Db.Insert(&p)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateNotificationPreference notificationprefテーブルのデータを1件更新する
+func (mda *MysqlDbAccessor) UpdateNotificationPreference(p model.NotificationPreference) error {
+	_, err := mda.Db.Update(&p)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteNotificationPreference notificationprefテーブルのデータを1件削除する
+func (mda *MysqlDbAccessor) DeleteNotificationPreference(p model.NotificationPreference) error {
+	_, err := mda.Db.Delete(&p)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetNotifiedHouseholds 有効な通知設定を持つユーザが所属するhouseholdのidを取得する
+func (mda *MysqlDbAccessor) GetNotifiedHouseholds() ([]int64, error) {
+	var hids []int64
+	_, err := mda.Db.Select(&hids, `SELECT DISTINCT householdid FROM householdmember
+		WHERE uid IN (SELECT uid FROM notificationpref WHERE enabled = 1) ORDER BY householdid`)
+	if err != nil {
+		return nil, err
+	}
+	return hids, nil
+}
+
+// AddNotification notificationテーブルへ通知を1件追加する
+// 同じ通知設定とDedupKeyの通知が既にある場合は追加せずfalseを返す
+func (mda *MysqlDbAccessor) AddNotification(n model.Notification) (bool, error) {
+	err := mda.Db.Insert(&n)
+	if isDupEntry(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetNotifications notificationテーブルからユーザの通知を新しい順にlimit件取得する
+func (mda *MysqlDbAccessor) GetNotifications(uid int64, limit int) ([]model.Notification, error) {
+	var ns []model.Notification
+	_, err := mda.Db.Select(&ns,
+		"SELECT * FROM notification WHERE uid = ? ORDER BY created DESC, id DESC LIMIT ?", uid, limit)
+	if err != nil {
+		return nil, err
+	}
+	return ns, nil
+}
+
+// GetPendingNotifications notificationテーブルから送信時刻がnow以前の送信待ちの通知を古い順にlimit件取得する
+func (mda *MysqlDbAccessor) GetPendingNotifications(now time.Time, limit int) ([]model.Notification, error) {
+	var ns []model.Notification
+	_, err := mda.Db.Select(&ns, `SELECT * FROM notification WHERE status = ? AND nextattempt <= ?
+		ORDER BY nextattempt, id LIMIT ?`, model.NotificationStatusPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	return ns, nil
+}
+
+// UpdateNotification notificationテーブルのデータを1件更新する
+func (mda *MysqlDbAccessor) UpdateNotification(n model.Notification) error {
+	_, err := mda.Db.Update(&n)
+	if err != nil {
+		return err
+	}
+	return nil
+}
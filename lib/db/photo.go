@@ -0,0 +1,158 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// inChunk IN句に一度に渡すidの数
+const inChunk = 500
+
+// AddPhoto photoテーブルへデータを1件追加し、idを設定したPhotoを返す
+func (mda *MysqlDbAccessor) AddPhoto(p model.Photo) (model.Photo, error) {
+	err := mda.Db.Insert(&p)
+	if err != nil {
+		return model.Photo{}, err
+	}
+	return p, nil
+}
+
+// GetPhoto photoテーブルからidに合致するデータを1つ返す
+func (mda *MysqlDbAccessor) GetPhoto(id int64) (model.Photo, error) {
+	var p model.Photo
+	err := mda.Db.SelectOne(&p, "SELECT * FROM photo WHERE id = ?", id)
+	if err != nil {
+		return model.Photo{}, err
+	}
+	return p, nil
+}
+
+// DeletePhoto photoテーブルのデータを1件削除する
+func (mda *MysqlDbAccessor) DeletePhoto(p model.Photo) error {
+	_, err := mda.Db.Delete(&p)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// AttachUseToiletPhotos usetoiletに画像をまとめて添付する
+// 添付済みの画像と合わせてmaxを超える場合はmodel.ErrTooManyPhotosを返し、1件も添付しない
+func (mda *MysqlDbAccessor) AttachUseToiletPhotos(utid, hid int64, photoids []int64, max int) error {
+	tx, err := mda.Db.Begin()
+	if err != nil {
+		return err
+	}
+	// lock the usetoilet so that concurrent attaches are counted one after another.
+	id, err := tx.SelectInt("SELECT id FROM usetoilet WHERE id = ? AND householdid = ? FOR UPDATE", utid, hid)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if id == 0 {
+		tx.Rollback()
+		return sql.ErrNoRows
+	}
+	n, err := tx.SelectInt("SELECT COUNT(*) FROM usetoiletphoto WHERE usetoiletid = ? FOR UPDATE", utid)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if int(n)+len(photoids) > max {
+		tx.Rollback()
+		return model.ErrTooManyPhotos
+	}
+	for _, pid := range photoids {
+		up := model.UseToiletPhoto{HouseholdId: hid, UseToiletId: utid, PhotoId: pid}
+		if err := tx.Insert(&up); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetUseToiletPhotos usetoiletphotoテーブルから世帯の添付の一覧を取得する
+// utidが0でなければそのusetoiletの添付のみを返す
+func (mda *MysqlDbAccessor) GetUseToiletPhotos(utid, hid int64) ([]model.UseToiletPhoto, error) {
+	var ups []model.UseToiletPhoto
+	query := "SELECT * FROM usetoiletphoto WHERE householdid = ?"
+	args := []interface{}{hid}
+	if utid != 0 {
+		query += " AND usetoiletid = ?"
+		args = append(args, utid)
+	}
+	_, err := mda.Db.Select(&ups, query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	return ups, nil
+}
+
+// GetUseToiletPhotoImages utidsのusetoiletに添付した画像を添付した順にusetoiletごとに取得する
+func (mda *MysqlDbAccessor) GetUseToiletPhotoImages(hid int64, utids []int64) (map[int64][]model.Photo, error) {
+	var ups []model.UseToiletPhoto
+	for start := 0; start < len(utids); start += inChunk {
+		marks, args := inArgs(utids[start:minInt(start+inChunk, len(utids))])
+		var chunk []model.UseToiletPhoto
+		_, err := mda.Db.Select(&chunk, "SELECT * FROM usetoiletphoto WHERE householdid = ? AND usetoiletid IN ("+marks+") ORDER BY id",
+			append([]interface{}{hid}, args...)...)
+		if err != nil {
+			return nil, err
+		}
+		ups = append(ups, chunk...)
+	}
+
+	pids := make([]int64, len(ups))
+	for i, up := range ups {
+		pids[i] = up.PhotoId
+	}
+	photos := map[int64]model.Photo{}
+	for start := 0; start < len(pids); start += inChunk {
+		marks, args := inArgs(pids[start:minInt(start+inChunk, len(pids))])
+		var chunk []model.Photo
+		_, err := mda.Db.Select(&chunk, "SELECT * FROM photo WHERE householdid = ? AND id IN ("+marks+")",
+			append([]interface{}{hid}, args...)...)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range chunk {
+			photos[p.Id] = p
+		}
+	}
+
+	res := map[int64][]model.Photo{}
+	for _, up := range ups {
+		if p, ok := photos[up.PhotoId]; ok {
+			res[up.UseToiletId] = append(res[up.UseToiletId], p)
+		}
+	}
+	return res, nil
+}
+
+// inArgs idsをIN句のプレースホルダと引数にする
+func inArgs(ids []int64) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "), args
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// DeleteUseToiletPhoto usetoiletphotoテーブルのデータを1件削除する
+func (mda *MysqlDbAccessor) DeleteUseToiletPhoto(up model.UseToiletPhoto) error {
+	_, err := mda.Db.Delete(&up)
+	if err != nil {
+		return err
+	}
+	return nil
+}
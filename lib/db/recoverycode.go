@@ -0,0 +1,54 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// ReplaceRecoveryCodes ユーザのリカバリーコードを全て削除し、codesで置き換える
+func (mda *MysqlDbAccessor) ReplaceRecoveryCodes(uid int64, codes []model.RecoveryCode) error {
+	tx, err := mda.Db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recoverycode WHERE uid = ?", uid); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for i := range codes {
+		codes[i].UID = uid
+		if err := tx.Insert(&codes[i]); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteRecoveryCodes ユーザのリカバリーコードを全て削除する
+func (mda *MysqlDbAccessor) DeleteRecoveryCodes(uid int64) error {
+	_, err := mda.Db.Exec("DELETE FROM recoverycode WHERE uid = ?", uid)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UseRecoveryCode 未使用のリカバリーコードを使用済みにする
+// 該当するコードが無い場合はsql.ErrNoRowsを返す
+func (mda *MysqlDbAccessor) UseRecoveryCode(uid int64, code string) error {
+	res, err := mda.Db.Exec(
+		"UPDATE recoverycode SET used = 1, updated = NOW() WHERE uid = ? AND code = ? AND used = 0", uid, code)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"github.com/go-gorp/gorp"
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// updateSandState toiletの使用回数などをapplyで更新し、閾値に応じて砂の状態を変える
+// 状態が変わった場合は履歴を記録して返す。toiletidが0の場合は何もしない
+func updateSandState(tx *gorp.Transaction, toiletid, hid int64, apply func(*model.Toilet), th model.SandThresholds, reason string) (*model.SandStateTransition, error) {
+	if toiletid == 0 {
+		return nil, nil
+	}
+	var t model.Toilet
+	err := tx.SelectOne(&t, "SELECT * FROM toilet WHERE id = ? AND householdid = ? FOR UPDATE", toiletid, hid)
+	if err != nil {
+		return nil, err
+	}
+	from := t.SandState
+	apply(&t)
+	t.SandState = th.State(t.UsageCount)
+	if _, err := tx.Update(&t); err != nil {
+		return nil, err
+	}
+	if from == t.SandState {
+		return nil, nil
+	}
+	st := &model.SandStateTransition{
+		HouseholdId: hid,
+		ToiletId:    toiletid,
+		From:        from,
+		To:          t.SandState,
+		Reason:      reason,
+		UsageCount:  t.UsageCount,
+	}
+	if err := tx.Insert(st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// recountToilets toiletidsのtoiletの使用回数と砂を入れ替えた日時を記録から求め直し、砂の状態を更新する
+// 使用回数は最後の掃除以降のusetoiletの数、砂を入れ替えた日時は最後に砂を全て入れ替えた掃除の日時とする
+// 砂の状態が変わった場合はその履歴を返す
+func recountToilets(tx *gorp.Transaction, toiletids []int64, hid int64, th model.SandThresholds, reason string) ([]model.SandStateTransition, error) {
+	var sts []model.SandStateTransition
+	for _, toiletid := range toiletids {
+		if toiletid == 0 {
+			continue
+		}
+		// lock the toilet first, so that a concurrent usetoilet or wash is counted after this.
+		if _, err := tx.SelectInt("SELECT id FROM toilet WHERE id = ? AND householdid = ? FOR UPDATE", toiletid, hid); err != nil {
+			return nil, err
+		}
+		n, err := tx.SelectInt(`SELECT COUNT(*) FROM usetoilet WHERE toiletid = ? AND householdid = ?
+			AND created > (SELECT COALESCE(MAX(created), '1000-01-01') FROM wash WHERE toiletid = ? AND householdid = ?)`,
+			toiletid, hid, toiletid, hid)
+		if err != nil {
+			return nil, err
+		}
+		var ws []model.Wash
+		_, err = tx.Select(&ws, `SELECT * FROM wash WHERE toiletid = ? AND householdid = ? AND kind IN (?, ?)
+			ORDER BY created DESC LIMIT 1`, toiletid, hid, model.SandWashKinds[0], model.SandWashKinds[1])
+		if err != nil {
+			return nil, err
+		}
+		set := func(t *model.Toilet) {
+			t.UsageCount = int(n)
+			t.SandChanged = nil
+			if len(ws) > 0 {
+				t.SandChanged = &ws[0].Created
+			}
+		}
+		st, err := updateSandState(tx, toiletid, hid, set, th, reason)
+		if err != nil {
+			return nil, err
+		}
+		if st != nil {
+			sts = append(sts, *st)
+		}
+	}
+	return sts, nil
+}
+
+// GetSandStateHistory sandstatetransitionテーブルからtoiletの砂の状態の履歴を新しい順に取得する
+func (mda *MysqlDbAccessor) GetSandStateHistory(toiletid, hid int64) ([]model.SandStateTransition, error) {
+	var ts []model.SandStateTransition
+	_, err := mda.Db.Select(&ts, `SELECT * FROM sandstatetransition WHERE toiletid = ? AND householdid = ?
+		ORDER BY created DESC, id DESC`, toiletid, hid)
+	if err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// GetLastWashes washテーブルからtoiletごと、掃除の種類ごとの最後の掃除の日時を取得する
+func (mda *MysqlDbAccessor) GetLastWashes(hid int64) ([]model.LastWash, error) {
+	var ls []model.LastWash
+	_, err := mda.Db.Select(&ls, `SELECT toiletid, kind, MAX(created) AS last FROM wash
+		WHERE householdid = ? GROUP BY toiletid, kind`, hid)
+	if err != nil {
+		return nil, err
+	}
+	return ls, nil
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// zoneSpan UTCからのオフセットが一定の期間
+type zoneSpan struct {
+	until  time.Time
+	offset int
+}
+
+// zoneSpans fromからtoまでを、locのUTCからのオフセットが変わる時刻で区切る
+// 最後の期間のuntilはtoになる
+func zoneSpans(from, to time.Time, loc *time.Location) []zoneSpan {
+	offsetAt := func(t time.Time) int {
+		_, o := t.In(loc).Zone()
+		return o
+	}
+	var spans []zoneSpan
+	cur := offsetAt(from)
+	for t := from; t.Before(to); {
+		next := t.Add(24 * time.Hour)
+		if next.After(to) {
+			next = to
+		}
+		if offsetAt(next) == cur {
+			t = next
+			continue
+		}
+		// find the first second with the new offset.
+		lo, hi := t.Unix(), next.Unix()
+		for hi-lo > 1 {
+			mid := lo + (hi-lo)/2
+			if offsetAt(time.Unix(mid, 0)) == cur {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		spans = append(spans, zoneSpan{until: time.Unix(hi, 0), offset: cur})
+		cur = offsetAt(time.Unix(hi, 0))
+		t = time.Unix(hi, 0)
+	}
+	return append(spans, zoneSpan{until: to, offset: cur})
+}
+
+// GetUseToiletCounts usetoiletテーブルからlocの日付ごと、cat、toilet、種類ごとの回数を集計する
+// f.Fromとf.Toは必須
+func (mda *MysqlDbAccessor) GetUseToiletCounts(hid int64, f model.RecordFilter, loc *time.Location) ([]model.UseToiletCount, error) {
+	where, args := recordWhere(hid, f, "created")
+
+	// created is stored in UTC; shift it by the offset in effect at that time
+	// so that time zones with daylight saving time are grouped correctly
+	// without relying on the time zone tables of MySQL.
+	spans := zoneSpans(*f.From, *f.To, loc)
+	var shift strings.Builder
+	var shiftArgs []interface{}
+	shift.WriteString("CASE")
+	for _, s := range spans[:len(spans)-1] {
+		shift.WriteString(" WHEN created < ? THEN ?")
+		shiftArgs = append(shiftArgs, s.until, s.offset)
+	}
+	shift.WriteString(" ELSE ? END")
+	shiftArgs = append(shiftArgs, spans[len(spans)-1].offset)
+
+	query := `SELECT DATE_FORMAT(created + INTERVAL (` + shift.String() + `) SECOND, '%Y-%m-%d') AS day,
+		catid, toiletid, type, COUNT(*) AS count FROM usetoilet WHERE ` + where + `
+		GROUP BY day, catid, toiletid, type ORDER BY day, catid, toiletid, type`
+	var cs []model.UseToiletCount
+	_, err := mda.Db.Select(&cs, query, append(shiftArgs, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
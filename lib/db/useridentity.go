@@ -0,0 +1,44 @@
+package db
+
+import (
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// FindUserIdentity useridentityテーブルからproviderとsubjectに合致するデータを1件取得する
+func (mda *MysqlDbAccessor) FindUserIdentity(provider, subject string) (model.UserIdentity, error) {
+	var ui model.UserIdentity
+	err := mda.Db.SelectOne(&ui,
+		"SELECT * FROM useridentity WHERE provider = ? AND subject = ?", provider, subject)
+	if err != nil {
+		return model.UserIdentity{}, err
+	}
+	return ui, nil
+}
+
+// AddUserWithIdentity ユーザと個人用のhousehold、外部アカウントとの紐付けを作成する
+func (mda *MysqlDbAccessor) AddUserWithIdentity(user model.User, identity model.UserIdentity) (model.User, error) {
+	tx, err := mda.Db.Begin()
+	if err != nil {
+		return model.User{}, err
+	}
+	if err := tx.Insert(&user); err != nil {
+		tx.Rollback()
+		return model.User{}, err
+	}
+	if _, err := addPersonalHousehold(tx, user); err != nil {
+		tx.Rollback()
+		return model.User{}, err
+	}
+	identity.UID = user.Id
+	if err := tx.Insert(&identity); err != nil {
+		tx.Rollback()
+		return model.User{}, err
+	}
+	return user, tx.Commit()
+}
+
+// DeleteUserIdentity useridentityテーブルから外部アカウントとの紐付けを1件削除する
+func (mda *MysqlDbAccessor) DeleteUserIdentity(identity model.UserIdentity) error {
+	_, err := mda.Db.Delete(&identity)
+	return err
+}
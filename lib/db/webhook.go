@@ -0,0 +1,139 @@
+package db
+
+import (
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// GetWebhooks webhookテーブルからhouseholdのwebhookを全て取得する
+func (mda *MysqlDbAccessor) GetWebhooks(hid int64) ([]model.Webhook, error) {
+	var ws []model.Webhook
+	_, err := mda.Db.Select(&ws, "SELECT * FROM webhook WHERE householdid = ? ORDER BY id", hid)
+	if err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+// GetWebhook webhookテーブルからidに合致するhouseholdのwebhookを1つ返す
+func (mda *MysqlDbAccessor) GetWebhook(id, hid int64) (model.Webhook, error) {
+	var w model.Webhook
+	err := mda.Db.SelectOne(&w, "SELECT * FROM webhook WHERE id = ? AND householdid = ?", id, hid)
+	if err != nil {
+		return model.Webhook{}, err
+	}
+	return w, nil
+}
+
+// GetWebhookById webhookテーブルからidに合致するwebhookを1つ返す
+func (mda *MysqlDbAccessor) GetWebhookById(id int64) (model.Webhook, error) {
+	var w model.Webhook
+	err := mda.Db.SelectOne(&w, "SELECT * FROM webhook WHERE id = ?", id)
+	if err != nil {
+		return model.Webhook{}, err
+	}
+	return w, nil
+}
+
+// AddWebhook webhookテーブルへデータを1件追加する
+// 追加したwebhookを返す
+func (mda *MysqlDbAccessor) AddWebhook(w model.Webhook) (model.Webhook, error) {
+	err := mda.Db.Insert(&w)
+	if err != nil {
+		return model.Webhook{}, err
+	}
+	return w, nil
+}
+
+// UpdateWebhook webhookテーブルのデータを1件更新する
+func (mda *MysqlDbAccessor) UpdateWebhook(w model.Webhook) error {
+	_, err := mda.Db.Update(&w)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteWebhook webhookテーブルのデータを1件、その送信の記録とともに削除する
+func (mda *MysqlDbAccessor) DeleteWebhook(w model.Webhook) error {
+	tx, err := mda.Db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM webhookdelivery WHERE webhookid = ?", w.Id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Delete(&w); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddWebhookDelivery webhookdeliveryテーブルへデータを1件追加する
+// 追加したデータを返す
+func (mda *MysqlDbAccessor) AddWebhookDelivery(d model.WebhookDelivery) (model.WebhookDelivery, error) {
+	err := mda.Db.Insert(&d)
+	if err != nil {
+		return model.WebhookDelivery{}, err
+	}
+	return d, nil
+}
+
+// GetWebhookDeliveries webhookdeliveryテーブルからwebhookの送信の記録を新しい順にlimit件取得する
+func (mda *MysqlDbAccessor) GetWebhookDeliveries(webhookid, hid int64, limit int) ([]model.WebhookDelivery, error) {
+	var ds []model.WebhookDelivery
+	_, err := mda.Db.Select(&ds, `SELECT * FROM webhookdelivery WHERE webhookid = ? AND householdid = ?
+		ORDER BY created DESC, id DESC LIMIT ?`, webhookid, hid, limit)
+	if err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// GetPendingWebhookDeliveries webhookdeliveryテーブルから送信時刻がnow以前の送信待ちのデータを古い順にlimit件取得する
+func (mda *MysqlDbAccessor) GetPendingWebhookDeliveries(now time.Time, limit int) ([]model.WebhookDelivery, error) {
+	var ds []model.WebhookDelivery
+	_, err := mda.Db.Select(&ds, `SELECT * FROM webhookdelivery WHERE status = ? AND nextattempt <= ?
+		ORDER BY nextattempt, id LIMIT ?`, model.NotificationStatusPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// ClaimWebhookDelivery 送信時刻がnow以前の送信待ちのデータの送信時刻をuntilにずらし、
+// untilまで他の送信処理が扱わないようにする。ずらせなかった場合はfalseを返す
+func (mda *MysqlDbAccessor) ClaimWebhookDelivery(id int64, now, until time.Time) (bool, error) {
+	res, err := mda.Db.Exec(`UPDATE webhookdelivery SET nextattempt = ?
+		WHERE id = ? AND status = ? AND nextattempt <= ?`, until, id, model.NotificationStatusPending, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// UpdateWebhookDelivery webhookdeliveryテーブルのデータを1件更新する
+func (mda *MysqlDbAccessor) UpdateWebhookDelivery(d model.WebhookDelivery) error {
+	_, err := mda.Db.Update(&d)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// PurgeWebhookDeliveries webhookdeliveryテーブルからbefore以前に作られた送信済み、送信失敗のデータを削除する
+func (mda *MysqlDbAccessor) PurgeWebhookDeliveries(before time.Time) (int64, error) {
+	res, err := mda.Db.Exec("DELETE FROM webhookdelivery WHERE status <> ? AND created < ?",
+		model.NotificationStatusPending, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
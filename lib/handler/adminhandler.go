@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/labstack/echo"
+)
+
+const (
+	adminDefaultLimit = 50
+	adminMaxLimit     = 500
+)
+
+// AdminDbAccessor 管理者によるユーザ管理に必要なテーブルを操作するinterface
+type AdminDbAccessor interface {
+	SearchUsers(q string, limit, offset int) ([]model.User, error)
+	GetUser(id int64) (model.User, error)
+	UpdateUser(user model.User) error
+	DeleteUser(user model.User) ([]string, error)
+	CountUserData(uid int64) (model.UserDataCounts, error)
+	GetJobs() ([]model.Job, error)
+}
+
+// AdminHandler /adminへのリクエストを処理する
+type AdminHandler struct {
+	Db AdminDbAccessor
+	// Photos nilでなければユーザの削除で削除したhouseholdの画像をBlobStoreからも削除する
+	Photos *PhotoHandler
+}
+
+// adminUser 管理者向けのユーザ情報
+type adminUser struct {
+	model.User
+	Counts *model.UserDataCounts `json:"counts,omitempty"`
+}
+
+// SearchUsers ユーザの一覧を返す
+// ?q= でnameの部分一致検索、?limit= ?offset= でページングを行う
+func (ah *AdminHandler) SearchUsers(c echo.Context) error {
+	limit, err := queryInt(c, "limit", adminDefaultLimit)
+	if err != nil || limit <= 0 || limit > adminMaxLimit {
+		return c.String(http.StatusBadRequest, "Invalid limit.")
+	}
+	offset, err := queryInt(c, "offset", 0)
+	if err != nil || offset < 0 {
+		return c.String(http.StatusBadRequest, "Invalid offset.")
+	}
+
+	users, err := ah.Db.SearchUsers(c.QueryParam("q"), limit, offset)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	res := make([]adminUser, 0, len(users))
+	for _, u := range users {
+		u.Password = ""
+		res = append(res, adminUser{User: u})
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// GetUser ユーザの情報と登録したデータの件数を返す
+func (ah *AdminHandler) GetUser(c echo.Context) error {
+	user, err := ah.selectUser(c)
+	if err != nil {
+		return err
+	}
+	counts, err := ah.Db.CountUserData(user.Id)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	user.Password = ""
+	return c.JSON(http.StatusOK, adminUser{User: user, Counts: &counts})
+}
+
+// DisableUser ユーザを無効化する
+// 無効化されたユーザはログインできず、発行済みのトークンも使用できない
+func (ah *AdminHandler) DisableUser(c echo.Context) error {
+	return ah.setDisabled(c, true)
+}
+
+// EnableUser 無効化したユーザを有効に戻す
+func (ah *AdminHandler) EnableUser(c echo.Context) error {
+	return ah.setDisabled(c, false)
+}
+
+// LogoutUser ユーザに発行済みの全てのトークンを失効させる
+func (ah *AdminHandler) LogoutUser(c echo.Context) error {
+	user, err := ah.selectUser(c)
+	if err != nil {
+		return err
+	}
+	user.LogoutAt = time.Now().Unix()
+	if err := ah.Db.UpdateUser(user); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusInternalServerError, "Could not update user.")
+	}
+	c.Logger().Infof("Logout: user %d", user.Id)
+	return c.String(http.StatusOK, "")
+}
+
+// DeleteUser ユーザを削除する
+// メンバーがいなくなったhouseholdはデータと画像ごと削除する
+func (ah *AdminHandler) DeleteUser(c echo.Context) error {
+	user, err := ah.selectUser(c)
+	if err != nil {
+		return err
+	}
+	if user.Id == UserIdFromToken(c) {
+		return c.String(http.StatusBadRequest, "Can not delete yourself.")
+	}
+	keys, err := ah.Db.DeleteUser(user)
+	if err != nil {
+		c.Logger().Errorf("Delete: ", err)
+		return c.String(http.StatusInternalServerError, "Failed delete the user.")
+	}
+	if ah.Photos != nil {
+		ah.Photos.removeBlobs(c, keys)
+	}
+	c.Logger().Infof("Deleted: user %d", user.Id)
+	return c.String(http.StatusOK, "")
+}
+
+func (ah *AdminHandler) setDisabled(c echo.Context, disabled bool) error {
+	user, err := ah.selectUser(c)
+	if err != nil {
+		return err
+	}
+	if user.Id == UserIdFromToken(c) {
+		return c.String(http.StatusBadRequest, "Can not change your own account.")
+	}
+	user.Disabled = disabled
+	if err := ah.Db.UpdateUser(user); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusInternalServerError, "Could not update user.")
+	}
+	c.Logger().Infof("Updated: user %d disabled=%v", user.Id, disabled)
+	return c.String(http.StatusOK, "")
+}
+
+// selectUser パスパラメータ:idのユーザを取得する
+// 取得できなかった場合はそのままhandlerから返すerrorを返す
+func (ah *AdminHandler) selectUser(c echo.Context) (model.User, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Logger().Errorf("Param parse: ", err)
+		return model.User{}, echo.NewHTTPError(http.StatusBadRequest, "Param parse: "+err.Error())
+	}
+	user, err := ah.Db.GetUser(id)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return model.User{}, echo.NewHTTPError(http.StatusNotFound, "No your specified user.")
+	}
+	return user, nil
+}
+
+// GetJobs 定期処理の実行状況を返す
+func (ah *AdminHandler) GetJobs(c echo.Context) error {
+	jobs, err := ah.Db.GetJobs()
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, jobs)
+}
+
+// queryInt クエリパラメータを整数として返す
+// 指定が無い場合はdefを返す
+func queryInt(c echo.Context, name string, def int) (int, error) {
+	v := c.QueryParam(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/greytabby/meowapi/lib/blob"
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/labstack/echo"
+)
+
+// fakeAdminDb DeleteUserで削除したhouseholdの画像のkeyを返すAdminDbAccessor
+type fakeAdminDb struct {
+	AdminDbAccessor
+	users map[int64]model.User
+	keys  []string
+}
+
+func (f *fakeAdminDb) GetUser(id int64) (model.User, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return model.User{}, sql.ErrNoRows
+	}
+	return u, nil
+}
+
+func (f *fakeAdminDb) DeleteUser(user model.User) ([]string, error) {
+	delete(f.users, user.Id)
+	return f.keys, nil
+}
+
+func TestAdminDeleteUserRemovesPhotoBlobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meowapi-blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := blob.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{"photos/2/a.jpg", "photos/2/a_thumb.jpg"}
+	for _, key := range append(keys, "photos/3/b.jpg") {
+		if err := store.Put(key, strings.NewReader("x"), 1, "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db := &fakeAdminDb{
+		users: map[int64]model.User{1: {Id: 1}, 2: {Id: 2}},
+		keys:  keys,
+	}
+	ah := AdminHandler{Db: db, Photos: &PhotoHandler{Store: store}}
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodDelete, "/admin/user/2", nil), rec)
+	c.SetParamNames("id")
+	c.SetParamValues("2")
+	c.Set("user", &jwt.Token{Claims: &jwtCustomClaims{UID: 1}})
+	if err := ah.DeleteUser(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	for _, key := range keys {
+		if _, _, err := store.Get(key); err != blob.ErrNotFound {
+			t.Errorf("Get(%q) after delete: err = %v", key, err)
+		}
+	}
+	r, _, err := store.Get("photos/3/b.jpg")
+	if err != nil {
+		t.Fatalf("photo of another household was deleted: %v", err)
+	}
+	r.Close()
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/health"
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/labstack/echo"
+)
+
+// AlertDbAccessor 異常の検出に使う記録を取得するinterface
+type AlertDbAccessor interface {
+	CatReader
+	GetUser(id int64) (model.User, error)
+	GetUseToilets(hid int64, f model.UseToiletFilter) ([]model.UseToilet, error)
+	GetLastUseToilets(hid int64) ([]model.LastUseToilet, error)
+}
+
+// AlertHandler /api/alertsへのリクエストを処理する
+type AlertHandler struct {
+	Db     AlertDbAccessor
+	Health health.Config
+}
+
+// GetAlerts 世帯の全てのcatについてトイレの記録から検出した異常を返す
+// 日の区切りはユーザのタイムゾーンで判定する
+func (ah *AlertHandler) GetAlerts(c echo.Context) error {
+	user, err := ah.Db.GetUser(UserIdFromToken(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	alerts, err := HealthAlerts(ah.Db, ah.Health, HouseholdIdFromContext(c), time.Now(), user.Location())
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, alerts)
+}
+
+// HealthAlerts 世帯の記録を読み込んで異常を検出する
+// 通知など、リクエスト以外からも呼び出す
+func HealthAlerts(db AlertDbAccessor, cfg health.Config, hid int64, now time.Time, loc *time.Location) ([]model.HealthAlert, error) {
+	cats, err := db.GetAllCats(hid)
+	if err != nil {
+		return nil, err
+	}
+	// one extra day covers the start of today in any time zone.
+	from := now.AddDate(0, 0, -cfg.BaselineDays-2)
+	uts, err := db.GetUseToilets(hid, model.UseToiletFilter{RecordFilter: model.RecordFilter{From: &from}})
+	if err != nil {
+		return nil, err
+	}
+	last, err := db.GetLastUseToilets(hid)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Analyze(cats, uts, last, now, loc), nil
+}
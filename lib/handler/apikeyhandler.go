@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/labstack/echo"
+)
+
+const (
+	apiKeyScheme = "ApiKey"
+	apiKeyPrefix = "meow_"
+	// apiKeyTouchInterval 最終利用日時の更新間隔
+	apiKeyTouchInterval = time.Minute
+)
+
+// ApiKeyScopes apiキーに付与できるscope
+// scopeはそのまま権限としてAuthorizeで確認する
+var ApiKeyScopes = map[string]bool{
+	PermCatRead:        true,
+	PermCatWrite:       true,
+	PermToiletRead:     true,
+	PermToiletWrite:    true,
+	PermUseToiletRead:  true,
+	PermUseToiletWrite: true,
+	PermWashRead:       true,
+	PermWashWrite:      true,
+	PermFeedingRead:    true,
+	PermFeedingWrite:   true,
+	PermMedicalRead:    true,
+	PermMedicalWrite:   true,
+	PermWebhookRead:    true,
+	PermWebhookWrite:   true,
+}
+
+// ApiKeyDbAccessor apikeyテーブルを操作するinterface
+type ApiKeyDbAccessor interface {
+	GetAllApiKeys(uid int64) ([]model.ApiKey, error)
+	GetApiKey(id, uid int64) (model.ApiKey, error)
+	FindApiKeyByHash(hash string) (model.ApiKey, error)
+	AddApiKey(key model.ApiKey) (model.ApiKey, error)
+	DeleteApiKey(key model.ApiKey) error
+	TouchApiKey(id int64, t time.Time) error
+	GetUser(id int64) (model.User, error)
+}
+
+// ApiKeyHandler /api/apikeyへのリクエストとapiキーによる認証を処理する
+type ApiKeyHandler struct {
+	Db ApiKeyDbAccessor
+}
+
+// GetAllApiKeys ユーザの全てのapiキーを返す
+func (kh *ApiKeyHandler) GetAllApiKeys(c echo.Context) error {
+	uid := UserIdFromToken(c)
+	keys, err := kh.Db.GetAllApiKeys(uid)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, keys)
+}
+
+// AddApiKey apiキーを1件発行する
+// キーはこのレスポンスでのみ返す
+func (kh *ApiKeyHandler) AddApiKey(c echo.Context) error {
+	var k model.ApiKey
+	if err := c.Bind(&k); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if k.Name == "" {
+		return c.String(http.StatusBadRequest, "Name is not specified.")
+	}
+	scopes, ok := normalizeScopes(k.Scopes)
+	if !ok {
+		return c.String(http.StatusBadRequest, "Invalid scopes.")
+	}
+
+	secret, err := generateApiKey()
+	if err != nil {
+		c.Logger().Error("Generate api key failed.", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	k.UID = UserIdFromToken(c)
+	k.Scopes = scopes
+	k.Prefix = secret[:len(apiKeyPrefix)+6]
+	k.Hash = hashApiKey(secret)
+	k.LastUsed = nil
+	k, err = kh.Db.AddApiKey(k)
+	if err != nil {
+		c.Logger().Errorf("Insert: ", err)
+		return c.String(http.StatusInternalServerError, "Could not add new api key.")
+	}
+	c.Logger().Infof("Added: api key %d", k.Id)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"apikey": k,
+		"key":    secret,
+	})
+}
+
+// DeleteApiKey apiキーを1件失効させる
+func (kh *ApiKeyHandler) DeleteApiKey(c echo.Context) error {
+	var k model.ApiKey
+	if err := c.Bind(&k); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if k.Id == 0 {
+		return c.String(http.StatusBadRequest, "Api key id is not specified.")
+	}
+
+	uid := UserIdFromToken(c)
+	selected, err := kh.Db.GetApiKey(k.Id, uid)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "No your specified api key.")
+	}
+	if err := kh.Db.DeleteApiKey(selected); err != nil {
+		c.Logger().Errorf("Delete: ", err)
+		return c.String(http.StatusInternalServerError, "Failed delete the api key.")
+	}
+	c.Logger().Infof("Deleted: api key %d", selected.Id)
+	return c.String(http.StatusOK, "")
+}
+
+// Middleware "Authorization: ApiKey ..." ヘッダによる認証を行うmiddleware
+// 認証に成功した場合はjwtによる認証と同様にcontextの"user"へトークンを設定する
+// トークンの権限はキーのscopeとユーザの役割の権限の両方に含まれるものになる
+// JWTConfigより前に登録すること
+func (kh *ApiKeyHandler) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		auth := c.Request().Header.Get(echo.HeaderAuthorization)
+		if !strings.HasPrefix(auth, apiKeyScheme+" ") {
+			return next(c)
+		}
+
+		secret := strings.TrimSpace(auth[len(apiKeyScheme)+1:])
+		k, err := kh.Db.FindApiKeyByHash(hashApiKey(secret))
+		if err != nil {
+			return c.String(http.StatusUnauthorized, "Invalid api key")
+		}
+
+		user, err := kh.Db.GetUser(k.UID)
+		if err != nil {
+			c.Logger().Errorf("Select: ", err)
+			return c.String(http.StatusUnauthorized, "Invalid api key")
+		}
+
+		now := time.Now()
+		if k.LastUsed == nil || now.Sub(*k.LastUsed) > apiKeyTouchInterval {
+			if err := kh.Db.TouchApiKey(k.Id, now); err != nil {
+				c.Logger().Errorf("Update: ", err)
+			}
+		}
+
+		c.Set("user", &jwt.Token{
+			Claims: &jwtCustomClaims{
+				UID:         user.Id,
+				Name:        user.Name,
+				Role:        user.Role,
+				Permissions: intersect(strings.Split(k.Scopes, ","), RolePermissions[user.Role]),
+				// api keys are not revoked by force logout
+				StandardClaims: jwt.StandardClaims{IssuedAt: now.Unix()},
+			},
+			Valid: true,
+		})
+		return next(c)
+	}
+}
+
+// skipAuthenticated 既に他の方法で認証済みのリクエストでjwtの検証を省略する
+func skipAuthenticated(c echo.Context) bool {
+	_, ok := c.Get("user").(*jwt.Token)
+	return ok
+}
+
+// normalizeScopes カンマ区切りのscopeを検証し、重複を除いて並べ替える
+func normalizeScopes(scopes string) (string, bool) {
+	set := map[string]bool{}
+	for _, s := range strings.Split(scopes, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !ApiKeyScopes[s] {
+			return "", false
+		}
+		set[s] = true
+	}
+	if len(set) == 0 {
+		return "", false
+	}
+	list := make([]string, 0, len(set))
+	for s := range set {
+		list = append(list, s)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ","), true
+}
+
+func generateApiKey() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	return apiKeyPrefix + strings.ToLower(enc.EncodeToString(buf)), nil
+}
+
+func hashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
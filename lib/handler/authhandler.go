@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"errors"
+	"net"
 	"net/http"
 	"os"
 	"time"
@@ -9,19 +11,51 @@ import (
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/greytabby/meowapi/lib/model"
+	"github.com/greytabby/meowapi/lib/password"
+	"github.com/greytabby/meowapi/lib/ratelimit"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 )
 
 type jwtCustomClaims struct {
-	UID  int64  `json:"uid"`
-	Name string `json:"name"`
+	UID         int64    `json:"uid"`
+	Name        string   `json:"name"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"perms"`
 	jwt.StandardClaims
 }
 
+func (c *jwtCustomClaims) Valid() error {
+	// Other tokens (mfa, oidc state) are signed with the same key
+	// and always have an audience, so reject them explicitly here.
+	if c.Audience != "" {
+		return errors.New("token can not be used as an access token")
+	}
+	return c.StandardClaims.Valid()
+}
+
+// mfaClaims パスワード認証後、TOTPによる2段階目の認証を待つ間の一時トークン
+type mfaClaims struct {
+	UID int64 `json:"uid"`
+	jwt.StandardClaims
+}
+
+func (c *mfaClaims) Valid() error {
+	if !c.VerifyAudience(mfaAudience, true) {
+		return errors.New("not a mfa token")
+	}
+	return c.StandardClaims.Valid()
+}
+
+const (
+	mfaAudience = "mfa"
+	mfaTokenTTL = 5 * time.Minute
+)
+
 var signingKey = []byte(os.Getenv("JWT_SIGNING_KEY"))
 
 var JWTConfig = middleware.JWTConfig{
+	Skipper:    skipAuthenticated,
 	Claims:     &jwtCustomClaims{},
 	SigningKey: signingKey,
 }
@@ -29,24 +63,52 @@ var JWTConfig = middleware.JWTConfig{
 // UserDbAccessor Userテーブルへのアクセスを行う
 type UserDbAccessor interface {
 	FindUser(name string) (model.User, error)
+	GetUser(id int64) (model.User, error)
 	AddUser(user model.User) error
-	DeleteUser(user model.User) error
+	DeleteUser(user model.User) ([]string, error)
 }
 
 // AuthHandler 認証に関するapihandler
 type AuthHandler struct {
 	Db UserDbAccessor
+	// IPLimiter 接続元IPごとのログイン失敗を制限する
+	IPLimiter *ratelimit.Limiter
+	// AccountLimiter ユーザ名ごとのログイン失敗を制限する(アカウントロック)
+	AccountLimiter *ratelimit.Limiter
+	// SignupLimiter 接続元IPごとのユーザ登録回数を制限する
+	SignupLimiter *ratelimit.Limiter
+	// PasswordPolicy ユーザ登録時のパスワード要件(nilの場合はpassword.DefaultPolicy)
+	PasswordPolicy *password.Policy
+	// TrustedProxies 接続元IPの転送ヘッダを信用する逆プロキシ
+	// 空の場合は転送ヘッダを参照せずRemoteAddrで制限する
+	TrustedProxies []*net.IPNet
 }
 
 // Signup ユーザ登録を行う
 func (ah *AuthHandler) Signup(c echo.Context) error {
 	var user model.User
 	var err error
+
+	// every signup request counts regardless of the result.
+	ip := clientIP(c, ah.TrustedProxies)
+	if wait := checkLimit(c, ah.SignupLimiter, ip); wait > 0 {
+		return tooManyRequests(c, wait)
+	}
+	recordFailure(c, ah.SignupLimiter, ip)
+
 	if err = c.Bind(&user); err != nil {
 		c.Logger().Errorf("Bind: ", err)
 		return c.String(http.StatusBadRequest, "Invalid field")
 	}
 
+	// 2fa is enabled only through the totp endpoints,
+	// and admins are created only by the admin command.
+	user.TotpEnabled = false
+	user.Role = model.UserRoleUser
+	if !model.ValidTimeZone(user.TimeZone) {
+		return c.String(http.StatusBadRequest, "Invalid time zone.")
+	}
+
 	// check the user already exist.
 	u, err := ah.Db.FindUser(user.Name)
 	if u.Id != 0 {
@@ -54,9 +116,21 @@ func (ah *AuthHandler) Signup(c echo.Context) error {
 		return c.String(http.StatusConflict, "User already exist")
 	}
 
-	// bcrypt password verify ignores 73 characters and more
-	if len(user.Password) > 72 {
-		return c.String(http.StatusBadRequest, "password length is 72 or less")
+	// bcrypt password verify ignores 73 characters and more,
+	// the policy rejects them along with weak or breached passwords.
+	policy := password.DefaultPolicy
+	if ah.PasswordPolicy != nil {
+		policy = *ah.PasswordPolicy
+	}
+	violations, err := policy.Validate(user.Password, user.Name)
+	if err != nil {
+		c.Logger().Error("password policy check failed.", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if len(violations) > 0 {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"errors": violations,
+		})
 	}
 
 	// create hash password
@@ -86,29 +160,52 @@ func (ah *AuthHandler) Login(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "Invalid field")
 	}
 
+	ip := clientIP(c, ah.TrustedProxies)
+	if wait := checkLimit(c, ah.IPLimiter, ip); wait > 0 {
+		return tooManyRequests(c, wait)
+	}
+	if wait := checkLimit(c, ah.AccountLimiter, requser.Name); wait > 0 {
+		return tooManyRequests(c, wait)
+	}
+
 	// check username and password
+	// do not log the attempted name, it is often a mistyped password.
 	loginUser, err := ah.Db.FindUser(requser.Name)
+	if err == nil {
+		err = passwordVerify(loginUser.Password, requser.Password)
+	}
 	if err != nil {
-		c.Logger().Errorf("Login: Invalid name", requser.Name, err)
+		c.Logger().Warnf("Login: failed attempt from %s", ip)
+		recordFailure(c, ah.IPLimiter, ip)
+		recordFailure(c, ah.AccountLimiter, requser.Name)
 		return c.String(http.StatusBadRequest, "Invalid name or password")
 	}
-	if err := passwordVerify(loginUser.Password, requser.Password); err != nil {
-		c.Logger().Errorf("Login: Invalid password", requser.Name, err)
-		return c.String(http.StatusBadRequest, "Invalid name or password")
+	resetLimit(c, ah.AccountLimiter, requser.Name)
+
+	return loginResponse(c, loginUser)
+}
+
+// loginResponse 認証済みのユーザにjwttokenを発行する
+// TOTPが有効なユーザには2段階目の認証のための一時トークンを発行する
+func loginResponse(c echo.Context, user model.User) error {
+	if user.Disabled {
+		return c.String(http.StatusForbidden, "Account is disabled")
 	}
 
-	// jwt authentication
-	claims := &jwtCustomClaims{
-		loginUser.Id,
-		loginUser.Name,
-		jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
-		},
+	// second step is required when totp is enabled.
+	if user.TotpEnabled {
+		t, err := issueMfaToken(user)
+		if err != nil {
+			c.Logger().Errorf("Login: create mfa token failed", err)
+			return c.String(http.StatusInternalServerError, "")
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"mfa_required": true,
+			"mfa_token":    t,
+		})
 	}
 
-	// create jwttoken
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	t, err := token.SignedString(signingKey)
+	t, err := issueToken(user)
 	if err != nil {
 		c.Logger().Errorf("Login: create token failed", err)
 		return c.String(http.StatusInternalServerError, "")
@@ -119,10 +216,73 @@ func (ah *AuthHandler) Login(c echo.Context) error {
 	})
 }
 
+// Active トークンのユーザが無効化、強制ログアウトされていないか確認するmiddleware
+// jwtによる認証の後に登録すること
+func (ah *AuthHandler) Active(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims := c.Get("user").(*jwt.Token).Claims.(*jwtCustomClaims)
+		user, err := ah.Db.GetUser(claims.UID)
+		if err != nil {
+			return c.String(http.StatusUnauthorized, "User not found")
+		}
+		if user.Disabled {
+			return c.String(http.StatusForbidden, "Account is disabled")
+		}
+		if claims.IssuedAt <= user.LogoutAt {
+			return c.String(http.StatusUnauthorized, "Token has been revoked")
+		}
+		return next(c)
+	}
+}
+
+// issueToken ユーザのjwttokenを発行する
+func issueToken(user model.User) (string, error) {
+	claims := &jwtCustomClaims{
+		UID:         user.Id,
+		Name:        user.Name,
+		Role:        user.Role,
+		Permissions: RolePermissions[user.Role],
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(signingKey)
+}
+
+// issueMfaToken TOTP認証を行うまでの短命なトークンを発行する
+func issueMfaToken(user model.User) (string, error) {
+	claims := &mfaClaims{
+		user.Id,
+		jwt.StandardClaims{
+			Audience:  mfaAudience,
+			ExpiresAt: time.Now().Add(mfaTokenTTL).Unix(),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(signingKey)
+}
+
+// parseMfaToken issueMfaTokenで発行したトークンを検証しuseridを返す
+func parseMfaToken(s string) (int64, error) {
+	claims := &mfaClaims{}
+	_, err := jwt.ParseWithClaims(s, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return signingKey, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return claims.UID, nil
+}
+
 func passwordHash(pw string) (string, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 	return string(hash), nil
 }
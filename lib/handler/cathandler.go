@@ -8,12 +8,12 @@ import (
 )
 
 type CatReader interface {
-	GetAllCats(uid int64) ([]model.Cat, error)
-	GetCat(id, uid int64) (model.Cat, error)
+	GetAllCats(hid int64) ([]model.Cat, error)
+	GetCat(id, hid int64) (model.Cat, error)
 }
 
 type CatManipulator interface {
-	AddCat(cat model.Cat) error
+	AddCat(cat model.Cat) (model.Cat, error)
 	UpdateCat(cat model.Cat) error
 	DeleteCat(cat model.Cat) error
 }
@@ -27,12 +27,16 @@ type CatDbAccessor interface {
 // CatHandler /api/catへのリクエストを処理する
 type CatHandler struct {
 	Db CatDbAccessor
+	// Photos nilでなければcatの削除時に画像も削除する
+	Photos *PhotoHandler
+	// Hooks nilでなければcatの追加/更新/削除をwebhookで通知する
+	Hooks *WebhookHandler
 }
 
 // GetAllCats catテーブルから全てのcatを返す
 func (ch *CatHandler) GetAllCats(c echo.Context) error {
-	uid := UserIdFromToken(c)
-	cats, err := ch.Db.GetAllCats(uid)
+	hid := HouseholdIdFromContext(c)
+	cats, err := ch.Db.GetAllCats(hid)
 	if err != nil {
 		c.Logger().Errorf("Select: ", err)
 		return c.String(http.StatusBadRequest, "Select: "+err.Error())
@@ -51,10 +55,17 @@ func (ch *CatHandler) AddCat(c echo.Context) error {
 	}
 
 	cat.UID = uid
-	if err := ch.Db.AddCat(cat); err != nil {
+	cat.HouseholdId = HouseholdIdFromContext(c)
+	// photos are set only through /api/cat/:id/photo.
+	cat.PhotoId = 0
+	cat, err := ch.Db.AddCat(cat)
+	if err != nil {
 		c.Logger().Errorf("Insert: ", err)
 		return c.String(http.StatusBadRequest, "Could not add new cat.")
 	}
+	if ch.Hooks != nil {
+		ch.Hooks.emit(c, model.WebhookEventCatCreated, cat)
+	}
 	c.Logger().Infof("Added: %#v", cat)
 	return c.String(http.StatusOK, "")
 }
@@ -73,8 +84,8 @@ func (ch *CatHandler) UpdateCat(c echo.Context) error {
 	}
 
 	// Get cat from db for confirming wheather the user specified cat exist.
-	uid := UserIdFromToken(c)
-	selectedCat, err := ch.Db.GetCat(cat.Id, uid)
+	hid := HouseholdIdFromContext(c)
+	selectedCat, err := ch.Db.GetCat(cat.Id, hid)
 	if err != nil {
 		c.Logger().Errorf("Select: ", err)
 		return c.String(http.StatusBadRequest, "No your specified cat in the database.")
@@ -84,11 +95,18 @@ func (ch *CatHandler) UpdateCat(c echo.Context) error {
 	selectedCat.Name = cat.Name
 	selectedCat.Breed = cat.Breed
 	selectedCat.Gender = cat.Gender
-	selectedCat.Age = cat.Age
+	// age is derived from birthdate, keep the birthdate when it is omitted.
+	if cat.BirthDate != nil {
+		selectedCat.BirthDate = cat.BirthDate
+		selectedCat.BirthDateEstimated = cat.BirthDateEstimated
+	}
 	if err := ch.Db.UpdateCat(selectedCat); err != nil {
 		c.Logger().Errorf("Update: ", err)
 		return c.String(http.StatusBadRequest, "Could not update cat info.")
 	}
+	if ch.Hooks != nil {
+		ch.Hooks.emit(c, model.WebhookEventCatUpdated, selectedCat)
+	}
 	c.Logger().Infof("Updated: %#v", selectedCat)
 	return c.String(http.StatusOK, "")
 }
@@ -106,8 +124,8 @@ func (ch *CatHandler) DeleteCat(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "Cat id is not specified.")
 	}
 
-	uid := UserIdFromToken(c)
-	selectedCat, err := ch.Db.GetCat(cat.Id, uid)
+	hid := HouseholdIdFromContext(c)
+	selectedCat, err := ch.Db.GetCat(cat.Id, hid)
 	if err != nil {
 		c.Logger().Errorf("Select: ", err)
 		return c.String(http.StatusBadRequest, "No your specified cat.")
@@ -117,6 +135,12 @@ func (ch *CatHandler) DeleteCat(c echo.Context) error {
 		c.Logger().Errorf("Delete: ", err)
 		return c.String(http.StatusBadRequest, "Failed delete the cat.")
 	}
+	if ch.Photos != nil && selectedCat.PhotoId != 0 {
+		ch.Photos.removePhotoById(c, selectedCat.PhotoId)
+	}
+	if ch.Hooks != nil {
+		ch.Hooks.emit(c, model.WebhookEventCatDeleted, selectedCat)
+	}
 	c.Logger().Infof("Deleted: %#v", selectedCat)
 	return c.String(http.StatusOK, "")
 }
@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/labstack/echo"
+)
+
+// DefaultWeightLossThreshold 体重減少を警告する30日または90日間の減少率(%)
+const DefaultWeightLossThreshold = 5.0
+
+// CatWeightDbAccessor catweightテーブルを操作するinterface
+type CatWeightDbAccessor interface {
+	CatReader
+	GetCatWeights(catid, hid int64) ([]model.CatWeight, error)
+	GetCatWeight(id, hid int64) (model.CatWeight, error)
+	AddCatWeight(w model.CatWeight) error
+	DeleteCatWeight(w model.CatWeight) error
+}
+
+// CatWeightHandler /api/cat/:id/weightsへのリクエストを処理する
+type CatWeightHandler struct {
+	Db CatWeightDbAccessor
+	// LossThreshold 体重減少を警告する減少率(%)。0の場合はDefaultWeightLossThreshold
+	LossThreshold float64
+}
+
+// GetCatWeights catの全ての体重の記録を返す
+func (wh *CatWeightHandler) GetCatWeights(c echo.Context) error {
+	cat, err := wh.selectCat(c)
+	if err != nil {
+		return err
+	}
+	ws, err := wh.Db.GetCatWeights(cat.Id, cat.HouseholdId)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, ws)
+}
+
+// AddCatWeight catの体重の記録を1件追加する
+// dateを省略した場合は現在日時とする
+func (wh *CatWeightHandler) AddCatWeight(c echo.Context) error {
+	cat, err := wh.selectCat(c)
+	if err != nil {
+		return err
+	}
+
+	var w model.CatWeight
+	if err := c.Bind(&w); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if w.Grams <= 0 {
+		return c.String(http.StatusBadRequest, "Grams must be positive.")
+	}
+	if w.Date.IsZero() {
+		w.Date = time.Now()
+	}
+
+	w.UID = UserIdFromToken(c)
+	w.HouseholdId = cat.HouseholdId
+	w.CatId = cat.Id
+	if err := wh.Db.AddCatWeight(w); err != nil {
+		c.Logger().Errorf("Insert: ", err)
+		return c.String(http.StatusInternalServerError, "Could not add weight.")
+	}
+	c.Logger().Infof("Added: %#v", w)
+	return c.String(http.StatusOK, "")
+}
+
+// DeleteCatWeight catの体重の記録を1件削除する
+func (wh *CatWeightHandler) DeleteCatWeight(c echo.Context) error {
+	cat, err := wh.selectCat(c)
+	if err != nil {
+		return err
+	}
+
+	var w model.CatWeight
+	if err := c.Bind(&w); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if w.Id == 0 {
+		return c.String(http.StatusBadRequest, "Weight id is not specified.")
+	}
+
+	selected, err := wh.Db.GetCatWeight(w.Id, cat.HouseholdId)
+	if err != nil || selected.CatId != cat.Id {
+		return c.String(http.StatusBadRequest, "No your specified weight.")
+	}
+	if err := wh.Db.DeleteCatWeight(selected); err != nil {
+		c.Logger().Errorf("Delete: ", err)
+		return c.String(http.StatusInternalServerError, "Failed delete the weight.")
+	}
+	c.Logger().Infof("Deleted: %#v", selected)
+	return c.String(http.StatusOK, "")
+}
+
+// GetCatWeightSummary 現在の体重と30日、90日間の変化率を返す
+func (wh *CatWeightHandler) GetCatWeightSummary(c echo.Context) error {
+	cat, err := wh.selectCat(c)
+	if err != nil {
+		return err
+	}
+	ws, err := wh.Db.GetCatWeights(cat.Id, cat.HouseholdId)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+
+	threshold := wh.LossThreshold
+	if threshold <= 0 {
+		threshold = DefaultWeightLossThreshold
+	}
+	return c.JSON(http.StatusOK, summarizeWeights(cat.Id, ws, threshold))
+}
+
+// selectCat パスパラメータ:idのcatを取得する
+// 取得できなかった場合はそのままhandlerから返すerrorを返す
+func (wh *CatWeightHandler) selectCat(c echo.Context) (model.Cat, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Logger().Errorf("Param parse: ", err)
+		return model.Cat{}, echo.NewHTTPError(http.StatusBadRequest, "Param parse: "+err.Error())
+	}
+	cat, err := wh.Db.GetCat(id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return model.Cat{}, echo.NewHTTPError(http.StatusNotFound, "No your specified cat.")
+	}
+	return cat, nil
+}
+
+// summarizeWeights 日付順の体重の記録から推移を求める
+func summarizeWeights(catid int64, ws []model.CatWeight, threshold float64) model.CatWeightSummary {
+	s := model.CatWeightSummary{CatId: catid, Threshold: threshold}
+	if len(ws) == 0 {
+		return s
+	}
+	current := ws[len(ws)-1]
+	s.Current = &current
+	s.Change30 = weightChange(ws, current, 30*24*time.Hour)
+	s.Change90 = weightChange(ws, current, 90*24*time.Hour)
+	for _, ch := range []*float64{s.Change30, s.Change90} {
+		if ch != nil && *ch <= -threshold {
+			s.LossAlert = true
+		}
+	}
+	return s
+}
+
+// weightChange currentと、currentからperiod以上前の最も新しい記録との変化率(%)を返す
+func weightChange(ws []model.CatWeight, current model.CatWeight, period time.Duration) *float64 {
+	since := current.Date.Add(-period)
+	for i := len(ws) - 1; i >= 0; i-- {
+		if ws[i].Date.After(since) || ws[i].Grams <= 0 {
+			continue
+		}
+		ch := float64(current.Grams-ws[i].Grams) / float64(ws[i].Grams) * 100
+		return &ch
+	}
+	return nil
+}
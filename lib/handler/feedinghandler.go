@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/labstack/echo"
+)
+
+type FeedingReader interface {
+	GetFeedings(hid int64, f model.RecordFilter) ([]model.Feeding, error)
+	GetFeeding(id, hid int64) (model.Feeding, error)
+}
+
+type FeedingManipulator interface {
+	AddFeeding(f model.Feeding) error
+	UpdateFeeding(f model.Feeding) error
+	DeleteFeeding(f model.Feeding) error
+}
+
+// FeedingDbAccessor feedingテーブルを操作するinterface
+type FeedingDbAccessor interface {
+	CatReader
+	FeedingReader
+	FeedingManipulator
+}
+
+// FeedingHandler /api/feedingへのリクエストを処理する
+type FeedingHandler struct {
+	Db FeedingDbAccessor
+}
+
+// GetFeedings 食事の記録を返す
+// cat_id, from, toで絞り込める
+func (fh *FeedingHandler) GetFeedings(c echo.Context) error {
+	f, err := parseRecordFilter(c)
+	if err != nil {
+		return err
+	}
+	fs, err := fh.Db.GetFeedings(HouseholdIdFromContext(c), f)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, fs)
+}
+
+// AddFeeding 食事の記録を1件追加する
+// occurredatを省略した場合は現在日時とする
+func (fh *FeedingHandler) AddFeeding(c echo.Context) error {
+	var feeding model.Feeding
+
+	if err := c.Bind(&feeding); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	hid := HouseholdIdFromContext(c)
+	if _, err := fh.Db.GetCat(feeding.CatId, hid); err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "No your specified cat.")
+	}
+	if !validFoodKind(feeding.Kind) {
+		return c.String(http.StatusBadRequest, "Kind must be wet or dry.")
+	}
+	if feeding.Grams <= 0 {
+		return c.String(http.StatusBadRequest, "Grams must be positive.")
+	}
+	if feeding.OccurredAt.IsZero() {
+		feeding.OccurredAt = time.Now()
+	}
+
+	feeding.UID = UserIdFromToken(c)
+	feeding.HouseholdId = hid
+	if err := fh.Db.AddFeeding(feeding); err != nil {
+		c.Logger().Errorf("Insert: ", err)
+		return c.String(http.StatusBadRequest, "Could not add new feeding.")
+	}
+	c.Logger().Infof("Added: %#v", feeding)
+	return c.String(http.StatusOK, "")
+}
+
+// UpdateFeeding 食事の記録を1件更新する
+func (fh *FeedingHandler) UpdateFeeding(c echo.Context) error {
+	var feeding model.Feeding
+
+	if err := c.Bind(&feeding); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if feeding.Id == 0 {
+		return c.String(http.StatusBadRequest, "Feeding id not specified.")
+	}
+
+	hid := HouseholdIdFromContext(c)
+	selected, err := fh.Db.GetFeeding(feeding.Id, hid)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "No your specified feeding.")
+	}
+	if feeding.CatId != 0 && feeding.CatId != selected.CatId {
+		if _, err := fh.Db.GetCat(feeding.CatId, hid); err != nil {
+			c.Logger().Errorf("Select: ", err)
+			return c.String(http.StatusBadRequest, "No your specified cat.")
+		}
+		selected.CatId = feeding.CatId
+	}
+	if feeding.Kind != "" {
+		if !validFoodKind(feeding.Kind) {
+			return c.String(http.StatusBadRequest, "Kind must be wet or dry.")
+		}
+		selected.Kind = feeding.Kind
+	}
+	if feeding.Grams < 0 {
+		return c.String(http.StatusBadRequest, "Grams must be positive.")
+	}
+	if feeding.Grams > 0 {
+		selected.Grams = feeding.Grams
+	}
+	if !feeding.OccurredAt.IsZero() {
+		selected.OccurredAt = feeding.OccurredAt
+	}
+	selected.FoodType = feeding.FoodType
+	if err := fh.Db.UpdateFeeding(selected); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusBadRequest, "Could not update feeding.")
+	}
+	c.Logger().Infof("Updated: %#v", selected)
+	return c.String(http.StatusOK, "")
+}
+
+// DeleteFeeding 食事の記録を1件削除する
+func (fh *FeedingHandler) DeleteFeeding(c echo.Context) error {
+	var feeding model.Feeding
+
+	if err := c.Bind(&feeding); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if feeding.Id == 0 {
+		return c.String(http.StatusBadRequest, "Feeding id is not specified.")
+	}
+
+	selected, err := fh.Db.GetFeeding(feeding.Id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "No your specified feeding.")
+	}
+	if err := fh.Db.DeleteFeeding(selected); err != nil {
+		c.Logger().Errorf("Delete: ", err)
+		return c.String(http.StatusBadRequest, "Failed delete the feeding.")
+	}
+	c.Logger().Infof("Deleted: %#v", selected)
+	return c.String(http.StatusOK, "")
+}
+
+func validFoodKind(kind string) bool {
+	return kind == model.FoodKindWet || kind == model.FoodKindDry
+}
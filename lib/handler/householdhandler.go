@@ -0,0 +1,277 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/labstack/echo"
+)
+
+const (
+	// HouseholdHeader 操作対象のhouseholdを指定するヘッダ
+	// 指定が無い場合はユーザが最初に所属したhouseholdを対象とする
+	HouseholdHeader = "X-Household-Id"
+
+	invitationTTL = 7 * 24 * time.Hour
+)
+
+// HouseholdDbAccessor household関連のテーブルを操作するinterface
+type HouseholdDbAccessor interface {
+	GetHouseholdMemberships(uid int64) ([]model.HouseholdMembership, error)
+	GetHouseholdMember(hid, uid int64) (model.HouseholdMember, error)
+	GetDefaultHouseholdMember(uid int64) (model.HouseholdMember, error)
+	GetHouseholdMembers(hid int64) ([]model.HouseholdMember, error)
+	CountHouseholdOwners(hid int64) (int64, error)
+	AddHousehold(h model.Household, owner int64) (model.Household, error)
+	AddHouseholdMember(m model.HouseholdMember) error
+	UpdateHouseholdMember(m model.HouseholdMember) error
+	DeleteHouseholdMember(m model.HouseholdMember) error
+	AddHouseholdInvitation(inv model.HouseholdInvitation) error
+	AcceptHouseholdInvitation(code string, uid int64) (model.HouseholdMember, error)
+}
+
+// HouseholdHandler /api/householdへのリクエストを処理する
+type HouseholdHandler struct {
+	Db HouseholdDbAccessor
+}
+
+// Middleware リクエストの対象となるhouseholdを決定し、所属を確認するmiddleware
+func (hh *HouseholdHandler) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		uid := UserIdFromToken(c)
+
+		var m model.HouseholdMember
+		var err error
+		if v := c.Request().Header.Get(HouseholdHeader); v != "" {
+			hid, perr := strconv.ParseInt(v, 10, 64)
+			if perr != nil {
+				return c.String(http.StatusBadRequest, "Invalid "+HouseholdHeader)
+			}
+			m, err = hh.Db.GetHouseholdMember(hid, uid)
+		} else {
+			m, err = hh.Db.GetDefaultHouseholdMember(uid)
+		}
+		if err != nil {
+			c.Logger().Errorf("Select: ", err)
+			return c.String(http.StatusForbidden, "You are not a member of the household.")
+		}
+
+		c.Set("household", m)
+		return next(c)
+	}
+}
+
+// Writable householdのデータを更新する権限を確認するmiddleware
+// viewerは参照(GET)のみ許可する。Middlewareの後に登録すること
+func (hh *HouseholdHandler) Writable(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		m := HouseholdFromContext(c)
+		if c.Request().Method != http.MethodGet && !m.CanWrite() {
+			return c.String(http.StatusForbidden, "Role "+m.Role+" can not modify the household.")
+		}
+		return next(c)
+	}
+}
+
+// HouseholdFromContext Middlewareで決定したhouseholdでのユーザの所属情報を返す
+func HouseholdFromContext(c echo.Context) model.HouseholdMember {
+	return c.Get("household").(model.HouseholdMember)
+}
+
+// HouseholdIdFromContext Middlewareで決定したhouseholdのidを返す
+func HouseholdIdFromContext(c echo.Context) int64 {
+	return HouseholdFromContext(c).HouseholdId
+}
+
+// GetHouseholds ユーザが所属する全てのhouseholdを返す
+func (hh *HouseholdHandler) GetHouseholds(c echo.Context) error {
+	uid := UserIdFromToken(c)
+	ms, err := hh.Db.GetHouseholdMemberships(uid)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, ms)
+}
+
+// AddHousehold householdを作成し、作成したユーザをownerにする
+func (hh *HouseholdHandler) AddHousehold(c echo.Context) error {
+	var h model.Household
+	if err := c.Bind(&h); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if h.Name == "" {
+		return c.String(http.StatusBadRequest, "Name is not specified.")
+	}
+
+	uid := UserIdFromToken(c)
+	h, err := hh.Db.AddHousehold(h, uid)
+	if err != nil {
+		c.Logger().Errorf("Insert: ", err)
+		return c.String(http.StatusInternalServerError, "Could not add new household.")
+	}
+	c.Logger().Infof("Added: %#v", h)
+	return c.JSON(http.StatusOK, h)
+}
+
+// GetMembers householdに所属する全てのユーザを返す
+func (hh *HouseholdHandler) GetMembers(c echo.Context) error {
+	hid := HouseholdIdFromContext(c)
+	ms, err := hh.Db.GetHouseholdMembers(hid)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, ms)
+}
+
+// UpdateMember メンバーの役割を変更する(ownerのみ)
+func (hh *HouseholdHandler) UpdateMember(c echo.Context) error {
+	var m model.HouseholdMember
+	if err := c.Bind(&m); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if !model.ValidRole(m.Role) {
+		return c.String(http.StatusBadRequest, "Invalid role.")
+	}
+
+	me := HouseholdFromContext(c)
+	if me.Role != model.RoleOwner {
+		return c.String(http.StatusForbidden, "Only owner can change roles.")
+	}
+	selected, err := hh.Db.GetHouseholdMember(me.HouseholdId, m.UID)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "No your specified member.")
+	}
+	if selected.Role == model.RoleOwner && m.Role != model.RoleOwner {
+		if ok, err := hh.hasOtherOwner(me.HouseholdId); err != nil {
+			c.Logger().Errorf("Select: ", err)
+			return c.String(http.StatusInternalServerError, "")
+		} else if !ok {
+			return c.String(http.StatusConflict, "Household must have at least one owner.")
+		}
+	}
+
+	selected.Role = m.Role
+	if err := hh.Db.UpdateHouseholdMember(selected); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusInternalServerError, "Could not update member.")
+	}
+	c.Logger().Infof("Updated: %#v", selected)
+	return c.String(http.StatusOK, "")
+}
+
+// DeleteMember メンバーをhouseholdから外す
+// ownerは誰でも、それ以外は自分自身のみ外すことができる
+func (hh *HouseholdHandler) DeleteMember(c echo.Context) error {
+	var m model.HouseholdMember
+	if err := c.Bind(&m); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+
+	me := HouseholdFromContext(c)
+	if me.Role != model.RoleOwner && me.UID != m.UID {
+		return c.String(http.StatusForbidden, "Only owner can remove other members.")
+	}
+	selected, err := hh.Db.GetHouseholdMember(me.HouseholdId, m.UID)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "No your specified member.")
+	}
+	if selected.Role == model.RoleOwner {
+		if ok, err := hh.hasOtherOwner(me.HouseholdId); err != nil {
+			c.Logger().Errorf("Select: ", err)
+			return c.String(http.StatusInternalServerError, "")
+		} else if !ok {
+			return c.String(http.StatusConflict, "Household must have at least one owner.")
+		}
+	}
+
+	if err := hh.Db.DeleteHouseholdMember(selected); err != nil {
+		c.Logger().Errorf("Delete: ", err)
+		return c.String(http.StatusInternalServerError, "Failed delete the member.")
+	}
+	c.Logger().Infof("Deleted: %#v", selected)
+	return c.String(http.StatusOK, "")
+}
+
+// AddInvitation householdへの招待コードを発行する(ownerのみ)
+func (hh *HouseholdHandler) AddInvitation(c echo.Context) error {
+	var inv model.HouseholdInvitation
+	if err := c.Bind(&inv); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if inv.Role == "" {
+		inv.Role = model.RoleMember
+	}
+	if !model.ValidRole(inv.Role) {
+		return c.String(http.StatusBadRequest, "Invalid role.")
+	}
+
+	me := HouseholdFromContext(c)
+	if me.Role != model.RoleOwner {
+		return c.String(http.StatusForbidden, "Only owner can invite members.")
+	}
+
+	code, err := generateInvitationCode()
+	if err != nil {
+		c.Logger().Error("Generate invitation code failed.", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	inv.HouseholdId = me.HouseholdId
+	inv.Code = code
+	inv.CreatedBy = me.UID
+	inv.Expires = time.Now().Add(invitationTTL)
+	if err := hh.Db.AddHouseholdInvitation(inv); err != nil {
+		c.Logger().Errorf("Insert: ", err)
+		return c.String(http.StatusInternalServerError, "Could not add invitation.")
+	}
+	return c.JSON(http.StatusOK, inv)
+}
+
+// Join 招待コードを使用してhouseholdに参加する
+func (hh *HouseholdHandler) Join(c echo.Context) error {
+	var inv model.HouseholdInvitation
+	if err := c.Bind(&inv); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if inv.Code == "" {
+		return c.String(http.StatusBadRequest, "Code is not specified.")
+	}
+
+	uid := UserIdFromToken(c)
+	m, err := hh.Db.AcceptHouseholdInvitation(inv.Code, uid)
+	if err != nil {
+		c.Logger().Errorf("Join: ", err)
+		return c.String(http.StatusBadRequest, "Invalid or expired invitation code.")
+	}
+	c.Logger().Infof("Joined: %#v", m)
+	return c.JSON(http.StatusOK, m)
+}
+
+// hasOtherOwner householdに2人以上のownerがいるか判定する
+func (hh *HouseholdHandler) hasOtherOwner(hid int64) (bool, error) {
+	n, err := hh.Db.CountHouseholdOwners(hid)
+	if err != nil {
+		return false, err
+	}
+	return n > 1, nil
+}
+
+func generateInvitationCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf), nil
+}
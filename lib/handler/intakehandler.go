@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/labstack/echo"
+)
+
+const (
+	defaultSummaryDays = 7
+	maxSummaryDays     = 90
+)
+
+// IntakeDbAccessor 食事、飲水とトイレの記録を集計するためのinterface
+type IntakeDbAccessor interface {
+	CatReader
+	FeedingReader
+	WaterIntakeReader
+	GetUser(id int64) (model.User, error)
+	GetUseToilets(hid int64, f model.UseToiletFilter) ([]model.UseToilet, error)
+}
+
+// IntakeHandler /api/intakeへのリクエストを処理する
+type IntakeHandler struct {
+	Db IntakeDbAccessor
+}
+
+// GetIntakeSummary catの1日ごとの食事、飲水の量とトイレの回数を返す
+// 今日を含む直近days日分を、ユーザのタイムゾーンの日付で集計する
+func (ih *IntakeHandler) GetIntakeSummary(c echo.Context) error {
+	catid, err := strconv.ParseInt(c.QueryParam("cat_id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid cat_id.")
+	}
+	days, err := queryInt(c, "days", defaultSummaryDays)
+	if err != nil || days <= 0 || days > maxSummaryDays {
+		return c.String(http.StatusBadRequest, "Invalid days.")
+	}
+
+	hid := HouseholdIdFromContext(c)
+	if _, err := ih.Db.GetCat(catid, hid); err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusNotFound, "No your specified cat.")
+	}
+	user, err := ih.Db.GetUser(UserIdFromToken(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	loc := user.Location()
+	now := time.Now().In(loc)
+	to := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
+	from := to.AddDate(0, 0, -days)
+	f := model.RecordFilter{CatId: catid, From: &from, To: &to}
+
+	fs, err := ih.Db.GetFeedings(hid, f)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	ws, err := ih.Db.GetWaterIntakes(hid, f)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	uts, err := ih.Db.GetUseToilets(hid, model.UseToiletFilter{RecordFilter: f})
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, summarizeIntake(from, days, loc, fs, ws, uts))
+}
+
+// summarizeIntake fromからdays日分の記録を日付ごとに集計する
+func summarizeIntake(from time.Time, days int, loc *time.Location, fs []model.Feeding, ws []model.WaterIntake, uts []model.UseToilet) []model.IntakeDay {
+	summary := make([]model.IntakeDay, days)
+	index := map[string]int{}
+	for i := range summary {
+		date := from.AddDate(0, 0, i).Format("2006-01-02")
+		summary[i] = model.IntakeDay{Date: date, UseToilet: map[string]int64{}}
+		index[date] = i
+	}
+	day := func(t time.Time) *model.IntakeDay {
+		i, ok := index[t.In(loc).Format("2006-01-02")]
+		if !ok {
+			return nil
+		}
+		return &summary[i]
+	}
+
+	for _, f := range fs {
+		if d := day(f.OccurredAt); d != nil {
+			d.Feedings++
+			if f.Kind == model.FoodKindWet {
+				d.WetGrams += f.Grams
+			} else {
+				d.DryGrams += f.Grams
+			}
+		}
+	}
+	for _, w := range ws {
+		if d := day(w.OccurredAt); d != nil {
+			d.WaterMl += w.Milliliters
+		}
+	}
+	for _, ut := range uts {
+		if d := day(ut.Created); d != nil {
+			d.UseToilet[ut.Type]++
+		}
+	}
+	return summary
+}
@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/labstack/echo"
+)
+
+// DefaultLitterWeeks 砂の消費量の平均をとる週数
+const DefaultLitterWeeks = 4
+
+// LitterReader 砂の在庫と消費量を参照するinterface
+type LitterReader interface {
+	GetLitters(hid int64) ([]model.Litter, error)
+	GetLitter(id, hid int64) (model.Litter, error)
+	GetLitterUsage(hid int64, since time.Time) ([]model.LitterUsage, error)
+}
+
+// LitterDbAccessor litterテーブルを操作するinterface
+type LitterDbAccessor interface {
+	LitterReader
+	AddLitter(l model.Litter) error
+	UpdateLitter(l model.Litter) error
+	DeleteLitter(l model.Litter) error
+	RestockLitter(id, hid, grams int64, now time.Time) error
+}
+
+// LitterHandler /api/litterへのリクエストを処理する
+type LitterHandler struct {
+	Db LitterDbAccessor
+	// Weeks 砂の消費量の平均をとる週数。0の場合はDefaultLitterWeeks
+	Weeks int
+}
+
+// GetLitters householdの砂の在庫を消費量と在庫が無くなる日の予測とともに返す
+func (lh *LitterHandler) GetLitters(c echo.Context) error {
+	ls, err := LitterForecasts(lh.Db, lh.Weeks, HouseholdIdFromContext(c), time.Now())
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, ls)
+}
+
+// GetLowStock householdの在庫が少ない砂を在庫が無くなる日の早い順に返す
+func (lh *LitterHandler) GetLowStock(c echo.Context) error {
+	ls, err := LitterForecasts(lh.Db, lh.Weeks, HouseholdIdFromContext(c), time.Now())
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	low := []model.Litter{}
+	for _, l := range ls {
+		if l.Forecast.LowStock {
+			low = append(low, l)
+		}
+	}
+	// empty stock without consumption comes first, it has no run-out date.
+	sort.SliceStable(low, func(i, j int) bool {
+		ri, rj := low[i].Forecast.RunOut, low[j].Forecast.RunOut
+		return ri == nil && rj != nil || ri != nil && rj != nil && ri.Before(*rj)
+	})
+	return c.JSON(http.StatusOK, low)
+}
+
+// AddLitter householdに砂の在庫を1件追加する
+func (lh *LitterHandler) AddLitter(c echo.Context) error {
+	var l model.Litter
+	if err := c.Bind(&l); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if err := validateLitter(l); err != nil {
+		return err
+	}
+	l.Id = 0
+	l.UID = UserIdFromToken(c)
+	l.HouseholdId = HouseholdIdFromContext(c)
+	l.Restocked = time.Now()
+	if err := lh.Db.AddLitter(l); err != nil {
+		c.Logger().Errorf("Insert: ", err)
+		return c.String(http.StatusInternalServerError, "Could not add litter.")
+	}
+	return c.String(http.StatusOK, "")
+}
+
+// UpdateLitter householdの砂の在庫を1件更新する
+// 在庫が増えた場合は補充したとみなす
+func (lh *LitterHandler) UpdateLitter(c echo.Context) error {
+	var l model.Litter
+	if err := c.Bind(&l); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if l.Id == 0 {
+		return c.String(http.StatusBadRequest, "Litter id not specified.")
+	}
+	if err := validateLitter(l); err != nil {
+		return err
+	}
+	selected, err := lh.Db.GetLitter(l.Id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusNotFound, "No your specified litter.")
+	}
+
+	if l.StockGrams > selected.StockGrams {
+		selected.Restocked = time.Now()
+	}
+	selected.Brand = l.Brand
+	selected.BagGrams = l.BagGrams
+	selected.StockGrams = l.StockGrams
+	selected.LowStockDays = l.LowStockDays
+	if err := lh.Db.UpdateLitter(selected); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusInternalServerError, "Could not update litter.")
+	}
+	return c.String(http.StatusOK, "")
+}
+
+// DeleteLitter householdの砂の在庫を1件削除する
+func (lh *LitterHandler) DeleteLitter(c echo.Context) error {
+	var l model.Litter
+	if err := c.Bind(&l); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if l.Id == 0 {
+		return c.String(http.StatusBadRequest, "Litter id not specified.")
+	}
+	selected, err := lh.Db.GetLitter(l.Id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusNotFound, "No your specified litter.")
+	}
+	if err := lh.Db.DeleteLitter(selected); err != nil {
+		c.Logger().Errorf("Delete: ", err)
+		return c.String(http.StatusInternalServerError, "Could not delete litter.")
+	}
+	return c.String(http.StatusOK, "")
+}
+
+// restockRequest 補充する量。bagsは袋の数でbaggramsを掛けた量になり、gramsと合計する
+type restockRequest struct {
+	Bags  int64 `json:"bags"`
+	Grams int64 `json:"grams"`
+}
+
+// Restock 砂の在庫を補充する
+func (lh *LitterHandler) Restock(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid litter id.")
+	}
+	var req restockRequest
+	if err := c.Bind(&req); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if req.Bags < 0 || req.Grams < 0 {
+		return c.String(http.StatusBadRequest, "Invalid amount.")
+	}
+	hid := HouseholdIdFromContext(c)
+	l, err := lh.Db.GetLitter(id, hid)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusNotFound, "No your specified litter.")
+	}
+	grams := req.Bags*l.BagGrams + req.Grams
+	if grams <= 0 {
+		return c.String(http.StatusBadRequest, "Invalid amount.")
+	}
+	if err := lh.Db.RestockLitter(l.Id, hid, grams, time.Now()); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusInternalServerError, "Could not restock litter.")
+	}
+	return c.String(http.StatusOK, "")
+}
+
+// LitterForecasts householdの砂の在庫に、直近weeks週の消費量からの予測を付けて返す
+// weeksが0の場合はDefaultLitterWeeks
+func LitterForecasts(db LitterReader, weeks int, hid int64, now time.Time) ([]model.Litter, error) {
+	if weeks <= 0 {
+		weeks = DefaultLitterWeeks
+	}
+	ls, err := db.GetLitters(hid)
+	if err != nil {
+		return nil, err
+	}
+	since := now.AddDate(0, 0, -7*weeks)
+	usage, err := db.GetLitterUsage(hid, since)
+	if err != nil {
+		return nil, err
+	}
+	used := map[int64]int64{}
+	for _, u := range usage {
+		used[u.LitterId] = u.Grams
+	}
+	for i := range ls {
+		f := forecastLitter(ls[i], used[ls[i].Id], since, now)
+		ls[i].Forecast = &f
+	}
+	return ls, nil
+}
+
+// forecastLitter since以降に使ったusedから週ごとの消費量を求め、在庫が無くなる日を予測する
+// since以降に登録した在庫は登録してからの期間で平均するが、1週間より短い期間は1週間とみなす
+func forecastLitter(l model.Litter, used int64, since, now time.Time) model.LitterForecast {
+	if l.Created.After(since) {
+		since = l.Created
+	}
+	weeks := now.Sub(since).Hours() / (7 * 24)
+	if weeks < 1 {
+		weeks = 1
+	}
+	f := model.LitterForecast{WeeklyGrams: float64(used) / weeks}
+	lowDays := l.LowStockDays
+	if lowDays <= 0 {
+		lowDays = model.DefaultLowStockDays
+	}
+	if f.WeeklyGrams > 0 {
+		days := float64(l.StockGrams) / (f.WeeklyGrams / 7)
+		runOut := now.Add(time.Duration(days * 24 * float64(time.Hour)))
+		f.DaysLeft = &days
+		f.RunOut = &runOut
+	}
+	f.LowStock = l.StockGrams <= 0 || f.DaysLeft != nil && *f.DaysLeft <= float64(lowDays)
+	return f
+}
+
+// validateLitter 砂の在庫を検証する
+// 不正な場合はそのままhandlerから返すerrorを返す
+func validateLitter(l model.Litter) error {
+	if l.Brand == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Brand is required.")
+	}
+	if l.BagGrams < 0 || l.StockGrams < 0 || l.LowStockDays < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid amount.")
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+func TestForecastLitter(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	since := now.AddDate(0, 0, -28)
+	day := 24 * time.Hour
+	for _, tt := range []struct {
+		name       string
+		litter     model.Litter
+		used       int64
+		wantWeekly float64
+		// wantDays negative means no forecast.
+		wantDays float64
+		wantLow  bool
+	}{
+		{
+			name:       "older than the period",
+			litter:     model.Litter{StockGrams: 14000, Created: now.AddDate(0, 0, -60)},
+			used:       2800,
+			wantWeekly: 700,
+			wantDays:   140,
+		},
+		{
+			name:       "registered within the period",
+			litter:     model.Litter{StockGrams: 7000, Created: now.Add(-14 * day)},
+			used:       1400,
+			wantWeekly: 700,
+			wantDays:   70,
+		},
+		{
+			name:       "younger than a week counts as a week",
+			litter:     model.Litter{StockGrams: 1000, Created: now.Add(-2 * day)},
+			used:       700,
+			wantWeekly: 700,
+			wantDays:   10,
+			wantLow:    true,
+		},
+		{
+			name:     "zero usage",
+			litter:   model.Litter{StockGrams: 5000, Created: now.AddDate(0, 0, -60)},
+			wantDays: -1,
+		},
+		{
+			name:     "empty stock and zero usage",
+			litter:   model.Litter{StockGrams: 0, Created: now.AddDate(0, 0, -60)},
+			wantDays: -1,
+			wantLow:  true,
+		},
+		{
+			name:       "empty stock",
+			litter:     model.Litter{StockGrams: 0, Created: now.AddDate(0, 0, -60)},
+			used:       2800,
+			wantWeekly: 700,
+			wantDays:   0,
+			wantLow:    true,
+		},
+		{
+			name:       "own low stock days",
+			litter:     model.Litter{StockGrams: 1000, LowStockDays: 7, Created: now.AddDate(0, 0, -60)},
+			used:       2800,
+			wantWeekly: 700,
+			wantDays:   10,
+		},
+	} {
+		f := forecastLitter(tt.litter, tt.used, since, now)
+		if math.Abs(f.WeeklyGrams-tt.wantWeekly) > 1e-9 {
+			t.Errorf("%s: weekly grams = %v, want %v", tt.name, f.WeeklyGrams, tt.wantWeekly)
+		}
+		if tt.wantDays < 0 {
+			if f.DaysLeft != nil || f.RunOut != nil {
+				t.Errorf("%s: forecast without usage: days left %v, run out %v", tt.name, f.DaysLeft, f.RunOut)
+			}
+		} else if f.DaysLeft == nil || f.RunOut == nil {
+			t.Errorf("%s: no forecast", tt.name)
+		} else {
+			if math.Abs(*f.DaysLeft-tt.wantDays) > 1e-9 {
+				t.Errorf("%s: days left = %v, want %v", tt.name, *f.DaysLeft, tt.wantDays)
+			}
+			if want := now.Add(time.Duration(tt.wantDays) * day); !f.RunOut.Equal(want) {
+				t.Errorf("%s: run out = %v, want %v", tt.name, f.RunOut, want)
+			}
+		}
+		if f.LowStock != tt.wantLow {
+			t.Errorf("%s: low stock = %v, want %v", tt.name, f.LowStock, tt.wantLow)
+		}
+	}
+}
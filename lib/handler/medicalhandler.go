@@ -0,0 +1,426 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/labstack/echo"
+)
+
+// DefaultUpcomingDays 接種予定を返す期間(日)の既定値
+const DefaultUpcomingDays = 30
+
+// MedicalDbAccessor vetvisit, vaccination, catconditionテーブルを操作するinterface
+type MedicalDbAccessor interface {
+	CatReader
+	GetVetVisits(hid int64, f model.RecordFilter) ([]model.VetVisit, error)
+	GetVetVisit(id, hid int64) (model.VetVisit, error)
+	AddVetVisit(v model.VetVisit) error
+	UpdateVetVisit(v model.VetVisit) error
+	DeleteVetVisit(v model.VetVisit) error
+	GetVaccinations(hid int64, f model.RecordFilter) ([]model.Vaccination, error)
+	GetUpcomingVaccinations(hid int64, until time.Time) ([]model.Vaccination, error)
+	GetVaccination(id, hid int64) (model.Vaccination, error)
+	AddVaccination(v model.Vaccination) error
+	UpdateVaccination(v model.Vaccination) error
+	DeleteVaccination(v model.Vaccination) error
+	GetConditions(hid int64, f model.RecordFilter, kind string, activeOnly bool) ([]model.Condition, error)
+	GetCondition(id, hid int64) (model.Condition, error)
+	AddCondition(cd model.Condition) error
+	UpdateCondition(cd model.Condition) error
+	DeleteCondition(cd model.Condition) error
+}
+
+// MedicalHandler /api/medicalへのリクエストを処理する
+type MedicalHandler struct {
+	Db MedicalDbAccessor
+}
+
+// GetVetVisits 受診記録を返す
+// cat_id, from, toで絞り込める
+func (mh *MedicalHandler) GetVetVisits(c echo.Context) error {
+	f, err := parseRecordFilter(c)
+	if err != nil {
+		return err
+	}
+	vs, err := mh.Db.GetVetVisits(HouseholdIdFromContext(c), f)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, vs)
+}
+
+// AddVetVisit 受診記録を1件追加する
+func (mh *MedicalHandler) AddVetVisit(c echo.Context) error {
+	var v model.VetVisit
+	if err := c.Bind(&v); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	cat, err := mh.selectCat(c, v.CatId)
+	if err != nil {
+		return err
+	}
+	if v.Date.IsZero() {
+		return c.String(http.StatusBadRequest, "Date is not specified.")
+	}
+	if v.Cost < 0 {
+		return c.String(http.StatusBadRequest, "Cost must not be negative.")
+	}
+
+	v.UID = UserIdFromToken(c)
+	v.HouseholdId = cat.HouseholdId
+	if err := mh.Db.AddVetVisit(v); err != nil {
+		c.Logger().Errorf("Insert: ", err)
+		return c.String(http.StatusInternalServerError, "Could not add vet visit.")
+	}
+	c.Logger().Infof("Added: %#v", v)
+	return c.String(http.StatusOK, "")
+}
+
+// UpdateVetVisit 受診記録を1件更新する
+func (mh *MedicalHandler) UpdateVetVisit(c echo.Context) error {
+	var v model.VetVisit
+	if err := c.Bind(&v); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if v.Id == 0 {
+		return c.String(http.StatusBadRequest, "Vet visit id is not specified.")
+	}
+	selected, err := mh.Db.GetVetVisit(v.Id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "No your specified vet visit.")
+	}
+	if v.Cost < 0 {
+		return c.String(http.StatusBadRequest, "Cost must not be negative.")
+	}
+
+	if !v.Date.IsZero() {
+		selected.Date = v.Date
+	}
+	selected.Clinic = v.Clinic
+	selected.Reason = v.Reason
+	selected.Diagnosis = v.Diagnosis
+	selected.Cost = v.Cost
+	if err := mh.Db.UpdateVetVisit(selected); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusInternalServerError, "Could not update vet visit.")
+	}
+	c.Logger().Infof("Updated: %#v", selected)
+	return c.String(http.StatusOK, "")
+}
+
+// DeleteVetVisit 受診記録を1件削除する
+func (mh *MedicalHandler) DeleteVetVisit(c echo.Context) error {
+	var v model.VetVisit
+	if err := c.Bind(&v); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if v.Id == 0 {
+		return c.String(http.StatusBadRequest, "Vet visit id is not specified.")
+	}
+	selected, err := mh.Db.GetVetVisit(v.Id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "No your specified vet visit.")
+	}
+	if err := mh.Db.DeleteVetVisit(selected); err != nil {
+		c.Logger().Errorf("Delete: ", err)
+		return c.String(http.StatusInternalServerError, "Failed delete the vet visit.")
+	}
+	c.Logger().Infof("Deleted: %#v", selected)
+	return c.String(http.StatusOK, "")
+}
+
+// GetVaccinations ワクチン接種記録を返す
+// cat_id, from, toで絞り込める
+func (mh *MedicalHandler) GetVaccinations(c echo.Context) error {
+	f, err := parseRecordFilter(c)
+	if err != nil {
+		return err
+	}
+	vs, err := mh.Db.GetVaccinations(HouseholdIdFromContext(c), f)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, vs)
+}
+
+// GetUpcomingVaccinations 世帯の全てのcatについて、days日以内に次回接種日を迎える接種記録を返す
+// 次回接種日を過ぎたものも含む
+func (mh *MedicalHandler) GetUpcomingVaccinations(c echo.Context) error {
+	days, err := queryInt(c, "days", DefaultUpcomingDays)
+	if err != nil || days < 0 {
+		return c.String(http.StatusBadRequest, "Invalid days.")
+	}
+	until := time.Now().AddDate(0, 0, days)
+	vs, err := mh.Db.GetUpcomingVaccinations(HouseholdIdFromContext(c), until)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, vs)
+}
+
+// AddVaccination ワクチン接種記録を1件追加する
+func (mh *MedicalHandler) AddVaccination(c echo.Context) error {
+	var v model.Vaccination
+	if err := c.Bind(&v); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	cat, err := mh.selectCat(c, v.CatId)
+	if err != nil {
+		return err
+	}
+	if v.Date.IsZero() || v.Vaccine == "" {
+		return c.String(http.StatusBadRequest, "Date and vaccine are required.")
+	}
+	if v.NextDue != nil && v.NextDue.Before(v.Date) {
+		return c.String(http.StatusBadRequest, "Next due must be after the date.")
+	}
+
+	v.UID = UserIdFromToken(c)
+	v.HouseholdId = cat.HouseholdId
+	if err := mh.Db.AddVaccination(v); err != nil {
+		c.Logger().Errorf("Insert: ", err)
+		return c.String(http.StatusInternalServerError, "Could not add vaccination.")
+	}
+	c.Logger().Infof("Added: %#v", v)
+	return c.String(http.StatusOK, "")
+}
+
+// UpdateVaccination ワクチン接種記録を1件更新する
+func (mh *MedicalHandler) UpdateVaccination(c echo.Context) error {
+	var v model.Vaccination
+	if err := c.Bind(&v); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if v.Id == 0 {
+		return c.String(http.StatusBadRequest, "Vaccination id is not specified.")
+	}
+	selected, err := mh.Db.GetVaccination(v.Id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "No your specified vaccination.")
+	}
+
+	if !v.Date.IsZero() {
+		selected.Date = v.Date
+	}
+	if v.Vaccine != "" {
+		selected.Vaccine = v.Vaccine
+	}
+	selected.Clinic = v.Clinic
+	selected.NextDue = v.NextDue
+	selected.Comment = v.Comment
+	if selected.NextDue != nil && selected.NextDue.Before(selected.Date) {
+		return c.String(http.StatusBadRequest, "Next due must be after the date.")
+	}
+	if err := mh.Db.UpdateVaccination(selected); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusInternalServerError, "Could not update vaccination.")
+	}
+	c.Logger().Infof("Updated: %#v", selected)
+	return c.String(http.StatusOK, "")
+}
+
+// DeleteVaccination ワクチン接種記録を1件削除する
+func (mh *MedicalHandler) DeleteVaccination(c echo.Context) error {
+	var v model.Vaccination
+	if err := c.Bind(&v); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if v.Id == 0 {
+		return c.String(http.StatusBadRequest, "Vaccination id is not specified.")
+	}
+	selected, err := mh.Db.GetVaccination(v.Id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "No your specified vaccination.")
+	}
+	if err := mh.Db.DeleteVaccination(selected); err != nil {
+		c.Logger().Errorf("Delete: ", err)
+		return c.String(http.StatusInternalServerError, "Failed delete the vaccination.")
+	}
+	c.Logger().Infof("Deleted: %#v", selected)
+	return c.String(http.StatusOK, "")
+}
+
+// GetConditions 持病、アレルギーを返す
+// cat_id, from, to(診断日)に加えてkind, active=trueで絞り込める
+func (mh *MedicalHandler) GetConditions(c echo.Context) error {
+	f, err := parseRecordFilter(c)
+	if err != nil {
+		return err
+	}
+	kind := c.QueryParam("kind")
+	if kind != "" && !validConditionKind(kind) {
+		return c.String(http.StatusBadRequest, "Invalid kind.")
+	}
+	active := false
+	if s := c.QueryParam("active"); s != "" {
+		if active, err = strconv.ParseBool(s); err != nil {
+			return c.String(http.StatusBadRequest, "Invalid active.")
+		}
+	}
+	cs, err := mh.Db.GetConditions(HouseholdIdFromContext(c), f, kind, active)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, cs)
+}
+
+// AddCondition 持病、アレルギーを1件追加する
+// kindを省略した場合はconditionとする
+func (mh *MedicalHandler) AddCondition(c echo.Context) error {
+	var cd model.Condition
+	if err := c.Bind(&cd); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	cat, err := mh.selectCat(c, cd.CatId)
+	if err != nil {
+		return err
+	}
+	if cd.Kind == "" {
+		cd.Kind = model.ConditionKindCondition
+	}
+	if !validConditionKind(cd.Kind) {
+		return c.String(http.StatusBadRequest, "Invalid kind.")
+	}
+	if cd.Name == "" {
+		return c.String(http.StatusBadRequest, "Name is not specified.")
+	}
+
+	cd.UID = UserIdFromToken(c)
+	cd.HouseholdId = cat.HouseholdId
+	if err := mh.Db.AddCondition(cd); err != nil {
+		c.Logger().Errorf("Insert: ", err)
+		return c.String(http.StatusInternalServerError, "Could not add condition.")
+	}
+	c.Logger().Infof("Added: %#v", cd)
+	return c.String(http.StatusOK, "")
+}
+
+// UpdateCondition 持病、アレルギーを1件更新する
+// 完治した場合はresolvedを指定する
+func (mh *MedicalHandler) UpdateCondition(c echo.Context) error {
+	var cd model.Condition
+	if err := c.Bind(&cd); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if cd.Id == 0 {
+		return c.String(http.StatusBadRequest, "Condition id is not specified.")
+	}
+	selected, err := mh.Db.GetCondition(cd.Id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "No your specified condition.")
+	}
+	if cd.Kind != "" {
+		if !validConditionKind(cd.Kind) {
+			return c.String(http.StatusBadRequest, "Invalid kind.")
+		}
+		selected.Kind = cd.Kind
+	}
+	if cd.Name != "" {
+		selected.Name = cd.Name
+	}
+	selected.Diagnosed = cd.Diagnosed
+	selected.Resolved = cd.Resolved
+	selected.Comment = cd.Comment
+	if err := mh.Db.UpdateCondition(selected); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusInternalServerError, "Could not update condition.")
+	}
+	c.Logger().Infof("Updated: %#v", selected)
+	return c.String(http.StatusOK, "")
+}
+
+// DeleteCondition 持病、アレルギーを1件削除する
+func (mh *MedicalHandler) DeleteCondition(c echo.Context) error {
+	var cd model.Condition
+	if err := c.Bind(&cd); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if cd.Id == 0 {
+		return c.String(http.StatusBadRequest, "Condition id is not specified.")
+	}
+	selected, err := mh.Db.GetCondition(cd.Id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "No your specified condition.")
+	}
+	if err := mh.Db.DeleteCondition(selected); err != nil {
+		c.Logger().Errorf("Delete: ", err)
+		return c.String(http.StatusInternalServerError, "Failed delete the condition.")
+	}
+	c.Logger().Infof("Deleted: %#v", selected)
+	return c.String(http.StatusOK, "")
+}
+
+// selectCat 記録の対象となる世帯のcatを取得する
+// 取得できなかった場合はそのままhandlerから返すerrorを返す
+func (mh *MedicalHandler) selectCat(c echo.Context, id int64) (model.Cat, error) {
+	if id == 0 {
+		return model.Cat{}, echo.NewHTTPError(http.StatusBadRequest, "Cat id is not specified.")
+	}
+	cat, err := mh.Db.GetCat(id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return model.Cat{}, echo.NewHTTPError(http.StatusNotFound, "No your specified cat.")
+	}
+	return cat, nil
+}
+
+func validConditionKind(kind string) bool {
+	return kind == model.ConditionKindCondition || kind == model.ConditionKindAllergy
+}
+
+// parseRecordFilter クエリパラメータcat_id, from, toからRecordFilterを作る
+// from, toはRFC3339または2006-01-02形式で指定する
+func parseRecordFilter(c echo.Context) (model.RecordFilter, error) {
+	var f model.RecordFilter
+	if s := c.QueryParam("cat_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return f, echo.NewHTTPError(http.StatusBadRequest, "Invalid cat_id.")
+		}
+		f.CatId = id
+	}
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &f.From}, {"to", &f.To}} {
+		s := c.QueryParam(p.name)
+		if s == "" {
+			continue
+		}
+		t, err := parseQueryTime(s)
+		if err != nil {
+			return f, echo.NewHTTPError(http.StatusBadRequest, "Invalid "+p.name+".")
+		}
+		*p.dst = &t
+	}
+	return f, nil
+}
+
+func parseQueryTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
@@ -0,0 +1,401 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/greytabby/meowapi/lib/schedule"
+	"github.com/labstack/echo"
+)
+
+const (
+	// DefaultDoseGrace 予定時刻からこの時間が経過すると投与漏れとみなす
+	DefaultDoseGrace = 30 * time.Minute
+	// defaultOverdueHours 投与漏れを遡る時間の既定値
+	defaultOverdueHours = 24
+	// maxOverdueHours 投与漏れを遡る時間の上限
+	maxOverdueHours = 24 * 14
+)
+
+// MedicationDbAccessor medication, medicationdoseテーブルを操作するinterface
+type MedicationDbAccessor interface {
+	CatReader
+	GetUser(id int64) (model.User, error)
+	GetMedications(hid int64, f model.RecordFilter, active *time.Time) ([]model.Medication, error)
+	GetMedication(id, hid int64) (model.Medication, error)
+	AddMedication(m model.Medication) error
+	UpdateMedication(m model.Medication) error
+	DeleteMedication(m model.Medication) error
+	GetMedicationDoses(medid, hid int64, f model.RecordFilter) ([]model.MedicationDose, error)
+	GetDosesSince(hid int64, since time.Time) ([]model.MedicationDose, error)
+	GetMedicationDose(medid int64, scheduled time.Time) (model.MedicationDose, error)
+	AddMedicationDose(d model.MedicationDose) error
+	UpdateMedicationDose(d model.MedicationDose) error
+}
+
+// MedicationHandler /api/medicationへのリクエストを処理する
+type MedicationHandler struct {
+	Db MedicationDbAccessor
+	// Grace 投与漏れとみなすまでの猶予。0の場合はDefaultDoseGrace
+	Grace time.Duration
+}
+
+// GetMedications 投薬計画を返す
+// cat_id, from, to(開始日)とactive=trueで絞り込める
+func (mh *MedicationHandler) GetMedications(c echo.Context) error {
+	f, err := parseRecordFilter(c)
+	if err != nil {
+		return err
+	}
+	var active *time.Time
+	if s := c.QueryParam("active"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return c.String(http.StatusBadRequest, "Invalid active.")
+		}
+		if b {
+			now := time.Now()
+			active = &now
+		}
+	}
+	ms, err := mh.Db.GetMedications(HouseholdIdFromContext(c), f, active)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, ms)
+}
+
+// AddMedication 投薬計画を1件追加する
+// timesperdayまたはtimes("08:00,20:00")、もしくはrruleで予定を指定する
+// timezoneを省略した場合は登録するユーザのタイムゾーンで予定を解釈する
+func (mh *MedicationHandler) AddMedication(c echo.Context) error {
+	var m model.Medication
+	if err := c.Bind(&m); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if m.CatId == 0 {
+		return c.String(http.StatusBadRequest, "Cat id is not specified.")
+	}
+	cat, err := mh.Db.GetCat(m.CatId, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusNotFound, "No your specified cat.")
+	}
+	if m.Drug == "" || m.Dose == "" {
+		return c.String(http.StatusBadRequest, "Drug and dose are required.")
+	}
+	if m.Start.IsZero() {
+		m.Start = time.Now()
+	}
+	user, err := mh.Db.GetUser(UserIdFromToken(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if m.TimeZone == "" {
+		m.TimeZone = user.TimeZone
+	}
+	if err := normalizeMedication(&m); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	m.UID = user.Id
+	m.HouseholdId = cat.HouseholdId
+	if err := mh.Db.AddMedication(m); err != nil {
+		c.Logger().Errorf("Insert: ", err)
+		return c.String(http.StatusInternalServerError, "Could not add medication.")
+	}
+	c.Logger().Infof("Added: %#v", m)
+	return c.String(http.StatusOK, "")
+}
+
+// UpdateMedication 投薬計画を1件更新する
+// 予定を変更しない場合はtimesperday, times, rruleを省略する
+func (mh *MedicationHandler) UpdateMedication(c echo.Context) error {
+	var m model.Medication
+	if err := c.Bind(&m); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if m.Id == 0 {
+		return c.String(http.StatusBadRequest, "Medication id is not specified.")
+	}
+	selected, err := mh.Db.GetMedication(m.Id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "No your specified medication.")
+	}
+
+	if m.Drug != "" {
+		selected.Drug = m.Drug
+	}
+	if m.Dose != "" {
+		selected.Dose = m.Dose
+	}
+	if m.TimesPerDay != 0 || m.Times != "" || m.RRule != "" {
+		selected.TimesPerDay = m.TimesPerDay
+		selected.Times = m.Times
+		selected.RRule = m.RRule
+	}
+	if !m.Start.IsZero() {
+		selected.Start = m.Start
+	}
+	if m.TimeZone != "" {
+		selected.TimeZone = m.TimeZone
+	}
+	selected.End = m.End
+	selected.Comment = m.Comment
+	if err := normalizeMedication(&selected); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if err := mh.Db.UpdateMedication(selected); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusInternalServerError, "Could not update medication.")
+	}
+	c.Logger().Infof("Updated: %#v", selected)
+	return c.String(http.StatusOK, "")
+}
+
+// DeleteMedication 投薬計画を投与記録とともに削除する
+func (mh *MedicationHandler) DeleteMedication(c echo.Context) error {
+	var m model.Medication
+	if err := c.Bind(&m); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if m.Id == 0 {
+		return c.String(http.StatusBadRequest, "Medication id is not specified.")
+	}
+	selected, err := mh.Db.GetMedication(m.Id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "No your specified medication.")
+	}
+	if err := mh.Db.DeleteMedication(selected); err != nil {
+		c.Logger().Errorf("Delete: ", err)
+		return c.String(http.StatusInternalServerError, "Failed delete the medication.")
+	}
+	c.Logger().Infof("Deleted: %#v", selected)
+	return c.String(http.StatusOK, "")
+}
+
+// GetDoses 投薬計画の投与記録を返す
+// from, to(予定時刻)で絞り込める
+func (mh *MedicationHandler) GetDoses(c echo.Context) error {
+	m, err := mh.selectMedication(c)
+	if err != nil {
+		return err
+	}
+	f, err := parseRecordFilter(c)
+	if err != nil {
+		return err
+	}
+	ds, err := mh.Db.GetMedicationDoses(m.Id, m.HouseholdId, f)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, ds)
+}
+
+// AddDose 予定された投与を実施(given)または見送り(skipped)として記録する
+// 同じ予定時刻の記録が既にある場合は上書きする
+func (mh *MedicationHandler) AddDose(c echo.Context) error {
+	m, err := mh.selectMedication(c)
+	if err != nil {
+		return err
+	}
+	var d model.MedicationDose
+	if err := c.Bind(&d); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if d.Status != model.DoseStatusGiven && d.Status != model.DoseStatusSkipped {
+		return c.String(http.StatusBadRequest, "Status must be given or skipped.")
+	}
+
+	uid := UserIdFromToken(c)
+	sched, err := medicationSchedule(m)
+	if err != nil {
+		c.Logger().Errorf("Schedule: ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	// the scheduled time must be one of the occurrences of the plan.
+	if len(sched.Between(d.Scheduled, d.Scheduled.Add(time.Second))) == 0 {
+		return c.String(http.StatusBadRequest, "No dose is scheduled at the time.")
+	}
+	if m.End != nil && !d.Scheduled.Before(*m.End) {
+		return c.String(http.StatusBadRequest, "No dose is scheduled at the time.")
+	}
+
+	existing, err := mh.Db.GetMedicationDose(m.Id, d.Scheduled)
+	if err == nil {
+		existing.UID = uid
+		existing.Status = d.Status
+		existing.Comment = d.Comment
+		if err := mh.Db.UpdateMedicationDose(existing); err != nil {
+			c.Logger().Errorf("Update: ", err)
+			return c.String(http.StatusInternalServerError, "Could not record dose.")
+		}
+		c.Logger().Infof("Updated: %#v", existing)
+		return c.String(http.StatusOK, "")
+	}
+
+	d.Id = 0
+	d.UID = uid
+	d.HouseholdId = m.HouseholdId
+	d.MedicationId = m.Id
+	d.CatId = m.CatId
+	if err := mh.Db.AddMedicationDose(d); err != nil {
+		c.Logger().Errorf("Insert: ", err)
+		return c.String(http.StatusInternalServerError, "Could not record dose.")
+	}
+	c.Logger().Infof("Added: %#v", d)
+	return c.String(http.StatusOK, "")
+}
+
+// GetOverdueDoses 予定時刻を過ぎても記録されていない投与を返す
+// 予定は投薬計画のタイムゾーンで計算し、hours時間前まで遡る
+func (mh *MedicationHandler) GetOverdueDoses(c echo.Context) error {
+	hours, err := queryInt(c, "hours", defaultOverdueHours)
+	if err != nil || hours <= 0 || hours > maxOverdueHours {
+		return c.String(http.StatusBadRequest, "Invalid hours.")
+	}
+
+	hid := HouseholdIdFromContext(c)
+	now := time.Now()
+	since := now.Add(-time.Duration(hours) * time.Hour)
+	ms, err := mh.Db.GetMedications(hid, model.RecordFilter{}, nil)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	ds, err := mh.Db.GetDosesSince(hid, since)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+
+	grace := mh.Grace
+	if grace <= 0 {
+		grace = DefaultDoseGrace
+	}
+	overdue, err := overdueDoses(ms, ds, since, now.Add(-grace))
+	if err != nil {
+		c.Logger().Errorf("Schedule: ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	return c.JSON(http.StatusOK, overdue)
+}
+
+// selectMedication パスパラメータ:idの投薬計画を取得する
+// 取得できなかった場合はそのままhandlerから返すerrorを返す
+func (mh *MedicationHandler) selectMedication(c echo.Context) (model.Medication, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Logger().Errorf("Param parse: ", err)
+		return model.Medication{}, echo.NewHTTPError(http.StatusBadRequest, "Param parse: "+err.Error())
+	}
+	m, err := mh.Db.GetMedication(id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return model.Medication{}, echo.NewHTTPError(http.StatusNotFound, "No your specified medication.")
+	}
+	return m, nil
+}
+
+// normalizeMedication 投薬計画の予定を検証し、timesとtimesperdayを揃える
+func normalizeMedication(m *model.Medication) error {
+	if !model.ValidTimeZone(m.TimeZone) {
+		return errors.New("Invalid time zone.")
+	}
+	if m.End != nil && !m.End.After(m.Start) {
+		return errors.New("End must be after start.")
+	}
+	if m.RRule != "" {
+		if m.TimesPerDay != 0 || m.Times != "" {
+			return errors.New("Specify either times or rrule.")
+		}
+		if _, err := schedule.ParseRRule(m.RRule, m.Start); err != nil {
+			return errors.New("Invalid rrule: " + err.Error())
+		}
+		return nil
+	}
+	if m.Times != "" {
+		cs, err := schedule.ParseClocks(m.Times)
+		if err != nil {
+			return errors.New("Invalid times: " + err.Error())
+		}
+		if m.TimesPerDay != 0 && m.TimesPerDay != len(cs) {
+			return errors.New("Times per day does not match the times.")
+		}
+		m.Times = schedule.FormatClocks(cs)
+		m.TimesPerDay = len(cs)
+		return nil
+	}
+	if m.TimesPerDay <= 0 || m.TimesPerDay > 24 {
+		return errors.New("Times per day or rrule is required.")
+	}
+	m.Times = schedule.FormatClocks(schedule.DefaultClocks(m.TimesPerDay))
+	return nil
+}
+
+// medicationSchedule 投薬計画の予定を計画のタイムゾーンで解釈する
+func medicationSchedule(m model.Medication) (schedule.Schedule, error) {
+	loc := m.Location()
+	start := m.Start.In(loc)
+	if m.RRule != "" {
+		return schedule.ParseRRule(m.RRule, start)
+	}
+	cs, err := schedule.ParseClocks(m.Times)
+	if err != nil {
+		return nil, err
+	}
+	return schedule.Daily{Start: start, Clock: cs, Loc: loc}, nil
+}
+
+// overdueDoses from以上to未満に予定され、記録の無い投与を予定時刻順に返す
+func overdueDoses(ms []model.Medication, ds []model.MedicationDose, from, to time.Time) ([]model.OverdueDose, error) {
+	type key struct {
+		medid int64
+		at    int64
+	}
+	recorded := map[key]bool{}
+	for _, d := range ds {
+		recorded[key{d.MedicationId, d.Scheduled.Unix()}] = true
+	}
+
+	overdue := []model.OverdueDose{}
+	for _, m := range ms {
+		end := to
+		if m.End != nil && m.End.Before(end) {
+			end = *m.End
+		}
+		sched, err := medicationSchedule(m)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range sched.Between(from, end) {
+			if recorded[key{m.Id, t.Unix()}] {
+				continue
+			}
+			overdue = append(overdue, model.OverdueDose{
+				MedicationId: m.Id,
+				CatId:        m.CatId,
+				Drug:         m.Drug,
+				Dose:         m.Dose,
+				Scheduled:    t,
+			})
+		}
+	}
+	sort.Slice(overdue, func(i, j int) bool {
+		return overdue[i].Scheduled.Before(overdue[j].Scheduled)
+	})
+	return overdue, nil
+}
@@ -0,0 +1,213 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/greytabby/meowapi/lib/notify"
+	"github.com/labstack/echo"
+)
+
+const (
+	defaultNotificationLimit = 50
+	maxNotificationLimit     = 500
+)
+
+// NotificationDbAccessor ユーザの通知設定と通知を操作するinterface
+type NotificationDbAccessor interface {
+	GetUser(id int64) (model.User, error)
+	GetNotificationPreferences(uid int64) ([]model.NotificationPreference, error)
+	GetNotificationPreference(id, uid int64) (model.NotificationPreference, error)
+	AddNotificationPreference(p model.NotificationPreference) error
+	UpdateNotificationPreference(p model.NotificationPreference) error
+	DeleteNotificationPreference(p model.NotificationPreference) error
+	GetNotifications(uid int64, limit int) ([]model.Notification, error)
+	AddNotification(n model.Notification) (bool, error)
+}
+
+// NotificationHandler /api/notificationへのリクエストを処理する
+type NotificationHandler struct {
+	Db       NotificationDbAccessor
+	Channels map[string]notify.Channel
+}
+
+// notificationChannels 利用できる通知の手段
+type notificationChannels struct {
+	Channels []string `json:"channels"`
+	Rules    []string `json:"rules"`
+	// VAPIDPublicKey web pushを購読する際のapplicationServerKey
+	VAPIDPublicKey string `json:"vapidpublickey,omitempty"`
+}
+
+// GetChannels 利用できる通知の手段と通知の種類を返す
+func (nh *NotificationHandler) GetChannels(c echo.Context) error {
+	res := notificationChannels{
+		Channels: []string{},
+		Rules: []string{
+			model.NotifyRuleToiletDue, model.NotifyRuleMedicationDue, model.NotifyRuleHealthAlert, model.NotifyRuleLitterLow,
+		},
+	}
+	for _, name := range []string{model.NotifyChannelEmail, model.NotifyChannelWebhook, model.NotifyChannelWebPush} {
+		ch, ok := nh.Channels[name]
+		if !ok {
+			continue
+		}
+		res.Channels = append(res.Channels, name)
+		if wp, ok := ch.(*notify.WebPushChannel); ok {
+			res.VAPIDPublicKey = wp.PublicKey()
+		}
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// GetPreferences ログイン中のユーザの通知設定を返す
+func (nh *NotificationHandler) GetPreferences(c echo.Context) error {
+	ps, err := nh.Db.GetNotificationPreferences(UserIdFromToken(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, ps)
+}
+
+// AddPreference ログイン中のユーザの通知設定を1件追加する
+func (nh *NotificationHandler) AddPreference(c echo.Context) error {
+	var p model.NotificationPreference
+	if err := c.Bind(&p); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if err := nh.validatePreference(&p); err != nil {
+		return err
+	}
+	p.Id = 0
+	p.UID = UserIdFromToken(c)
+	if err := nh.Db.AddNotificationPreference(p); err != nil {
+		c.Logger().Errorf("Insert: ", err)
+		return c.String(http.StatusInternalServerError, "Could not add notification preference.")
+	}
+	return c.String(http.StatusOK, "")
+}
+
+// UpdatePreference ログイン中のユーザの通知設定を1件更新する
+func (nh *NotificationHandler) UpdatePreference(c echo.Context) error {
+	var p model.NotificationPreference
+	if err := c.Bind(&p); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if p.Id == 0 {
+		return c.String(http.StatusBadRequest, "Notification preference id not specified.")
+	}
+	if err := nh.validatePreference(&p); err != nil {
+		return err
+	}
+	selected, err := nh.Db.GetNotificationPreference(p.Id, UserIdFromToken(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusNotFound, "No your specified notification preference.")
+	}
+
+	selected.Channel = p.Channel
+	selected.Destination = p.Destination
+	selected.Rules = p.Rules
+	selected.QuietStart = p.QuietStart
+	selected.QuietEnd = p.QuietEnd
+	selected.Enabled = p.Enabled
+	if err := nh.Db.UpdateNotificationPreference(selected); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusInternalServerError, "Could not update notification preference.")
+	}
+	return c.String(http.StatusOK, "")
+}
+
+// DeletePreference ログイン中のユーザの通知設定を1件削除する
+func (nh *NotificationHandler) DeletePreference(c echo.Context) error {
+	var p model.NotificationPreference
+	if err := c.Bind(&p); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if p.Id == 0 {
+		return c.String(http.StatusBadRequest, "Notification preference id not specified.")
+	}
+	selected, err := nh.Db.GetNotificationPreference(p.Id, UserIdFromToken(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusNotFound, "No your specified notification preference.")
+	}
+	if err := nh.Db.DeleteNotificationPreference(selected); err != nil {
+		c.Logger().Errorf("Delete: ", err)
+		return c.String(http.StatusInternalServerError, "Could not delete notification preference.")
+	}
+	return c.String(http.StatusOK, "")
+}
+
+// GetNotifications ログイン中のユーザへの通知を新しい順に返す
+func (nh *NotificationHandler) GetNotifications(c echo.Context) error {
+	limit, err := queryInt(c, "limit", defaultNotificationLimit)
+	if err != nil || limit <= 0 || limit > maxNotificationLimit {
+		return c.String(http.StatusBadRequest, "Invalid limit.")
+	}
+	ns, err := nh.Db.GetNotifications(UserIdFromToken(c), limit)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, ns)
+}
+
+// SendTest ログイン中のユーザの有効な全ての通知設定へ確認用の通知を送る
+// 通知はoutboxに積まれ、次の送信で届く
+func (nh *NotificationHandler) SendTest(c echo.Context) error {
+	uid := UserIdFromToken(c)
+	ps, err := nh.Db.GetNotificationPreferences(uid)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	user, err := nh.Db.GetUser(uid)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	now := time.Now()
+	queued := 0
+	for _, p := range ps {
+		if !p.Enabled {
+			continue
+		}
+		if err := enqueueNotification(nh.Db, uid, 0, p, user.Location(), testEvent(now), now); err != nil {
+			c.Logger().Errorf("Insert: ", err)
+			return c.String(http.StatusInternalServerError, "Could not queue test notification.")
+		}
+		queued++
+	}
+	return c.JSON(http.StatusAccepted, map[string]int{"queued": queued})
+}
+
+// validatePreference 通知設定を検証し、通知の種類の一覧を正規化する
+// 不正な場合はそのままhandlerから返すerrorを返す
+func (nh *NotificationHandler) validatePreference(p *model.NotificationPreference) error {
+	ch, ok := nh.Channels[p.Channel]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "Channel "+strconv.Quote(p.Channel)+" is not available.")
+	}
+	if err := ch.Validate(p.Destination); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid destination: "+err.Error())
+	}
+	rules := p.RuleList()
+	for _, r := range rules {
+		if !model.ValidNotifyRule(r) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid rule "+strconv.Quote(r)+".")
+		}
+	}
+	p.Rules = strings.Join(rules, ",")
+	if err := notify.ValidQuietHours(p.QuietStart, p.QuietEnd); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid quiet hours.")
+	}
+	return nil
+}
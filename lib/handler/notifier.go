@@ -0,0 +1,288 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/health"
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/greytabby/meowapi/lib/notify"
+)
+
+// notifyDoseWindow この時間より前の投与漏れは通知しない
+const notifyDoseWindow = 24 * time.Hour
+
+// NotifierDbAccessor 通知の条件の判定と通知の保存を行うinterface
+type NotifierDbAccessor interface {
+	AlertDbAccessor
+	LitterReader
+	notify.Outbox
+	GetAllToilets(hid int64) ([]model.Toilet, error)
+	GetLastWashes(hid int64) ([]model.LastWash, error)
+	GetMedications(hid int64, f model.RecordFilter, active *time.Time) ([]model.Medication, error)
+	GetDosesSince(hid int64, since time.Time) ([]model.MedicationDose, error)
+	GetHouseholdMembers(hid int64) ([]model.HouseholdMember, error)
+	GetNotifiedHouseholds() ([]int64, error)
+	GetNotificationPreferences(uid int64) ([]model.NotificationPreference, error)
+	AddNotification(n model.Notification) (bool, error)
+}
+
+// Notifier 通知の条件を判定してoutboxに積み、outboxの通知を送信する
+type Notifier struct {
+	Db       NotifierDbAccessor
+	Channels map[string]notify.Channel
+	// Health 異常の検出の閾値。未設定の場合はhealth.DefaultConfig
+	Health health.Config
+	// DoseGrace 投与漏れとみなすまでの猶予。0の場合はDefaultDoseGrace
+	DoseGrace time.Duration
+	// LitterWeeks 砂の消費量の平均をとる週数。0の場合はDefaultLitterWeeks
+	LitterWeeks int
+}
+
+// notificationEvent 通知するできごと
+// keyはruleの中で同じできごとを識別し、urgentな通知は通知を控える時間帯でも送る
+type notificationEvent struct {
+	rule    string
+	key     string
+	subject string
+	body    string
+	urgent  bool
+}
+
+// Evaluate 通知設定を持つユーザが所属する全てのhouseholdについて通知の条件を判定し、
+// 通知をoutboxに積む。同じできごとは通知設定ごとに1度だけ積む
+// ctxが終了した場合は残りのhouseholdを判定せずに終える
+func (n *Notifier) Evaluate(ctx context.Context, now time.Time) error {
+	hids, err := n.Db.GetNotifiedHouseholds()
+	if err != nil {
+		return err
+	}
+	for _, hid := range hids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := n.evaluateHousehold(hid, now); err != nil {
+			return fmt.Errorf("household %d: %v", hid, err)
+		}
+	}
+	return nil
+}
+
+// Deliver outboxの送信時刻になった通知を送信する
+func (n *Notifier) Deliver(ctx context.Context, now time.Time) (int, error) {
+	return notify.Deliver(ctx, n.Db, n.Channels, now)
+}
+
+func (n *Notifier) evaluateHousehold(hid int64, now time.Time) error {
+	members, err := n.Db.GetHouseholdMembers(hid)
+	if err != nil {
+		return err
+	}
+	cats, err := n.Db.GetAllCats(hid)
+	if err != nil {
+		return err
+	}
+	catNames := map[int64]string{}
+	for _, c := range cats {
+		catNames[c.Id] = c.Name
+	}
+	toiletEvents, err := n.toiletEvents(hid, now)
+	if err != nil {
+		return err
+	}
+	litterEvents, err := n.litterEvents(hid, now)
+	if err != nil {
+		return err
+	}
+	toiletEvents = append(toiletEvents, litterEvents...)
+
+	// days of the health alerts and the dose times in the messages depend on the time zone of each member.
+	zoneEvents := map[string][]notificationEvent{}
+	for _, m := range members {
+		prefs, err := n.Db.GetNotificationPreferences(m.UID)
+		if err != nil {
+			return err
+		}
+		if len(prefs) == 0 {
+			continue
+		}
+		user, err := n.Db.GetUser(m.UID)
+		if err != nil {
+			return err
+		}
+		if user.Disabled {
+			continue
+		}
+		loc := user.Location()
+		events, ok := zoneEvents[loc.String()]
+		if !ok {
+			if events, err = n.zoneEvents(hid, catNames, now, loc); err != nil {
+				return err
+			}
+			zoneEvents[loc.String()] = events
+		}
+		events = append(append([]notificationEvent{}, toiletEvents...), events...)
+		for _, p := range prefs {
+			for _, ev := range events {
+				if err := enqueueNotification(n.Db, m.UID, hid, p, loc, ev, now); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (n *Notifier) toiletEvents(hid int64, now time.Time) ([]notificationEvent, error) {
+	toilets, err := n.Db.GetAllToilets(hid)
+	if err != nil {
+		return nil, err
+	}
+	last, err := n.Db.GetLastWashes(hid)
+	if err != nil {
+		return nil, err
+	}
+	var events []notificationEvent
+	for _, d := range dueToilets(toilets, last, now) {
+		task := "a scoop"
+		if d.Task == model.CleaningTaskFullChange {
+			task = "a full litter change"
+		}
+		events = append(events, notificationEvent{
+			rule:    model.NotifyRuleToiletDue,
+			key:     fmt.Sprintf("%d:%s:%d", d.ToiletId, d.Task, d.Due.Unix()),
+			subject: fmt.Sprintf("%s needs %s", d.Name, task),
+			body:    fmt.Sprintf("%s has needed %s since %s.", d.Name, task, d.Due.Format(time.RFC3339)),
+		})
+	}
+	return events, nil
+}
+
+// litterEvents 在庫が少ない砂を返す
+// 同じ砂は補充するまで1度だけ通知する
+func (n *Notifier) litterEvents(hid int64, now time.Time) ([]notificationEvent, error) {
+	ls, err := LitterForecasts(n.Db, n.LitterWeeks, hid, now)
+	if err != nil {
+		return nil, err
+	}
+	var events []notificationEvent
+	for _, l := range ls {
+		if !l.Forecast.LowStock {
+			continue
+		}
+		body := fmt.Sprintf("%s is out of stock.", l.Brand)
+		if l.Forecast.RunOut != nil && l.StockGrams > 0 {
+			body = fmt.Sprintf("%s has %dg left and will run out around %s.",
+				l.Brand, l.StockGrams, l.Forecast.RunOut.Format("2006-01-02"))
+		}
+		events = append(events, notificationEvent{
+			rule:    model.NotifyRuleLitterLow,
+			key:     fmt.Sprintf("%d:%d", l.Id, l.Restocked.Unix()),
+			subject: fmt.Sprintf("%s is running low", l.Brand),
+			body:    body,
+		})
+	}
+	return events, nil
+}
+
+// zoneEvents 投与漏れとlocのタイムゾーンで判定するトイレの異常を返す
+// 投与漏れは投薬計画のタイムゾーンで判定し、予定時刻はlocで表示する
+func (n *Notifier) zoneEvents(hid int64, catNames map[int64]string, now time.Time, loc *time.Location) ([]notificationEvent, error) {
+	ms, err := n.Db.GetMedications(hid, model.RecordFilter{}, &now)
+	if err != nil {
+		return nil, err
+	}
+	since := now.Add(-notifyDoseWindow)
+	ds, err := n.Db.GetDosesSince(hid, since)
+	if err != nil {
+		return nil, err
+	}
+	grace := n.DoseGrace
+	if grace <= 0 {
+		grace = DefaultDoseGrace
+	}
+	overdue, err := overdueDoses(ms, ds, since, now.Add(-grace))
+	if err != nil {
+		return nil, err
+	}
+	var events []notificationEvent
+	for _, d := range overdue {
+		if _, ok := catNames[d.CatId]; !ok {
+			// the cat was deleted.
+			continue
+		}
+		at := d.Scheduled.In(loc).Format("15:04")
+		events = append(events, notificationEvent{
+			rule:    model.NotifyRuleMedicationDue,
+			key:     fmt.Sprintf("%d:%d", d.MedicationId, d.Scheduled.Unix()),
+			subject: fmt.Sprintf("%s has not had %s", catNames[d.CatId], d.Drug),
+			body:    fmt.Sprintf("The %s dose of %s %s for %s is not recorded.", at, d.Drug, d.Dose, catNames[d.CatId]),
+		})
+	}
+
+	cfg := n.Health
+	if cfg == (health.Config{}) {
+		cfg = health.DefaultConfig
+	}
+	alerts, err := HealthAlerts(n.Db, cfg, hid, now, loc)
+	if err != nil {
+		return nil, err
+	}
+	day := now.In(loc).Format("2006-01-02")
+	for _, a := range alerts {
+		events = append(events, notificationEvent{
+			rule:    model.NotifyRuleHealthAlert,
+			key:     fmt.Sprintf("%d:%s:%s:%s", a.CatId, a.Kind, a.Type, day),
+			subject: fmt.Sprintf("%s: %s", catNames[a.CatId], a.Message),
+			body:    fmt.Sprintf("%s (%s): %s.", catNames[a.CatId], a.Severity, a.Message),
+			urgent:  a.Severity == model.AlertSeverityCritical,
+		})
+	}
+	return events, nil
+}
+
+// notificationQueue 通知を積むoutbox
+type notificationQueue interface {
+	AddNotification(n model.Notification) (bool, error)
+}
+
+// enqueueNotification 通知設定pがevを受け取る場合、outboxに通知を積む
+// 通知を控える時間帯には、時間帯の終わりに送るように積む
+func enqueueNotification(q notificationQueue, uid, hid int64, p model.NotificationPreference, loc *time.Location, ev notificationEvent, now time.Time) error {
+	if !p.Enabled || !p.Wants(ev.rule) {
+		return nil
+	}
+	at := now
+	if !ev.urgent {
+		if until, ok := notify.QuietUntil(p.QuietStart, p.QuietEnd, now, loc); ok {
+			at = until
+		}
+	}
+	_, err := q.AddNotification(model.Notification{
+		UID:          uid,
+		HouseholdId:  hid,
+		PreferenceId: p.Id,
+		Rule:         ev.rule,
+		DedupKey:     ev.rule + ":" + ev.key,
+		Channel:      p.Channel,
+		Destination:  p.Destination,
+		Subject:      ev.subject,
+		Body:         ev.body,
+		Status:       model.NotificationStatusPending,
+		NextAttempt:  at,
+	})
+	return err
+}
+
+// testEvent 通知設定を確認するための通知
+func testEvent(now time.Time) notificationEvent {
+	return notificationEvent{
+		rule:    model.NotifyRuleTest,
+		key:     strconv.FormatInt(now.UnixNano(), 10),
+		subject: "Test notification from meowapi",
+		body:    "Notifications are delivered to this destination.",
+		urgent:  true,
+	}
+}
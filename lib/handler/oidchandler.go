@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/greytabby/meowapi/lib/oidc"
+	"github.com/labstack/echo"
+)
+
+const (
+	oidcCookie   = "meowapi_oidc"
+	oidcAudience = "oidc"
+	oidcStateTTL = 10 * time.Minute
+)
+
+// OidcDbAccessor OpenID Connectによるログインに必要なテーブルを操作するinterface
+type OidcDbAccessor interface {
+	FindUser(name string) (model.User, error)
+	GetUser(id int64) (model.User, error)
+	FindUserIdentity(provider, subject string) (model.UserIdentity, error)
+	AddUserWithIdentity(user model.User, identity model.UserIdentity) (model.User, error)
+	DeleteUserIdentity(identity model.UserIdentity) error
+}
+
+// OidcHandler /auth/oidcへのリクエストを処理する
+type OidcHandler struct {
+	Db        OidcDbAccessor
+	Providers map[string]*oidc.Provider
+}
+
+// oidcStateClaims 認可リクエストからcallbackまでcookieに保持する値
+type oidcStateClaims struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.StandardClaims
+}
+
+func (c *oidcStateClaims) Valid() error {
+	if !c.VerifyAudience(oidcAudience, true) {
+		return errors.New("not an oidc state token")
+	}
+	return c.StandardClaims.Valid()
+}
+
+// Start IdPの認可エンドポイントへリダイレクトする
+func (oh *OidcHandler) Start(c echo.Context) error {
+	name := c.Param("provider")
+	p, ok := oh.Providers[name]
+	if !ok {
+		return c.String(http.StatusNotFound, "Unknown provider")
+	}
+
+	ar, err := oidc.NewAuthRequest()
+	if err != nil {
+		c.Logger().Error("OIDC: ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	authURL, err := p.AuthURL(c.Request().Context(), ar)
+	if err != nil {
+		c.Logger().Error("OIDC: discovery failed. ", err)
+		return c.String(http.StatusBadGateway, "Identity provider is not available")
+	}
+
+	// state, nonce and verifier are kept in a signed cookie,
+	// so the callback can be handled by any replica.
+	claims := &oidcStateClaims{
+		Provider: name,
+		State:    ar.State,
+		Nonce:    ar.Nonce,
+		Verifier: ar.Verifier,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  oidcAudience,
+			ExpiresAt: time.Now().Add(oidcStateTTL).Unix(),
+		},
+	}
+	t, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(signingKey)
+	if err != nil {
+		c.Logger().Error("OIDC: create state token failed. ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     oidcCookie,
+		Value:    t,
+		Path:     "/auth/oidc/",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback IdPからのリダイレクトを受け、ユーザを紐付けまたは作成してjwttokenを発行する
+func (oh *OidcHandler) Callback(c echo.Context) error {
+	name := c.Param("provider")
+	p, ok := oh.Providers[name]
+	if !ok {
+		return c.String(http.StatusNotFound, "Unknown provider")
+	}
+	if e := c.QueryParam("error"); e != "" {
+		return c.String(http.StatusUnauthorized, "Identity provider returned error: "+e)
+	}
+
+	cookie, err := c.Cookie(oidcCookie)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Login session not found")
+	}
+	c.SetCookie(&http.Cookie{Name: oidcCookie, Path: "/auth/oidc/", MaxAge: -1})
+
+	state := &oidcStateClaims{}
+	_, err = jwt.ParseWithClaims(cookie.Value, state, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return signingKey, nil
+	})
+	if err != nil || state.Provider != name || state.State != c.QueryParam("state") {
+		return c.String(http.StatusBadRequest, "Invalid or expired login session")
+	}
+
+	ar := oidc.AuthRequest{State: state.State, Nonce: state.Nonce, Verifier: state.Verifier}
+	claims, err := p.Exchange(c.Request().Context(), c.QueryParam("code"), ar)
+	if err != nil {
+		c.Logger().Error("OIDC: ", err)
+		return c.String(http.StatusUnauthorized, "Could not verify the identity")
+	}
+
+	user, err := oh.findOrCreateUser(name, claims)
+	if err != nil {
+		c.Logger().Error("OIDC: ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	return loginResponse(c, user)
+}
+
+// findOrCreateUser 外部アカウントに紐付いたユーザを返す
+// 紐付いたユーザがいない場合は作成する。ユーザが削除済みの紐付けは削除して作り直す
+func (oh *OidcHandler) findOrCreateUser(provider string, claims oidc.Claims) (model.User, error) {
+	identity, err := oh.Db.FindUserIdentity(provider, claims.Subject)
+	switch {
+	case err == nil:
+		user, err := oh.Db.GetUser(identity.UID)
+		if err != sql.ErrNoRows {
+			return user, err
+		}
+		if err := oh.Db.DeleteUserIdentity(identity); err != nil {
+			return model.User{}, err
+		}
+	case err != sql.ErrNoRows:
+		return model.User{}, err
+	}
+
+	name, err := oh.availableName(provider, claims)
+	if err != nil {
+		return model.User{}, err
+	}
+	// The user has no password, so password login is impossible for this user.
+	user := model.User{Name: name, Role: model.UserRoleUser}
+	identity = model.UserIdentity{Provider: provider, Subject: claims.Subject, Email: claims.Email}
+	return oh.Db.AddUserWithIdentity(user, identity)
+}
+
+// availableName 作成するユーザの名前を決める
+// 既に使われている場合はランダムな接尾辞を付ける
+func (oh *OidcHandler) availableName(provider string, claims oidc.Claims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base = claims.Email
+	}
+	if base == "" {
+		base = provider + "-" + claims.Subject
+	}
+	if len(base) > 180 {
+		base = base[:180]
+	}
+
+	name := base
+	for i := 0; i < 5; i++ {
+		_, err := oh.Db.FindUser(name)
+		if err == sql.ErrNoRows {
+			return name, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		buf := make([]byte, 3)
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		name = base + "-" + hex.EncodeToString(buf)
+	}
+	return "", errors.New("could not find an available user name")
+}
@@ -0,0 +1,338 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/greytabby/meowapi/lib/oidc"
+	"github.com/labstack/echo"
+)
+
+const (
+	testOidcProvider = "test"
+	testOidcClient   = "meowapi-client"
+	testOidcCode     = "good-code"
+)
+
+// testIssuer discovery, jwks, tokenのエンドポイントを持つIdP
+type testIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+	// subject tokenエンドポイントが返すid_tokenのsub
+	subject string
+	// nonce 空でなければ認可リクエストのnonceの代わりにid_tokenへ入れる
+	nonce string
+	// challenge 認可リクエストのcode_challenge
+	challenge string
+	// authNonce 認可リクエストのnonce
+	authNonce string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti := &testIssuer{key: key, subject: "sub-1"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 ti.URL,
+			"authorization_endpoint": ti.URL + "/authorize",
+			"token_endpoint":         ti.URL + "/token",
+			"jwks_uri":               ti.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if r.PostFormValue("code") != testOidcCode ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != ti.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		nonce := ti.authNonce
+		if ti.nonce != "" {
+			nonce = ti.nonce
+		}
+		tok := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":                ti.URL,
+			"sub":                ti.subject,
+			"aud":                testOidcClient,
+			"exp":                time.Now().Add(time.Minute).Unix(),
+			"iat":                time.Now().Unix(),
+			"nonce":              nonce,
+			"email":              "tama@example.com",
+			"preferred_username": "tama",
+		})
+		tok.Header["kid"] = "k1"
+		raw, err := tok.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": raw})
+	})
+	ti.Server = httptest.NewServer(mux)
+	return ti
+}
+
+// fakeOidcDb OidcDbAccessorのメモリ上の実装
+type fakeOidcDb struct {
+	users      map[int64]model.User
+	identities []model.UserIdentity
+	nextId     int64
+}
+
+func newFakeOidcDb() *fakeOidcDb {
+	return &fakeOidcDb{users: map[int64]model.User{}, nextId: 1}
+}
+
+func (f *fakeOidcDb) FindUser(name string) (model.User, error) {
+	for _, u := range f.users {
+		if u.Name == name {
+			return u, nil
+		}
+	}
+	return model.User{}, sql.ErrNoRows
+}
+
+func (f *fakeOidcDb) GetUser(id int64) (model.User, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return model.User{}, sql.ErrNoRows
+	}
+	return u, nil
+}
+
+func (f *fakeOidcDb) FindUserIdentity(provider, subject string) (model.UserIdentity, error) {
+	for _, ui := range f.identities {
+		if ui.Provider == provider && ui.Subject == subject {
+			return ui, nil
+		}
+	}
+	return model.UserIdentity{}, sql.ErrNoRows
+}
+
+func (f *fakeOidcDb) AddUserWithIdentity(user model.User, identity model.UserIdentity) (model.User, error) {
+	user.Id = f.nextId
+	identity.Id = f.nextId
+	f.nextId++
+	identity.UID = user.Id
+	f.users[user.Id] = user
+	f.identities = append(f.identities, identity)
+	return user, nil
+}
+
+func (f *fakeOidcDb) DeleteUserIdentity(identity model.UserIdentity) error {
+	for i, ui := range f.identities {
+		if ui.Id == identity.Id {
+			f.identities = append(f.identities[:i], f.identities[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func newTestOidcHandler(ti *testIssuer, db *fakeOidcDb) *OidcHandler {
+	return &OidcHandler{
+		Db: db,
+		Providers: map[string]*oidc.Provider{testOidcProvider: {
+			Name:        testOidcProvider,
+			IssuerURL:   ti.URL,
+			ClientID:    testOidcClient,
+			RedirectURL: "https://meowapi.example.com/auth/oidc/test/callback",
+			Client:      ti.Client(),
+		}},
+	}
+}
+
+// startOidcLogin Startを呼び、状態を保持するcookieと認可リクエストのstateを返す
+func startOidcLogin(t *testing.T, oh *OidcHandler, ti *testIssuer) (*http.Cookie, string) {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/auth/oidc/test", nil), rec)
+	c.SetParamNames("provider")
+	c.SetParamValues(testOidcProvider)
+	if err := oh.Start(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Start status = %d, body %s", rec.Code, rec.Body)
+	}
+	loc, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(loc.String(), ti.URL+"/authorize?") {
+		t.Fatalf("redirected to %s", loc)
+	}
+	q := loc.Query()
+	ti.challenge = q.Get("code_challenge")
+	ti.authNonce = q.Get("nonce")
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oidcCookie {
+		t.Fatalf("cookies = %v", cookies)
+	}
+	return cookies[0], q.Get("state")
+}
+
+// oidcCallback Callbackを呼び、レスポンスを返す
+func oidcCallback(t *testing.T, oh *OidcHandler, cookie *http.Cookie, state, code string) *httptest.ResponseRecorder {
+	e := echo.New()
+	v := url.Values{"state": {state}, "code": {code}}
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/test/callback?"+v.Encode(), nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("provider")
+	c.SetParamValues(testOidcProvider)
+	if err := oh.Callback(c); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+// tokenUID レスポンスのjwttokenのuidを返す
+func tokenUID(t *testing.T, rec *httptest.ResponseRecorder) int64 {
+	var res map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("body %s: %v", rec.Body, err)
+	}
+	claims := &jwtCustomClaims{}
+	if _, err := jwt.ParseWithClaims(res["token"], claims, func(*jwt.Token) (interface{}, error) {
+		return signingKey, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return claims.UID
+}
+
+func TestOidcCallbackCreatesThenLinksUser(t *testing.T) {
+	ti := newTestIssuer(t)
+	defer ti.Close()
+	db := newFakeOidcDb()
+	oh := newTestOidcHandler(ti, db)
+
+	cookie, state := startOidcLogin(t, oh, ti)
+	rec := oidcCallback(t, oh, cookie, state, testOidcCode)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first login status = %d, body %s", rec.Code, rec.Body)
+	}
+	uid := tokenUID(t, rec)
+	if u := db.users[uid]; u.Name != "tama" || u.Role != model.UserRoleUser {
+		t.Fatalf("created user = %#v", u)
+	}
+	if len(db.identities) != 1 || db.identities[0].Subject != "sub-1" || db.identities[0].UID != uid {
+		t.Fatalf("identities = %#v", db.identities)
+	}
+
+	cookie, state = startOidcLogin(t, oh, ti)
+	rec = oidcCallback(t, oh, cookie, state, testOidcCode)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("second login status = %d, body %s", rec.Code, rec.Body)
+	}
+	if got := tokenUID(t, rec); got != uid {
+		t.Errorf("second login uid = %d, want %d", got, uid)
+	}
+	if len(db.users) != 1 || len(db.identities) != 1 {
+		t.Errorf("second login created a user: %d users, %d identities", len(db.users), len(db.identities))
+	}
+}
+
+func TestOidcCallbackRenamesOnNameConflict(t *testing.T) {
+	ti := newTestIssuer(t)
+	defer ti.Close()
+	db := newFakeOidcDb()
+	db.users[100] = model.User{Id: 100, Name: "tama"}
+	oh := newTestOidcHandler(ti, db)
+
+	cookie, state := startOidcLogin(t, oh, ti)
+	rec := oidcCallback(t, oh, cookie, state, testOidcCode)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	uid := tokenUID(t, rec)
+	if uid == 100 || !strings.HasPrefix(db.users[uid].Name, "tama-") {
+		t.Errorf("linked to uid %d named %q", uid, db.users[uid].Name)
+	}
+}
+
+func TestOidcCallbackRecreatesUserForStaleIdentity(t *testing.T) {
+	ti := newTestIssuer(t)
+	defer ti.Close()
+	db := newFakeOidcDb()
+	db.nextId = 10
+	db.identities = []model.UserIdentity{{Id: 5, UID: 99, Provider: testOidcProvider, Subject: "sub-1"}}
+	oh := newTestOidcHandler(ti, db)
+
+	cookie, state := startOidcLogin(t, oh, ti)
+	rec := oidcCallback(t, oh, cookie, state, testOidcCode)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	uid := tokenUID(t, rec)
+	if uid == 99 || db.users[uid].Name != "tama" {
+		t.Errorf("logged in as uid %d, users %#v", uid, db.users)
+	}
+	if len(db.identities) != 1 || db.identities[0].UID != uid {
+		t.Errorf("identities = %#v", db.identities)
+	}
+}
+
+func TestOidcCallbackRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		state func(string) string
+		code  string
+		nonce string
+		want  int
+	}{
+		{"state mismatch", func(string) string { return "forged" }, testOidcCode, "", http.StatusBadRequest},
+		{"nonce mismatch", nil, testOidcCode, "replayed", http.StatusUnauthorized},
+		{"bad code", nil, "bad-code", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ti := newTestIssuer(t)
+			defer ti.Close()
+			ti.nonce = tt.nonce
+			db := newFakeOidcDb()
+			oh := newTestOidcHandler(ti, db)
+
+			cookie, state := startOidcLogin(t, oh, ti)
+			if tt.state != nil {
+				state = tt.state(state)
+			}
+			rec := oidcCallback(t, oh, cookie, state, tt.code)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d, body %s", rec.Code, tt.want, rec.Body)
+			}
+			if len(db.users) != 0 {
+				t.Errorf("created users %#v", db.users)
+			}
+		})
+	}
+}
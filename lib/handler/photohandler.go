@@ -0,0 +1,472 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/blob"
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/greytabby/meowapi/lib/photo"
+	"github.com/labstack/echo"
+)
+
+const (
+	// DefaultPhotoMaxBytes アップロードできる画像の最大サイズの既定値
+	DefaultPhotoMaxBytes = 10 << 20
+	// DefaultPhotoURLTTL 画像のURLの有効期間の既定値
+	DefaultPhotoURLTTL = time.Hour
+
+	// photoFormField 画像をアップロードするmultipartのフィールド名
+	photoFormField = "photo"
+
+	photoVariantOriginal  = "original"
+	photoVariantThumbnail = "thumb"
+
+	// maxUseToiletPhotos usetoilet1件に添付できる画像の数
+	maxUseToiletPhotos = 10
+)
+
+// PhotoDbAccessor photoテーブルとcatの画像を操作するinterface
+type PhotoDbAccessor interface {
+	CatReader
+	UseToiletReader
+	UpdateCat(cat model.Cat) error
+	AddPhoto(p model.Photo) (model.Photo, error)
+	GetPhoto(id int64) (model.Photo, error)
+	DeletePhoto(p model.Photo) error
+	AttachUseToiletPhotos(utid, hid int64, photoids []int64, max int) error
+	GetUseToiletPhotos(utid, hid int64) ([]model.UseToiletPhoto, error)
+	GetUseToiletPhotoImages(hid int64, utids []int64) (map[int64][]model.Photo, error)
+	DeleteUseToiletPhoto(up model.UseToiletPhoto) error
+}
+
+// PhotoHandler 画像のアップロードと配信を行う
+// 画像は署名付きの期限付きURL(/photo/:id/:variant)で配信する
+type PhotoHandler struct {
+	Db    PhotoDbAccessor
+	Store blob.Store
+	// MaxBytes 0の場合はDefaultPhotoMaxBytes
+	MaxBytes int64
+	// URLTTL 0の場合はDefaultPhotoURLTTL
+	URLTTL time.Duration
+}
+
+// UploadCatPhoto catの画像をアップロードする
+// 既に画像がある場合は置き換える
+func (ph *PhotoHandler) UploadCatPhoto(c echo.Context) error {
+	cat, err := ph.selectCat(c)
+	if err != nil {
+		return err
+	}
+	p, err := ph.savePhoto(c, cat.HouseholdId)
+	if err != nil {
+		return err
+	}
+
+	old := cat.PhotoId
+	cat.PhotoId = p.Id
+	if err := ph.Db.UpdateCat(cat); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		ph.removePhoto(c, p)
+		return c.String(http.StatusInternalServerError, "Could not update cat info.")
+	}
+	if old != 0 {
+		ph.removePhotoById(c, old)
+	}
+	c.Logger().Infof("Added: %#v", p)
+	return c.JSON(http.StatusOK, ph.links(p))
+}
+
+// GetCatPhoto catの画像のURLを返す
+func (ph *PhotoHandler) GetCatPhoto(c echo.Context) error {
+	cat, err := ph.selectCat(c)
+	if err != nil {
+		return err
+	}
+	if cat.PhotoId == 0 {
+		return c.String(http.StatusNotFound, "The cat has no photo.")
+	}
+	p, err := ph.Db.GetPhoto(cat.PhotoId)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusNotFound, "The cat has no photo.")
+	}
+	return c.JSON(http.StatusOK, ph.links(p))
+}
+
+// DeleteCatPhoto catの画像を削除する
+func (ph *PhotoHandler) DeleteCatPhoto(c echo.Context) error {
+	cat, err := ph.selectCat(c)
+	if err != nil {
+		return err
+	}
+	if cat.PhotoId == 0 {
+		return c.String(http.StatusNotFound, "The cat has no photo.")
+	}
+	old := cat.PhotoId
+	cat.PhotoId = 0
+	if err := ph.Db.UpdateCat(cat); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusInternalServerError, "Could not update cat info.")
+	}
+	ph.removePhotoById(c, old)
+	return c.String(http.StatusOK, "")
+}
+
+// AttachUseToiletPhotos usetoiletに画像を添付する
+// multipartのphotoフィールドで複数の画像を送信できる。全ての画像を添付するか、1枚も添付しない
+func (ph *PhotoHandler) AttachUseToiletPhotos(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Logger().Errorf("Param parse: ", err)
+		return c.String(http.StatusBadRequest, "Param parse: "+err.Error())
+	}
+	hid := HouseholdIdFromContext(c)
+	ut, err := ph.Db.GetUseToilet(id, hid)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusNotFound, "No your specified usetoilet.")
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File[photoFormField]) == 0 {
+		return c.String(http.StatusBadRequest, "Photo is not specified.")
+	}
+	files := form.File[photoFormField]
+	attached, err := ph.Db.GetUseToiletPhotos(ut.Id, hid)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	if len(attached)+len(files) > maxUseToiletPhotos {
+		return c.String(http.StatusBadRequest,
+			fmt.Sprintf("Up to %d photos can be attached.", maxUseToiletPhotos))
+	}
+
+	var saved []model.Photo
+	removeSaved := func() {
+		for _, p := range saved {
+			ph.removePhoto(c, p)
+		}
+	}
+	for _, fh := range files {
+		p, err := ph.savePhotoFile(c, hid, fh)
+		if err != nil {
+			removeSaved()
+			return err
+		}
+		saved = append(saved, p)
+	}
+
+	// the count is checked again under a lock, another request may have attached photos meanwhile.
+	pids := make([]int64, len(saved))
+	for i, p := range saved {
+		pids[i] = p.Id
+	}
+	if err := ph.Db.AttachUseToiletPhotos(ut.Id, hid, pids, maxUseToiletPhotos); err != nil {
+		removeSaved()
+		if err == model.ErrTooManyPhotos {
+			return c.String(http.StatusBadRequest,
+				fmt.Sprintf("Up to %d photos can be attached.", maxUseToiletPhotos))
+		}
+		c.Logger().Errorf("Insert: ", err)
+		return c.String(http.StatusInternalServerError, "Could not attach photo.")
+	}
+
+	links := make([]model.PhotoLinks, len(saved))
+	for i, p := range saved {
+		links[i] = ph.links(p)
+	}
+	c.Logger().Infof("Attached: usetoilet %d photos %v", ut.Id, pids)
+	return c.JSON(http.StatusOK, links)
+}
+
+// DetachUseToiletPhoto usetoiletに添付した画像を削除する
+func (ph *PhotoHandler) DetachUseToiletPhoto(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Logger().Errorf("Param parse: ", err)
+		return c.String(http.StatusBadRequest, "Param parse: "+err.Error())
+	}
+	photoid, err := strconv.ParseInt(c.Param("photoid"), 10, 64)
+	if err != nil {
+		c.Logger().Errorf("Param parse: ", err)
+		return c.String(http.StatusBadRequest, "Param parse: "+err.Error())
+	}
+	ups, err := ph.Db.GetUseToiletPhotos(id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	for _, up := range ups {
+		if up.PhotoId != photoid {
+			continue
+		}
+		if err := ph.Db.DeleteUseToiletPhoto(up); err != nil {
+			c.Logger().Errorf("Delete: ", err)
+			return c.String(http.StatusInternalServerError, "Failed delete the photo.")
+		}
+		ph.removePhotoById(c, up.PhotoId)
+		c.Logger().Infof("Deleted: %#v", up)
+		return c.String(http.StatusOK, "")
+	}
+	return c.String(http.StatusNotFound, "No your specified photo.")
+}
+
+// useToiletPhotoLinks usetoiletsの添付画像のURLをusetoiletごとに返す
+func (ph *PhotoHandler) useToiletPhotoLinks(hid int64, usetoilets []model.UseToilet) (map[int64][]model.PhotoLinks, error) {
+	utids := make([]int64, len(usetoilets))
+	for i, ut := range usetoilets {
+		utids[i] = ut.Id
+	}
+	photos, err := ph.Db.GetUseToiletPhotoImages(hid, utids)
+	if err != nil {
+		return nil, err
+	}
+	links := map[int64][]model.PhotoLinks{}
+	for utid, ps := range photos {
+		for _, p := range ps {
+			links[utid] = append(links[utid], ph.links(p))
+		}
+	}
+	return links, nil
+}
+
+// removeUseToiletPhotos usetoiletに添付した全ての画像を削除する
+func (ph *PhotoHandler) removeUseToiletPhotos(c echo.Context, utid, hid int64) {
+	ups, err := ph.Db.GetUseToiletPhotos(utid, hid)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return
+	}
+	for _, up := range ups {
+		if err := ph.Db.DeleteUseToiletPhoto(up); err != nil {
+			c.Logger().Errorf("Delete: ", err)
+			continue
+		}
+		ph.removePhotoById(c, up.PhotoId)
+	}
+}
+
+// Serve 署名付きURLの画像を返す
+// 認証はURLの署名で行うため、jwtの認証を行わないルートに登録する
+func (ph *PhotoHandler) Serve(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusNotFound, "")
+	}
+	variant := c.Param("variant")
+	exp, err := strconv.ParseInt(c.QueryParam("exp"), 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return c.String(http.StatusForbidden, "URL has expired")
+	}
+	sig, err := hex.DecodeString(c.QueryParam("sig"))
+	if err != nil || !hmac.Equal(sig, photoSignature(id, variant, exp)) {
+		return c.String(http.StatusForbidden, "Invalid signature")
+	}
+
+	p, err := ph.Db.GetPhoto(id)
+	if err != nil {
+		return c.String(http.StatusNotFound, "")
+	}
+	key := p.Key
+	switch variant {
+	case photoVariantOriginal:
+	case photoVariantThumbnail:
+		key = p.ThumbKey
+	default:
+		return c.String(http.StatusNotFound, "")
+	}
+
+	r, info, err := ph.Store.Get(key)
+	if err == blob.ErrNotFound {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Errorf("Blob get: ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	defer r.Close()
+	c.Response().Header().Set("Cache-Control", "private, max-age="+strconv.FormatInt(exp-time.Now().Unix(), 10))
+	return c.Stream(http.StatusOK, info.ContentType, r)
+}
+
+// savePhoto multipartのphotoフィールドの画像を保存する
+// 失敗した場合はそのままhandlerから返すerrorを返す
+func (ph *PhotoHandler) savePhoto(c echo.Context, hid int64) (model.Photo, error) {
+	fh, err := c.FormFile(photoFormField)
+	if err != nil {
+		return model.Photo{}, echo.NewHTTPError(http.StatusBadRequest, "Photo is not specified.")
+	}
+	return ph.savePhotoFile(c, hid, fh)
+}
+
+// savePhotoFile アップロードされた画像を検証してBlobStoreとphotoテーブルに保存する
+// 失敗した場合はそのままhandlerから返すerrorを返す
+func (ph *PhotoHandler) savePhotoFile(c echo.Context, hid int64, fh *multipart.FileHeader) (model.Photo, error) {
+	maxBytes := ph.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultPhotoMaxBytes
+	}
+	if fh.Size > maxBytes {
+		return model.Photo{}, echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Photo is too large.")
+	}
+	f, err := fh.Open()
+	if err != nil {
+		c.Logger().Errorf("Open: ", err)
+		return model.Photo{}, echo.NewHTTPError(http.StatusBadRequest, "Could not read photo.")
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(f, maxBytes+1))
+	if err != nil {
+		c.Logger().Errorf("Read: ", err)
+		return model.Photo{}, echo.NewHTTPError(http.StatusBadRequest, "Could not read photo.")
+	}
+	if int64(len(data)) > maxBytes {
+		return model.Photo{}, echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Photo is too large.")
+	}
+
+	res, err := photo.Process(data)
+	switch err {
+	case nil:
+	case photo.ErrUnsupportedType:
+		return model.Photo{}, echo.NewHTTPError(http.StatusUnsupportedMediaType, "Photo must be JPEG, PNG or WebP.")
+	case photo.ErrTooLarge:
+		return model.Photo{}, echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Photo has too many pixels.")
+	default:
+		c.Logger().Errorf("Photo: ", err)
+		return model.Photo{}, echo.NewHTTPError(http.StatusBadRequest, "Invalid image.")
+	}
+
+	name, err := randomHex(16)
+	if err != nil {
+		c.Logger().Errorf("Random: ", err)
+		return model.Photo{}, echo.NewHTTPError(http.StatusInternalServerError, "")
+	}
+	p := model.Photo{
+		UID:         UserIdFromToken(c),
+		HouseholdId: hid,
+		Key:         fmt.Sprintf("photo/%d/%s%s", hid, name, photoExtension(res.ContentType)),
+		ThumbKey:    fmt.Sprintf("photo/%d/%s_thumb.jpg", hid, name),
+		ContentType: res.ContentType,
+		Size:        int64(len(res.Data)),
+		Width:       res.Width,
+		Height:      res.Height,
+	}
+	if err := ph.Store.Put(p.Key, bytes.NewReader(res.Data), p.Size, p.ContentType); err != nil {
+		c.Logger().Errorf("Blob put: ", err)
+		return model.Photo{}, echo.NewHTTPError(http.StatusInternalServerError, "Could not save photo.")
+	}
+	if err := ph.Store.Put(p.ThumbKey, bytes.NewReader(res.Thumbnail), int64(len(res.Thumbnail)), photo.TypeJPEG); err != nil {
+		c.Logger().Errorf("Blob put: ", err)
+		ph.Store.Delete(p.Key)
+		return model.Photo{}, echo.NewHTTPError(http.StatusInternalServerError, "Could not save photo.")
+	}
+	saved, err := ph.Db.AddPhoto(p)
+	if err != nil {
+		c.Logger().Errorf("Insert: ", err)
+		ph.Store.Delete(p.Key)
+		ph.Store.Delete(p.ThumbKey)
+		return model.Photo{}, echo.NewHTTPError(http.StatusInternalServerError, "Could not save photo.")
+	}
+	return saved, nil
+}
+
+// removePhoto photoテーブルのデータとBlobStoreの画像を削除する
+// 削除の失敗はログに残すのみとする
+func (ph *PhotoHandler) removePhoto(c echo.Context, p model.Photo) {
+	if err := ph.Db.DeletePhoto(p); err != nil {
+		c.Logger().Errorf("Delete: ", err)
+	}
+	ph.removeBlobs(c, []string{p.Key, p.ThumbKey})
+}
+
+// removeBlobs BlobStoreからkeysを削除する
+// 削除の失敗はログに残すのみとする
+func (ph *PhotoHandler) removeBlobs(c echo.Context, keys []string) {
+	for _, key := range keys {
+		if err := ph.Store.Delete(key); err != nil {
+			c.Logger().Errorf("Blob delete: %s %v", key, err)
+		}
+	}
+}
+
+// removePhotoById idの画像が存在すれば削除する
+func (ph *PhotoHandler) removePhotoById(c echo.Context, id int64) {
+	if p, err := ph.Db.GetPhoto(id); err == nil {
+		ph.removePhoto(c, p)
+	}
+}
+
+// links 画像とサムネイルの署名付きURLを返す
+func (ph *PhotoHandler) links(p model.Photo) model.PhotoLinks {
+	ttl := ph.URLTTL
+	if ttl <= 0 {
+		ttl = DefaultPhotoURLTTL
+	}
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	return model.PhotoLinks{
+		Id:           p.Id,
+		Url:          photoURL(p.Id, photoVariantOriginal, expires.Unix()),
+		ThumbnailUrl: photoURL(p.Id, photoVariantThumbnail, expires.Unix()),
+		Width:        p.Width,
+		Height:       p.Height,
+		Expires:      expires,
+	}
+}
+
+// selectCat パスパラメータ:idのcatを取得する
+// 取得できなかった場合はそのままhandlerから返すerrorを返す
+func (ph *PhotoHandler) selectCat(c echo.Context) (model.Cat, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Logger().Errorf("Param parse: ", err)
+		return model.Cat{}, echo.NewHTTPError(http.StatusBadRequest, "Param parse: "+err.Error())
+	}
+	cat, err := ph.Db.GetCat(id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return model.Cat{}, echo.NewHTTPError(http.StatusNotFound, "No your specified cat.")
+	}
+	return cat, nil
+}
+
+func photoURL(id int64, variant string, exp int64) string {
+	return fmt.Sprintf("/photo/%d/%s?exp=%d&sig=%s",
+		id, variant, exp, hex.EncodeToString(photoSignature(id, variant, exp)))
+}
+
+// photoSignature jwtと同じ鍵で画像のURLに署名する
+func photoSignature(id int64, variant string, exp int64) []byte {
+	mac := hmac.New(sha256.New, signingKey)
+	fmt.Fprintf(mac, "photo:%d:%s:%d", id, variant, exp)
+	return mac.Sum(nil)
+}
+
+func photoExtension(contentType string) string {
+	switch contentType {
+	case photo.TypePNG:
+		return ".png"
+	case photo.TypeWebP:
+		return ".webp"
+	}
+	return ".jpg"
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
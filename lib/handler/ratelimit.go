@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/ratelimit"
+	"github.com/labstack/echo"
+)
+
+// checkLimit keyがブロック中であれば再試行までの待ち時間を返す
+// limiterがnilの場合やStoreのエラー時は制限しない
+func checkLimit(c echo.Context, l *ratelimit.Limiter, key string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	wait, err := l.Allow(key)
+	if err != nil {
+		c.Logger().Error("Ratelimit: ", err)
+		return 0
+	}
+	return wait
+}
+
+// recordFailure keyの失敗を記録する
+func recordFailure(c echo.Context, l *ratelimit.Limiter, key string) {
+	if l == nil {
+		return
+	}
+	if _, err := l.Fail(key); err != nil {
+		c.Logger().Error("Ratelimit: ", err)
+	}
+}
+
+// resetLimit keyの失敗回数を消去する
+func resetLimit(c echo.Context, l *ratelimit.Limiter, key string) {
+	if l == nil {
+		return
+	}
+	if err := l.Reset(key); err != nil {
+		c.Logger().Error("Ratelimit: ", err)
+	}
+}
+
+// tooManyRequests Retry-Afterを付けて429を返す
+func tooManyRequests(c echo.Context, wait time.Duration) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return c.String(http.StatusTooManyRequests, "Too many attempts. Retry later.")
+}
+
+// ParseTrustedProxies カンマ区切りのCIDRまたはIPアドレスを逆プロキシの一覧として解釈する
+func ParseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !strings.Contains(f, "/") {
+			ip := net.ParseIP(f)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", f)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(f)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// clientIP 制限に使う接続元IPを返す
+// 接続元がtrustedに含まれる逆プロキシの場合のみX-Forwarded-ForとX-Real-IPを参照する
+func clientIP(c echo.Context, trusted []*net.IPNet) string {
+	r := c.Request()
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustedProxy(host, trusted) {
+		return host
+	}
+	// walk from the nearest hop, the left part can be set freely by the client.
+	if xff := r.Header.Get(echo.HeaderXForwardedFor); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			host = hop
+			if !trustedProxy(hop, trusted) {
+				return hop
+			}
+		}
+		return host
+	}
+	if ip := strings.TrimSpace(r.Header.Get(echo.HeaderXRealIP)); net.ParseIP(ip) != nil {
+		return ip
+	}
+	return host
+}
+
+// trustedProxy hostがtrustedのいずれかに含まれるか判定する
+func trustedProxy(host string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/labstack/echo"
+)
+
+// Permissions
+const (
+	PermCatRead        = "cat:read"
+	PermCatWrite       = "cat:write"
+	PermToiletRead     = "toilet:read"
+	PermToiletWrite    = "toilet:write"
+	PermUseToiletRead  = "usetoilet:read"
+	PermUseToiletWrite = "usetoilet:write"
+	PermWashRead       = "wash:read"
+	PermWashWrite      = "wash:write"
+	PermFeedingRead    = "feeding:read"
+	PermFeedingWrite   = "feeding:write"
+	PermMedicalRead    = "medical:read"
+	PermMedicalWrite   = "medical:write"
+	PermHouseholdRead  = "household:read"
+	PermHouseholdWrite = "household:write"
+	PermWebhookRead    = "webhook:read"
+	PermWebhookWrite   = "webhook:write"
+	PermApiKeyManage   = "apikey:manage"
+	PermTotpManage     = "totp:manage"
+	PermProfileManage  = "profile:manage"
+	PermAdminUsers     = "admin:users"
+	PermAdminJobs      = "admin:jobs"
+)
+
+var readPermissions = []string{
+	PermCatRead, PermToiletRead, PermUseToiletRead, PermWashRead, PermFeedingRead, PermMedicalRead, PermHouseholdRead,
+	PermWebhookRead,
+}
+
+var writePermissions = []string{
+	PermCatWrite, PermToiletWrite, PermUseToiletWrite, PermWashWrite, PermFeedingWrite, PermMedicalWrite, PermHouseholdWrite,
+	PermWebhookWrite,
+}
+
+// RolePermissions ユーザの役割ごとに付与する権限
+var RolePermissions = map[string][]string{
+	model.UserRoleAdmin: concat(readPermissions, writePermissions,
+		[]string{PermApiKeyManage, PermTotpManage, PermProfileManage, PermAdminUsers, PermAdminJobs}),
+	model.UserRoleUser: concat(readPermissions, writePermissions,
+		[]string{PermApiKeyManage, PermTotpManage, PermProfileManage}),
+	model.UserRoleReadonly: concat(readPermissions,
+		[]string{PermTotpManage, PermProfileManage}),
+}
+
+// PermissionTable ルートごとに必要な権限
+// keyは "<METHOD> <echoのルートのpath>" (例: "GET /api/cat")
+type PermissionTable map[string]string
+
+// Authorize PermissionTableに従ってトークンの権限を確認するmiddleware
+// テーブルに無いルートは拒否する。jwtによる認証の後に登録すること
+func Authorize(table PermissionTable) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			// unmatched paths reach the catch-all route of the group and end up in 404.
+			if strings.HasSuffix(c.Path(), "/*") {
+				return next(c)
+			}
+			route := c.Request().Method + " " + c.Path()
+			perm, ok := table[route]
+			if !ok {
+				c.Logger().Errorf("Authorize: no permission is declared for %s", route)
+				return c.String(http.StatusForbidden, "Permission denied")
+			}
+			if !HasPermission(c, perm) {
+				return c.String(http.StatusForbidden, "Permission denied: missing "+perm)
+			}
+			return next(c)
+		}
+	}
+}
+
+// HasPermission トークンが権限を持っているか判定する
+func HasPermission(c echo.Context, perm string) bool {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return false
+	}
+	claims := token.Claims.(*jwtCustomClaims)
+	for _, p := range claims.permissions() {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// permissions トークンの権限を返す
+// 役割を持たない古いトークンは通常のユーザとして扱う
+func (c *jwtCustomClaims) permissions() []string {
+	if c.Role == "" {
+		return RolePermissions[model.UserRoleUser]
+	}
+	return c.Permissions
+}
+
+// intersect aとbの両方に含まれる権限を返す
+func intersect(a, b []string) []string {
+	set := map[string]bool{}
+	for _, p := range b {
+		set[p] = true
+	}
+	var out []string
+	for _, p := range a {
+		if set[p] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func concat(lists ...[]string) []string {
+	var out []string
+	for _, l := range lists {
+		out = append(out, l...)
+	}
+	return out
+}
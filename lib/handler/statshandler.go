@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/labstack/echo"
+)
+
+// statsPeriods group_byごとの既定の期間数と上限
+var statsPeriods = map[string]struct{ def, max int }{
+	model.StatsGroupDay:   {30, 366},
+	model.StatsGroupWeek:  {12, 104},
+	model.StatsGroupMonth: {12, 60},
+}
+
+// StatsDbAccessor 記録を集計するためのinterface
+type StatsDbAccessor interface {
+	CatReader
+	GetUser(id int64) (model.User, error)
+	GetUseToiletCounts(hid int64, f model.RecordFilter, loc *time.Location) ([]model.UseToiletCount, error)
+}
+
+// StatsHandler /api/statsへのリクエストを処理する
+type StatsHandler struct {
+	Db StatsDbAccessor
+}
+
+// GetUseToiletStats catごとのトイレの回数を日、週(月曜始まり)、月ごとに集計して返す
+// 今日を含む直近periods期間分を、ユーザのタイムゾーンの日付で集計する
+// cat_idを指定しない場合は世帯の全てのcatについて返す
+func (sh *StatsHandler) GetUseToiletStats(c echo.Context) error {
+	groupBy := c.QueryParam("group_by")
+	if groupBy == "" {
+		groupBy = model.StatsGroupDay
+	}
+	limit, ok := statsPeriods[groupBy]
+	if !ok {
+		return c.String(http.StatusBadRequest, "Invalid group_by.")
+	}
+	periods, err := queryInt(c, "periods", limit.def)
+	if err != nil || periods <= 0 || periods > limit.max {
+		return c.String(http.StatusBadRequest, "Invalid periods.")
+	}
+
+	hid := HouseholdIdFromContext(c)
+	var cats []model.Cat
+	if s := c.QueryParam("cat_id"); s != "" {
+		catid, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return c.String(http.StatusBadRequest, "Invalid cat_id.")
+		}
+		cat, err := sh.Db.GetCat(catid, hid)
+		if err != nil {
+			c.Logger().Errorf("Select: ", err)
+			return c.String(http.StatusNotFound, "No your specified cat.")
+		}
+		cats = []model.Cat{cat}
+	} else {
+		cats, err = sh.Db.GetAllCats(hid)
+		if err != nil {
+			c.Logger().Errorf("Select: ", err)
+			return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+		}
+	}
+
+	user, err := sh.Db.GetUser(UserIdFromToken(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	loc := user.Location()
+	starts := bucketStarts(groupBy, periods, time.Now().In(loc))
+	from, to := starts[0], starts[len(starts)-1]
+	starts = starts[:len(starts)-1]
+
+	f := model.RecordFilter{From: &from, To: &to}
+	if len(cats) == 1 {
+		f.CatId = cats[0].Id
+	}
+	counts, err := sh.Db.GetUseToiletCounts(hid, f, loc)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+
+	stats := make([]model.UseToiletStats, len(cats))
+	for i, cat := range cats {
+		stats[i] = model.UseToiletStats{CatId: cat.Id, Buckets: foldUseToiletCounts(cat.Id, starts, counts, loc)}
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
+// bucketStarts nowを含む直近periods期間の開始日と、最後の期間の翌日を返す
+func bucketStarts(groupBy string, periods int, now time.Time) []time.Time {
+	loc := now.Location()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	step := func(t time.Time, n int) time.Time { return t.AddDate(0, 0, n) }
+	switch groupBy {
+	case model.StatsGroupWeek:
+		// weeks start on monday.
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+		step = func(t time.Time, n int) time.Time { return t.AddDate(0, 0, 7*n) }
+	case model.StatsGroupMonth:
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+		step = func(t time.Time, n int) time.Time { return t.AddDate(0, n, 0) }
+	}
+	starts := make([]time.Time, periods+1)
+	for i := range starts {
+		starts[i] = step(start, i-periods+1)
+	}
+	return starts
+}
+
+// foldUseToiletCounts 1日ごとの回数をstartsで始まる期間ごとにまとめる
+func foldUseToiletCounts(catid int64, starts []time.Time, counts []model.UseToiletCount, loc *time.Location) []model.UseToiletBucket {
+	buckets := make([]model.UseToiletBucket, len(starts))
+	toilets := make([]map[int64]*model.ToiletUsageCounts, len(starts))
+	for i, s := range starts {
+		buckets[i] = model.UseToiletBucket{Start: s.Format("2006-01-02"), Types: map[string]int64{}, Toilets: []model.ToiletUsageCounts{}}
+		toilets[i] = map[int64]*model.ToiletUsageCounts{}
+	}
+	for _, uc := range counts {
+		if uc.CatId != catid {
+			continue
+		}
+		day, err := time.ParseInLocation("2006-01-02", uc.Day, loc)
+		if err != nil {
+			continue
+		}
+		// the last bucket whose start is not after the day.
+		i := sort.Search(len(starts), func(i int) bool { return starts[i].After(day) }) - 1
+		if i < 0 {
+			continue
+		}
+		b := &buckets[i]
+		b.Total += uc.Count
+		b.Types[uc.Type] += uc.Count
+		t, ok := toilets[i][uc.ToiletId]
+		if !ok {
+			t = &model.ToiletUsageCounts{ToiletId: uc.ToiletId, Types: map[string]int64{}}
+			toilets[i][uc.ToiletId] = t
+		}
+		t.Total += uc.Count
+		t.Types[uc.Type] += uc.Count
+	}
+	for i := range buckets {
+		for _, t := range toilets[i] {
+			buckets[i].Toilets = append(buckets[i].Toilets, *t)
+		}
+		sort.Slice(buckets[i].Toilets, func(a, b int) bool {
+			return buckets[i].Toilets[a].ToiletId < buckets[i].Toilets[b].ToiletId
+		})
+	}
+	return buckets
+}
@@ -2,14 +2,19 @@ package handler
 
 import (
 	"net/http"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/greytabby/meowapi/lib/model"
 	"github.com/labstack/echo"
 )
 
 type ToiletReader interface {
-	GetAllToilets(uid int64) ([]model.Toilet, error)
-	GetToilet(id, uid int64) (model.Toilet, error)
+	GetAllToilets(hid int64) ([]model.Toilet, error)
+	GetToilet(id, hid int64) (model.Toilet, error)
+	GetSandStateHistory(toiletid, hid int64) ([]model.SandStateTransition, error)
+	GetLastWashes(hid int64) ([]model.LastWash, error)
 }
 
 type ToiletManipulator interface {
@@ -21,6 +26,7 @@ type ToiletManipulator interface {
 // ToiletDbAccessor toiletテーブルを操作するinterface
 type ToiletDbAccessor interface {
 	ToiletReader
+	LitterReader
 	ToiletManipulator
 }
 
@@ -30,13 +36,18 @@ type ToiletHandler struct {
 }
 
 // GetAllToilets Toiletテーブルから全てのToiletを返す
+// 最後に砂を全て入れ替えてからの日数を含める
 func (th *ToiletHandler) GetAllToilets(c echo.Context) error {
-	uid := UserIdFromToken(c)
-	toilets, err := th.Db.GetAllToilets(uid)
+	hid := HouseholdIdFromContext(c)
+	toilets, err := th.Db.GetAllToilets(hid)
 	if err != nil {
 		c.Logger().Errorf("Select: ", err)
 		return c.String(http.StatusBadRequest, "Select: "+err.Error())
 	}
+	now := time.Now()
+	for i := range toilets {
+		toilets[i].SetSandAge(now)
+	}
 	return c.JSON(http.StatusOK, toilets)
 }
 
@@ -49,8 +60,22 @@ func (th *ToiletHandler) AddToilet(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
 	}
 
+	if toilet.ScoopHours < 0 || toilet.ChangeDays < 0 {
+		return c.String(http.StatusBadRequest, "Invalid cleaning interval.")
+	}
+
 	uid := UserIdFromToken(c)
 	toilet.UID = uid
+	toilet.HouseholdId = HouseholdIdFromContext(c)
+	if toilet.LitterId != 0 {
+		if _, err := th.Db.GetLitter(toilet.LitterId, toilet.HouseholdId); err != nil {
+			c.Logger().Errorf("Select: ", err)
+			return c.String(http.StatusNotFound, "No your specified litter.")
+		}
+	}
+	toilet.SandState = model.SandStateClean
+	toilet.UsageCount = 0
+	toilet.SandChanged = nil
 	if err := th.Db.AddToilet(toilet); err != nil {
 		c.Logger().Errorf("Insert: ", err)
 		return c.String(http.StatusBadRequest, "Could not add new toilet.")
@@ -59,9 +84,21 @@ func (th *ToiletHandler) AddToilet(c echo.Context) error {
 	return c.String(http.StatusOK, "")
 }
 
+// toiletUpdateRequest toiletの更新内容
+// 掃除の間隔と砂の在庫は指定しない場合(null)に今の値のままとし、0と区別する
+// litteridの0は砂の在庫の設定を外す
+type toiletUpdateRequest struct {
+	Id         int64  `json:"id"`
+	Name       string `json:"name"`
+	Comment    string `json:"comment"`
+	ScoopHours *int   `json:"scoophours"`
+	ChangeDays *int   `json:"fullchangedays"`
+	LitterId   *int64 `json:"litterid"`
+}
+
 // UpdateToilet Toiletの情報を1件更新する
 func (th *ToiletHandler) UpdateToilet(c echo.Context) error {
-	var toilet, selectedToilet model.Toilet
+	var toilet toiletUpdateRequest
 
 	if err := c.Bind(&toilet); err != nil {
 		c.Logger().Errorf("Bind: ", err)
@@ -71,19 +108,37 @@ func (th *ToiletHandler) UpdateToilet(c echo.Context) error {
 	if toilet.Id == 0 {
 		return c.String(http.StatusBadRequest, "Toilet id not specified.")
 	}
+	if (toilet.ScoopHours != nil && *toilet.ScoopHours < 0) || (toilet.ChangeDays != nil && *toilet.ChangeDays < 0) {
+		return c.String(http.StatusBadRequest, "Invalid cleaning interval.")
+	}
 
 	// Get cat from db for confirming wheather the user specified cat exist.
-	uid := UserIdFromToken(c)
-	selectedToilet, err := th.Db.GetToilet(toilet.Id, uid)
+	hid := HouseholdIdFromContext(c)
+	selectedToilet, err := th.Db.GetToilet(toilet.Id, hid)
 	if err != nil {
 		c.Logger().Errorf("Select: ", err)
 		return c.String(http.StatusBadRequest, "No your specified cat in the database.")
 	}
 
-	// Update information.
+	if toilet.LitterId != nil && *toilet.LitterId != 0 && *toilet.LitterId != selectedToilet.LitterId {
+		if _, err := th.Db.GetLitter(*toilet.LitterId, hid); err != nil {
+			c.Logger().Errorf("Select: ", err)
+			return c.String(http.StatusNotFound, "No your specified litter.")
+		}
+	}
+
+	// Update information. SandState is derived from visits and washes.
 	selectedToilet.Name = toilet.Name
 	selectedToilet.Comment = toilet.Comment
-	selectedToilet.SandState = toilet.SandState
+	if toilet.ScoopHours != nil {
+		selectedToilet.ScoopHours = *toilet.ScoopHours
+	}
+	if toilet.ChangeDays != nil {
+		selectedToilet.ChangeDays = *toilet.ChangeDays
+	}
+	if toilet.LitterId != nil {
+		selectedToilet.LitterId = *toilet.LitterId
+	}
 	if err := th.Db.UpdateToilet(selectedToilet); err != nil {
 		c.Logger().Errorf("Update: ", err)
 		return c.String(http.StatusBadRequest, "Could not update toilet info.")
@@ -105,8 +160,8 @@ func (th *ToiletHandler) DeleteToilet(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "Toilet id is not specified.")
 	}
 
-	uid := UserIdFromToken(c)
-	selectedToilet, err := th.Db.GetToilet(toilet.Id, uid)
+	hid := HouseholdIdFromContext(c)
+	selectedToilet, err := th.Db.GetToilet(toilet.Id, hid)
 	if err != nil {
 		c.Logger().Errorf("Select: ", err)
 		return c.String(http.StatusBadRequest, "No your specified toilet.")
@@ -119,3 +174,83 @@ func (th *ToiletHandler) DeleteToilet(c echo.Context) error {
 	c.Logger().Infof("Deleted: %#v", selectedToilet)
 	return c.String(http.StatusOK, "")
 }
+
+// GetSandStateHistory toiletの砂の状態の変化の履歴を新しい順に返す
+func (th *ToiletHandler) GetSandStateHistory(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid toilet id.")
+	}
+	hid := HouseholdIdFromContext(c)
+	if _, err := th.Db.GetToilet(id, hid); err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusNotFound, "No your specified toilet.")
+	}
+	history, err := th.Db.GetSandStateHistory(id, hid)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, history)
+}
+
+// GetDueToilets 掃除の期限を過ぎたtoiletを期限の古い順に返す
+// 期限は最後の掃除(一度も掃除していない場合はtoiletの登録日時)に掃除の間隔を足した日時
+func (th *ToiletHandler) GetDueToilets(c echo.Context) error {
+	hid := HouseholdIdFromContext(c)
+	toilets, err := th.Db.GetAllToilets(hid)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	last, err := th.Db.GetLastWashes(hid)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, dueToilets(toilets, last, time.Now()))
+}
+
+// dueToilets nowの時点で掃除の期限を過ぎたtoiletの掃除を返す
+func dueToilets(toilets []model.Toilet, last []model.LastWash, now time.Time) []model.ToiletDue {
+	// every kind of wash includes scooping.
+	scooped := map[int64]time.Time{}
+	changed := map[int64]time.Time{}
+	for _, l := range last {
+		if l.Last.After(scooped[l.ToiletId]) {
+			scooped[l.ToiletId] = l.Last
+		}
+		if (model.Wash{Kind: l.Kind}).ReplacesSand() && l.Last.After(changed[l.ToiletId]) {
+			changed[l.ToiletId] = l.Last
+		}
+	}
+
+	dues := []model.ToiletDue{}
+	add := func(t model.Toilet, task string, washed map[int64]time.Time, interval time.Duration) {
+		if interval <= 0 {
+			return
+		}
+		d := model.ToiletDue{ToiletId: t.Id, Name: t.Name, Task: task, Due: t.Created.Add(interval)}
+		if l, ok := washed[t.Id]; ok {
+			d.Last = &l
+			d.Due = l.Add(interval)
+		}
+		if !d.Due.After(now) {
+			dues = append(dues, d)
+		}
+	}
+	for _, t := range toilets {
+		add(t, model.CleaningTaskScoop, scooped, time.Duration(t.ScoopHours)*time.Hour)
+		add(t, model.CleaningTaskFullChange, changed, time.Duration(t.ChangeDays)*24*time.Hour)
+	}
+	sort.SliceStable(dues, func(i, j int) bool { return dues[i].Due.Before(dues[j].Due) })
+	return dues
+}
+
+// sandThresholds thが未設定の場合はDefaultSandThresholdsを返す
+func sandThresholds(th model.SandThresholds) model.SandThresholds {
+	if th == (model.SandThresholds{}) {
+		return model.DefaultSandThresholds
+	}
+	return th
+}
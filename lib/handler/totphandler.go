@@ -0,0 +1,254 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/crypt"
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/greytabby/meowapi/lib/ratelimit"
+	"github.com/greytabby/meowapi/lib/totp"
+	"github.com/labstack/echo"
+)
+
+const recoveryCodeCount = 10
+
+// TotpDbAccessor TOTPによる2段階認証に必要なテーブルを操作するinterface
+type TotpDbAccessor interface {
+	GetUser(id int64) (model.User, error)
+	UpdateUser(user model.User) error
+	ReplaceRecoveryCodes(uid int64, codes []model.RecoveryCode) error
+	DeleteRecoveryCodes(uid int64) error
+	UseRecoveryCode(uid int64, code string) error
+	UseTotpStep(uid, step int64) (bool, error)
+}
+
+// TotpHandler TOTPによる2段階認証に関するapihandler
+type TotpHandler struct {
+	Db TotpDbAccessor
+	// Cipher TOTPの共有鍵をDBに保存する際の暗号化に使う
+	Cipher *crypt.Cipher
+	// Issuer 認証アプリに表示されるサービス名
+	Issuer string
+	// Limiter ユーザごとのコード入力の失敗を制限する
+	Limiter *ratelimit.Limiter
+}
+
+type totpRequest struct {
+	MfaToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
+// Enroll TOTPの共有鍵を発行し、otpauth URIを返す
+// Confirmで正しいコードが送られるまで2段階認証は有効にならない
+func (th *TotpHandler) Enroll(c echo.Context) error {
+	uid := UserIdFromToken(c)
+	user, err := th.Db.GetUser(uid)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if user.TotpEnabled {
+		return c.String(http.StatusConflict, "TOTP is already enabled")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.Logger().Error("Generate secret failed.", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	user.TotpSecret, err = th.Cipher.Encrypt(secret)
+	if err != nil {
+		c.Logger().Error("Encrypt secret failed.", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if err := th.Db.UpdateUser(user); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"secret": secret,
+		"uri":    totp.URI(th.Issuer, user.Name, secret),
+	})
+}
+
+// Confirm 認証アプリが生成したコードを検証して2段階認証を有効にし、リカバリーコードを返す
+func (th *TotpHandler) Confirm(c echo.Context) error {
+	var req totpRequest
+	if err := c.Bind(&req); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Invalid field")
+	}
+
+	uid := UserIdFromToken(c)
+	user, err := th.Db.GetUser(uid)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if user.TotpEnabled {
+		return c.String(http.StatusConflict, "TOTP is already enabled")
+	}
+	if user.TotpSecret == "" {
+		return c.String(http.StatusBadRequest, "TOTP enrollment is not started")
+	}
+
+	secret, err := th.Cipher.Decrypt(user.TotpSecret)
+	if err != nil {
+		c.Logger().Error("Decrypt secret failed.", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	step, ok := totp.Match(secret, req.Code, time.Now())
+	if !ok {
+		return c.String(http.StatusBadRequest, "Invalid code")
+	}
+
+	codes, hashed, err := generateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		c.Logger().Error("Generate recovery codes failed.", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if err := th.Db.ReplaceRecoveryCodes(uid, hashed); err != nil {
+		c.Logger().Errorf("Insert: ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	user.TotpEnabled = true
+	// the code used for confirmation can not be used again to log in.
+	user.TotpStep = step
+	if err := th.Db.UpdateUser(user); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, map[string][]string{
+		"recovery_codes": codes,
+	})
+}
+
+// Disable パスワードを確認して2段階認証を無効にする
+func (th *TotpHandler) Disable(c echo.Context) error {
+	var req totpRequest
+	if err := c.Bind(&req); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Invalid field")
+	}
+
+	uid := UserIdFromToken(c)
+	user, err := th.Db.GetUser(uid)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if err := passwordVerify(user.Password, req.Password); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid password")
+	}
+
+	user.TotpEnabled = false
+	user.TotpSecret = ""
+	if err := th.Db.UpdateUser(user); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if err := th.Db.DeleteRecoveryCodes(uid); err != nil {
+		c.Logger().Errorf("Delete: ", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	return c.String(http.StatusOK, "")
+}
+
+// Login Loginで発行された一時トークンとTOTPコード(またはリカバリーコード)を照合しjwttokenを発行する
+// 一度受け付けたコードと、それより前のコードは受け付けない
+func (th *TotpHandler) Login(c echo.Context) error {
+	if th.Cipher == nil {
+		return c.String(http.StatusServiceUnavailable, "TOTP is not configured on this server")
+	}
+	var req totpRequest
+	if err := c.Bind(&req); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Invalid field")
+	}
+
+	uid, err := parseMfaToken(req.MfaToken)
+	if err != nil {
+		return c.String(http.StatusUnauthorized, "Invalid or expired mfa token")
+	}
+	user, err := th.Db.GetUser(uid)
+	if err != nil || !user.TotpEnabled {
+		return c.String(http.StatusUnauthorized, "Invalid or expired mfa token")
+	}
+
+	key := strconv.FormatInt(uid, 10)
+	if wait := checkLimit(c, th.Limiter, key); wait > 0 {
+		return tooManyRequests(c, wait)
+	}
+
+	secret, err := th.Cipher.Decrypt(user.TotpSecret)
+	if err != nil {
+		c.Logger().Error("Decrypt secret failed.", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if step, ok := totp.Match(secret, req.Code, time.Now()); ok {
+		accepted, err := th.Db.UseTotpStep(uid, step)
+		if err != nil {
+			c.Logger().Errorf("Update: ", err)
+			return c.String(http.StatusInternalServerError, "")
+		}
+		if !accepted {
+			recordFailure(c, th.Limiter, key)
+			return c.String(http.StatusBadRequest, "Invalid code")
+		}
+	} else if err := th.Db.UseRecoveryCode(uid, hashRecoveryCode(req.Code)); err != nil {
+		// not a valid TOTP code nor one of the recovery codes
+		recordFailure(c, th.Limiter, key)
+		return c.String(http.StatusBadRequest, "Invalid code")
+	}
+	resetLimit(c, th.Limiter, key)
+
+	if user.Disabled {
+		return c.String(http.StatusForbidden, "Account is disabled")
+	}
+
+	t, err := issueToken(user)
+	if err != nil {
+		c.Logger().Errorf("Login: create token failed", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"token": t,
+	})
+}
+
+// generateRecoveryCodes n個のリカバリーコードと、DBに保存するためのハッシュ化したものを返す
+func generateRecoveryCodes(n int) ([]string, []model.RecoveryCode, error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, n)
+	hashed := make([]model.RecoveryCode, 0, n)
+	for i := 0; i < n; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(enc.EncodeToString(buf))
+		code := s[:4] + "-" + s[4:]
+		codes = append(codes, code)
+		hashed = append(hashed, model.RecoveryCode{Code: hashRecoveryCode(code)})
+	}
+	return codes, hashed, nil
+}
+
+// hashRecoveryCode 入力の揺れを正規化してリカバリーコードのハッシュを返す
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.Replace(code, "-", "", -1)
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/labstack/echo"
+)
+
+// UserProfileDbAccessor ログイン中のユーザの設定を操作するinterface
+type UserProfileDbAccessor interface {
+	GetUser(id int64) (model.User, error)
+	UpdateUser(user model.User) error
+}
+
+// UserHandler /api/userへのリクエストを処理する
+type UserHandler struct {
+	Db UserProfileDbAccessor
+}
+
+// GetProfile ログイン中のユーザの情報を返す
+func (uh *UserHandler) GetProfile(c echo.Context) error {
+	user, err := uh.Db.GetUser(UserIdFromToken(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusNotFound, "User not found")
+	}
+	user.Password = ""
+	return c.JSON(http.StatusOK, user)
+}
+
+// UpdateProfile ログイン中のユーザの設定を更新する
+// 現在はタイムゾーン(IANAのタイムゾーン名)のみ変更できる
+func (uh *UserHandler) UpdateProfile(c echo.Context) error {
+	var req model.User
+	if err := c.Bind(&req); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if !model.ValidTimeZone(req.TimeZone) {
+		return c.String(http.StatusBadRequest, "Invalid time zone.")
+	}
+
+	user, err := uh.Db.GetUser(UserIdFromToken(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusNotFound, "User not found")
+	}
+	user.TimeZone = req.TimeZone
+	if err := uh.Db.UpdateUser(user); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusInternalServerError, "Could not update user.")
+	}
+	return c.String(http.StatusOK, "")
+}
@@ -1,25 +1,30 @@
 package handler
 
 import (
+	"encoding/csv"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/greytabby/meowapi/lib/model"
 	"github.com/labstack/echo"
 )
 
 type UseToiletReader interface {
-	GetAllUseToilets(uid int64) ([]model.UseToilet, error)
-	GetUseToilet(id, uid int64) (model.UseToilet, error)
+	GetUseToilets(hid int64, f model.UseToiletFilter) ([]model.UseToilet, error)
+	GetUseToilet(id, hid int64) (model.UseToilet, error)
 }
 
 type UseToiletManipulator interface {
-	AddUseToilet(ut model.UseToilet) error
-	UpdateUseToilet(ut model.UseToilet) error
-	DeleteUseToilet(ut model.UseToilet) error
+	AddUseToilet(ut model.UseToilet, th model.SandThresholds) (model.UseToilet, *model.SandStateTransition, error)
+	UpdateUseToilet(ut model.UseToilet, th model.SandThresholds) ([]model.SandStateTransition, error)
+	DeleteUseToilet(ut model.UseToilet, th model.SandThresholds) ([]model.SandStateTransition, error)
 }
 
 // UseToiletDbAccessor usetoiletテーブルを操作するinterface
 type UseToiletDbAccessor interface {
+	CatReader
+	ToiletReader
 	UseToiletReader
 	UseToiletManipulator
 }
@@ -27,16 +32,37 @@ type UseToiletDbAccessor interface {
 // UseToiletHandler /api/usetoiletへのリクエストを処理する
 type UseToiletHandler struct {
 	Db UseToiletDbAccessor
+	// Photos nilでなければ一覧に添付画像を含め、削除時に添付画像も削除する
+	Photos *PhotoHandler
+	// Sand toiletの砂の状態が変わる使用回数。未設定の場合はDefaultSandThresholds
+	Sand model.SandThresholds
+	// Hooks nilでなければusetoiletの追加と砂の状態の変化をwebhookで通知する
+	Hooks *WebhookHandler
 }
 
 // GetAllUseToilets UseToiletテーブルから全てのUseToiletを返す
+// 絞り込み条件はparseUseToiletFilterを参照
 func (th *UseToiletHandler) GetAllUseToilets(c echo.Context) error {
-	uid := UserIdFromToken(c)
-	usetoilets, err := th.Db.GetAllUseToilets(uid)
+	f, err := parseUseToiletFilter(c)
+	if err != nil {
+		return err
+	}
+	hid := HouseholdIdFromContext(c)
+	usetoilets, err := th.Db.GetUseToilets(hid, f)
 	if err != nil {
 		c.Logger().Errorf("Select: ", err)
 		return c.String(http.StatusBadRequest, "Select: "+err.Error())
 	}
+	if th.Photos != nil {
+		links, err := th.Photos.useToiletPhotoLinks(hid, usetoilets)
+		if err != nil {
+			c.Logger().Errorf("Select: ", err)
+			return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+		}
+		for i := range usetoilets {
+			usetoilets[i].Photos = links[usetoilets[i].Id]
+		}
+	}
 	return c.JSON(http.StatusOK, usetoilets)
 }
 
@@ -49,12 +75,27 @@ func (th *UseToiletHandler) AddUseToilet(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
 	}
 
+	if err := usetoilet.ValidateObservation(); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	hid := HouseholdIdFromContext(c)
+	if err := th.checkRefs(usetoilet, hid); err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return err
+	}
 	uid := UserIdFromToken(c)
 	usetoilet.UID = uid
-	if err := th.Db.AddUseToilet(usetoilet); err != nil {
+	usetoilet.HouseholdId = hid
+	usetoilet, st, err := th.Db.AddUseToilet(usetoilet, sandThresholds(th.Sand))
+	if err != nil {
 		c.Logger().Errorf("Insert: ", err)
 		return c.String(http.StatusBadRequest, "Could not add new usetoilet.")
 	}
+	if th.Hooks != nil {
+		th.Hooks.emit(c, model.WebhookEventUseToiletCreated, usetoilet)
+		th.Hooks.emitSandState(c, st)
+	}
 	c.Logger().Infof("Added: %#v", usetoilet)
 	return c.String(http.StatusOK, "")
 }
@@ -73,21 +114,38 @@ func (th *UseToiletHandler) UpdateUseToilet(c echo.Context) error {
 	}
 
 	// Get cat from db for confirming wheather the user specified cat exist.
-	uid := UserIdFromToken(c)
-	selectedUseToilet, err := th.Db.GetUseToilet(usetoilet.Id, uid)
+	hid := HouseholdIdFromContext(c)
+	selectedUseToilet, err := th.Db.GetUseToilet(usetoilet.Id, hid)
 	if err != nil {
 		c.Logger().Errorf("Select: ", err)
 		return c.String(http.StatusBadRequest, "No your specified cat in the database.")
 	}
 
+	if err := th.checkRefs(usetoilet, hid); err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return err
+	}
+
 	// Update information.
 	selectedUseToilet.ToiletId = usetoilet.ToiletId
 	selectedUseToilet.CatId = usetoilet.CatId
 	selectedUseToilet.Type = usetoilet.Type
-	if err := th.Db.UpdateUseToilet(selectedUseToilet); err != nil {
+	selectedUseToilet.StoolScore = usetoilet.StoolScore
+	selectedUseToilet.Color = usetoilet.Color
+	selectedUseToilet.Blood = usetoilet.Blood
+	selectedUseToilet.Mucus = usetoilet.Mucus
+	selectedUseToilet.ClumpSize = usetoilet.ClumpSize
+	selectedUseToilet.Straining = usetoilet.Straining
+	selectedUseToilet.DurationSec = usetoilet.DurationSec
+	if err := selectedUseToilet.ValidateObservation(); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	sts, err := th.Db.UpdateUseToilet(selectedUseToilet, sandThresholds(th.Sand))
+	if err != nil {
 		c.Logger().Errorf("Update: ", err)
 		return c.String(http.StatusBadRequest, "Could not update usetoilet info.")
 	}
+	th.emitSandStates(c, sts)
 	c.Logger().Infof("Updated: %#v", selectedUseToilet)
 	return c.String(http.StatusOK, "")
 }
@@ -105,17 +163,158 @@ func (th *UseToiletHandler) DeleteUseToilet(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "UseToilet id is not specified.")
 	}
 
-	uid := UserIdFromToken(c)
-	selectedUseToilet, err := th.Db.GetUseToilet(usetoilet.Id, uid)
+	hid := HouseholdIdFromContext(c)
+	selectedUseToilet, err := th.Db.GetUseToilet(usetoilet.Id, hid)
 	if err != nil {
 		c.Logger().Errorf("Select: ", err)
 		return c.String(http.StatusBadRequest, "No your specified usetoilet.")
 	}
 
-	if err := th.Db.DeleteUseToilet(selectedUseToilet); err != nil {
+	sts, err := th.Db.DeleteUseToilet(selectedUseToilet, sandThresholds(th.Sand))
+	if err != nil {
 		c.Logger().Errorf("Delete: ", err)
 		return c.String(http.StatusBadRequest, "Failed delete the usetoilet.")
 	}
+	th.emitSandStates(c, sts)
+	if th.Photos != nil {
+		th.Photos.removeUseToiletPhotos(c, selectedUseToilet.Id, hid)
+	}
 	c.Logger().Infof("Deleted: %#v", selectedUseToilet)
 	return c.String(http.StatusOK, "")
 }
+
+// emitSandStates 更新や削除で変わった砂の状態をwebhookで通知する
+func (th *UseToiletHandler) emitSandStates(c echo.Context, sts []model.SandStateTransition) {
+	if th.Hooks == nil {
+		return
+	}
+	for i := range sts {
+		th.Hooks.emitSandState(c, &sts[i])
+	}
+}
+
+// checkRefs usetoiletのcatとtoiletがhouseholdのものか確認する
+// 見つからない場合はそのままhandlerから返すerrorを返す
+func (th *UseToiletHandler) checkRefs(ut model.UseToilet, hid int64) error {
+	if _, err := th.Db.GetCat(ut.CatId, hid); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "No your specified cat.")
+	}
+	if _, err := th.Db.GetToilet(ut.ToiletId, hid); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "No your specified toilet.")
+	}
+	return nil
+}
+
+// useToiletCSVHeader ExportUseToiletsのCSVの見出し
+var useToiletCSVHeader = []string{
+	"id", "created", "catid", "toiletid", "type",
+	"stoolscore", "color", "blood", "mucus", "clumpsize", "straining", "durationsec", "photos",
+}
+
+// ExportUseToilets UseToiletを観察記録とともにCSVで返す
+// 絞り込み条件はGetAllUseToiletsと同じ
+func (th *UseToiletHandler) ExportUseToilets(c echo.Context) error {
+	f, err := parseUseToiletFilter(c)
+	if err != nil {
+		return err
+	}
+	hid := HouseholdIdFromContext(c)
+	usetoilets, err := th.Db.GetUseToilets(hid, f)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	var links map[int64][]model.PhotoLinks
+	if th.Photos != nil {
+		if links, err = th.Photos.useToiletPhotoLinks(hid, usetoilets); err != nil {
+			c.Logger().Errorf("Select: ", err)
+			return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+		}
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	res.Header().Set("Content-Disposition", `attachment; filename="usetoilet.csv"`)
+	res.WriteHeader(http.StatusOK)
+	w := csv.NewWriter(res)
+	w.Write(useToiletCSVHeader)
+	for _, ut := range usetoilets {
+		w.Write([]string{
+			strconv.FormatInt(ut.Id, 10),
+			ut.Created.Format(time.RFC3339),
+			strconv.FormatInt(ut.CatId, 10),
+			strconv.FormatInt(ut.ToiletId, 10),
+			ut.Type,
+			csvInt(ut.StoolScore),
+			ut.Color,
+			csvBool(ut.Blood),
+			csvBool(ut.Mucus),
+			ut.ClumpSize,
+			csvBool(ut.Straining),
+			csvInt(ut.DurationSec),
+			strconv.Itoa(len(links[ut.Id])),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// parseUseToiletFilter クエリパラメータからUseToiletFilterを作る
+// cat_id, from, toに加えてtoilet_id, type, stool_score_min, stool_score_max,
+// color, blood, mucus, clump_size, strainingで絞り込める
+func parseUseToiletFilter(c echo.Context) (model.UseToiletFilter, error) {
+	rf, err := parseRecordFilter(c)
+	if err != nil {
+		return model.UseToiletFilter{}, err
+	}
+	f := model.UseToiletFilter{
+		RecordFilter: rf,
+		Type:         c.QueryParam("type"),
+		Color:        c.QueryParam("color"),
+		ClumpSize:    c.QueryParam("clump_size"),
+	}
+	ints := []struct {
+		name string
+		dst  *int
+	}{{"stool_score_min", &f.StoolScoreMin}, {"stool_score_max", &f.StoolScoreMax}}
+	for _, p := range ints {
+		if s := c.QueryParam(p.name); s != "" {
+			if *p.dst, err = strconv.Atoi(s); err != nil {
+				return f, echo.NewHTTPError(http.StatusBadRequest, "Invalid "+p.name+".")
+			}
+		}
+	}
+	if s := c.QueryParam("toilet_id"); s != "" {
+		if f.ToiletId, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return f, echo.NewHTTPError(http.StatusBadRequest, "Invalid toilet_id.")
+		}
+	}
+	bools := []struct {
+		name string
+		dst  **bool
+	}{{"blood", &f.Blood}, {"mucus", &f.Mucus}, {"straining", &f.Straining}}
+	for _, p := range bools {
+		if s := c.QueryParam(p.name); s != "" {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return f, echo.NewHTTPError(http.StatusBadRequest, "Invalid "+p.name+".")
+			}
+			*p.dst = &b
+		}
+	}
+	return f, nil
+}
+
+func csvInt(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
+
+func csvBool(v *bool) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatBool(*v)
+}
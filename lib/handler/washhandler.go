@@ -10,20 +10,22 @@ import (
 
 // WashReader washテーブルを参照する
 type WashReader interface {
-	GetAllWashes(uid int64) ([]model.Wash, error)
-	GetWashesByToiletId(toiletid, uid int64) ([]model.Wash, error)
-	GetWash(id, uid int64) (model.Wash, error)
+	GetAllWashes(hid int64) ([]model.Wash, error)
+	GetWashesByToiletId(toiletid, hid int64) ([]model.Wash, error)
+	GetWash(id, hid int64) (model.Wash, error)
 }
 
 // WashManipulater washテーブルを操作する
 type WashManipulator interface {
-	AddWash(wash model.Wash) error
-	UpdateWash(wash model.Wash) error
-	DeleteWash(wash model.Wash) error
+	AddWash(wash model.Wash, th model.SandThresholds) (model.Wash, *model.SandStateTransition, error)
+	UpdateWash(wash model.Wash, th model.SandThresholds) ([]model.SandStateTransition, error)
+	DeleteWash(wash model.Wash, th model.SandThresholds) ([]model.SandStateTransition, error)
 }
 
 // WashDbAccessor washテーブルの参照/操作を行う
 type WashDbAccessor interface {
+	ToiletReader
+	LitterReader
 	WashReader
 	WashManipulator
 }
@@ -31,12 +33,16 @@ type WashDbAccessor interface {
 // WashHandler /api/washへのリクエストを処理する
 type WashHandler struct {
 	Db WashDbAccessor
+	// Sand toiletの砂の状態が変わる使用回数。未設定の場合はDefaultSandThresholds
+	Sand model.SandThresholds
+	// Hooks nilでなければwashの追加と砂の状態の変化をwebhookで通知する
+	Hooks *WebhookHandler
 }
 
 // GetAllWashed 全てのwashを取得する
 func (wh *WashHandler) GetAllWashes(c echo.Context) error {
-	uid := UserIdFromToken(c)
-	washes, err := wh.Db.GetAllWashes(uid)
+	hid := HouseholdIdFromContext(c)
+	washes, err := wh.Db.GetAllWashes(hid)
 	if err != nil {
 		c.Logger().Errorf("Select: ", err)
 		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
@@ -51,8 +57,8 @@ func (wh *WashHandler) GetWashesByToiletId(c echo.Context) error {
 		c.Logger().Errorf("Param parse: ", err)
 		return c.String(http.StatusBadRequest, "Param parse: "+err.Error())
 	}
-	uid := UserIdFromToken(c)
-	washes, err := wh.Db.GetWashesByToiletId(toiletid, uid)
+	hid := HouseholdIdFromContext(c)
+	washes, err := wh.Db.GetWashesByToiletId(toiletid, hid)
 	if err != nil {
 		c.Logger().Errorf("Select: ", err)
 		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
@@ -67,19 +73,58 @@ func (wh *WashHandler) AddWash(c echo.Context) error {
 		c.Logger().Errorf("Bind: ", err)
 		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
 	}
+	if w.Kind == "" {
+		w.Kind = model.WashKindScoop
+	}
+	if !model.ValidWashKind(w.Kind) {
+		return c.String(http.StatusBadRequest, "Invalid kind.")
+	}
+	if w.LitterGrams < 0 {
+		return c.String(http.StatusBadRequest, "Invalid littergrams.")
+	}
+	hid := HouseholdIdFromContext(c)
+	if _, err := wh.Db.GetToilet(w.ToiletId, hid); err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusNotFound, "No your specified toilet.")
+	}
+	if w.LitterId != 0 {
+		if _, err := wh.Db.GetLitter(w.LitterId, hid); err != nil {
+			c.Logger().Errorf("Select: ", err)
+			return c.String(http.StatusNotFound, "No your specified litter.")
+		}
+	}
 	uid := UserIdFromToken(c)
 	w.UID = uid
-	if err := wh.Db.AddWash(w); err != nil {
+	w.HouseholdId = hid
+	w, st, err := wh.Db.AddWash(w, sandThresholds(wh.Sand))
+	if err != nil {
 		c.Logger().Error("Insert: ", err)
 		return c.String(http.StatusInternalServerError, "Could not add wash.")
 	}
+	if wh.Hooks != nil {
+		wh.Hooks.emit(c, model.WebhookEventWashCreated, w)
+		wh.Hooks.emitSandState(c, st)
+	}
 	c.Logger().Infof("Added: %#v", w)
 	return c.JSON(http.StatusOK, "")
 }
 
+// washUpdateRequest washの更新内容
+// littergramsは指定しない場合(null)に今の値のままとし、0と区別する
+type washUpdateRequest struct {
+	Id          int64  `json:"id"`
+	ToiletId    int64  `json:"toiletid"`
+	Kind        string `json:"kind"`
+	LitterGrams *int64 `json:"littergrams"`
+	LitterId    int64  `json:"litterid"`
+	Comment     string `json:"comment"`
+}
+
 // UpdateWash washを1件更新する
+// 砂の在庫は更新前に減らした量を戻してから、更新後に入れた量を減らす
+// kind, littergrams, litteridを指定しない場合は今の値のままとする
 func (wh *WashHandler) UpdateWash(c echo.Context) error {
-	var w, selected model.Wash
+	var w washUpdateRequest
 	if err := c.Bind(&w); err != nil {
 		c.Logger().Errorf("Bind: ", err)
 		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
@@ -89,23 +134,55 @@ func (wh *WashHandler) UpdateWash(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "Wash id was not specifyed.")
 	}
 
-	uid := UserIdFromToken(c)
-	selected, err := wh.Db.GetWash(w.Id, uid)
+	hid := HouseholdIdFromContext(c)
+	selected, err := wh.Db.GetWash(w.Id, hid)
 	if err != nil {
 		c.Logger().Errorf("Select: ", err)
 		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
 	}
 
+	if w.Kind == "" {
+		w.Kind = selected.Kind
+	}
+	if !model.ValidWashKind(w.Kind) {
+		return c.String(http.StatusBadRequest, "Invalid kind.")
+	}
+	if w.LitterGrams != nil && *w.LitterGrams < 0 {
+		return c.String(http.StatusBadRequest, "Invalid littergrams.")
+	}
+
+	if w.ToiletId != selected.ToiletId {
+		if _, err := wh.Db.GetToilet(w.ToiletId, hid); err != nil {
+			c.Logger().Errorf("Select: ", err)
+			return c.String(http.StatusNotFound, "No your specified toilet.")
+		}
+	}
+	if w.LitterId == 0 {
+		w.LitterId = selected.LitterId
+	} else if w.LitterId != selected.LitterId {
+		if _, err := wh.Db.GetLitter(w.LitterId, hid); err != nil {
+			c.Logger().Errorf("Select: ", err)
+			return c.String(http.StatusNotFound, "No your specified litter.")
+		}
+	}
 	selected.ToiletId = w.ToiletId
+	selected.Kind = w.Kind
+	if w.LitterGrams != nil {
+		selected.LitterGrams = *w.LitterGrams
+	}
+	selected.LitterId = w.LitterId
 	selected.Comment = w.Comment
-	if err := wh.Db.UpdateWash(selected); err != nil {
+	sts, err := wh.Db.UpdateWash(selected, sandThresholds(wh.Sand))
+	if err != nil {
 		c.Logger().Error("Update: ", err)
 		return c.String(http.StatusInternalServerError, "Could not update wash.")
 	}
-	return c.JSON(http.StatusOK, w)
+	wh.emitSandStates(c, sts)
+	return c.JSON(http.StatusOK, selected)
 }
 
 // DeleteWash washを1件削除する
+// 砂を入れた掃除の場合は砂の在庫から減らした量を在庫に戻す
 func (wh *WashHandler) DeleteWash(c echo.Context) error {
 	var w, selected model.Wash
 	if err := c.Bind(&w); err != nil {
@@ -117,16 +194,28 @@ func (wh *WashHandler) DeleteWash(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "Wash id was not specifyed.")
 	}
 
-	uid := UserIdFromToken(c)
-	selected, err := wh.Db.GetWash(w.Id, uid)
+	hid := HouseholdIdFromContext(c)
+	selected, err := wh.Db.GetWash(w.Id, hid)
 	if err != nil {
 		c.Logger().Errorf("Select: ", err)
 		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
 	}
 
-	if err := wh.Db.DeleteWash(selected); err != nil {
+	sts, err := wh.Db.DeleteWash(selected, sandThresholds(wh.Sand))
+	if err != nil {
 		c.Logger().Error("Delete: ", err)
 		return c.String(http.StatusInternalServerError, "Could not delete wash.")
 	}
+	wh.emitSandStates(c, sts)
 	return c.JSON(http.StatusOK, w)
 }
+
+// emitSandStates 更新や削除で変わった砂の状態をwebhookで通知する
+func (wh *WashHandler) emitSandStates(c echo.Context, sts []model.SandStateTransition) {
+	if wh.Hooks == nil {
+		return
+	}
+	for i := range sts {
+		wh.Hooks.emitSandState(c, &sts[i])
+	}
+}
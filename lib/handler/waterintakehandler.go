@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/labstack/echo"
+)
+
+type WaterIntakeReader interface {
+	GetWaterIntakes(hid int64, f model.RecordFilter) ([]model.WaterIntake, error)
+	GetWaterIntake(id, hid int64) (model.WaterIntake, error)
+}
+
+type WaterIntakeManipulator interface {
+	AddWaterIntake(w model.WaterIntake) error
+	UpdateWaterIntake(w model.WaterIntake) error
+	DeleteWaterIntake(w model.WaterIntake) error
+}
+
+// WaterIntakeDbAccessor waterintakeテーブルを操作するinterface
+type WaterIntakeDbAccessor interface {
+	CatReader
+	WaterIntakeReader
+	WaterIntakeManipulator
+}
+
+// WaterIntakeHandler /api/waterへのリクエストを処理する
+type WaterIntakeHandler struct {
+	Db WaterIntakeDbAccessor
+}
+
+// GetWaterIntakes 飲水の記録を返す
+// cat_id, from, toで絞り込める
+func (wh *WaterIntakeHandler) GetWaterIntakes(c echo.Context) error {
+	f, err := parseRecordFilter(c)
+	if err != nil {
+		return err
+	}
+	ws, err := wh.Db.GetWaterIntakes(HouseholdIdFromContext(c), f)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, ws)
+}
+
+// AddWaterIntake 飲水の記録を1件追加する
+// occurredatを省略した場合は現在日時とする
+func (wh *WaterIntakeHandler) AddWaterIntake(c echo.Context) error {
+	var water model.WaterIntake
+
+	if err := c.Bind(&water); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	hid := HouseholdIdFromContext(c)
+	if _, err := wh.Db.GetCat(water.CatId, hid); err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "No your specified cat.")
+	}
+	if water.Milliliters <= 0 {
+		return c.String(http.StatusBadRequest, "Ml must be positive.")
+	}
+	if water.OccurredAt.IsZero() {
+		water.OccurredAt = time.Now()
+	}
+
+	water.UID = UserIdFromToken(c)
+	water.HouseholdId = hid
+	if err := wh.Db.AddWaterIntake(water); err != nil {
+		c.Logger().Errorf("Insert: ", err)
+		return c.String(http.StatusBadRequest, "Could not add new water intake.")
+	}
+	c.Logger().Infof("Added: %#v", water)
+	return c.String(http.StatusOK, "")
+}
+
+// UpdateWaterIntake 飲水の記録を1件更新する
+func (wh *WaterIntakeHandler) UpdateWaterIntake(c echo.Context) error {
+	var water model.WaterIntake
+
+	if err := c.Bind(&water); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if water.Id == 0 {
+		return c.String(http.StatusBadRequest, "Water intake id not specified.")
+	}
+
+	hid := HouseholdIdFromContext(c)
+	selected, err := wh.Db.GetWaterIntake(water.Id, hid)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "No your specified water intake.")
+	}
+	if water.CatId != 0 && water.CatId != selected.CatId {
+		if _, err := wh.Db.GetCat(water.CatId, hid); err != nil {
+			c.Logger().Errorf("Select: ", err)
+			return c.String(http.StatusBadRequest, "No your specified cat.")
+		}
+		selected.CatId = water.CatId
+	}
+	if water.Milliliters < 0 {
+		return c.String(http.StatusBadRequest, "Ml must be positive.")
+	}
+	if water.Milliliters > 0 {
+		selected.Milliliters = water.Milliliters
+	}
+	if !water.OccurredAt.IsZero() {
+		selected.OccurredAt = water.OccurredAt
+	}
+	if err := wh.Db.UpdateWaterIntake(selected); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusBadRequest, "Could not update water intake.")
+	}
+	c.Logger().Infof("Updated: %#v", selected)
+	return c.String(http.StatusOK, "")
+}
+
+// DeleteWaterIntake 飲水の記録を1件削除する
+func (wh *WaterIntakeHandler) DeleteWaterIntake(c echo.Context) error {
+	var water model.WaterIntake
+
+	if err := c.Bind(&water); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if water.Id == 0 {
+		return c.String(http.StatusBadRequest, "Water intake id is not specified.")
+	}
+
+	selected, err := wh.Db.GetWaterIntake(water.Id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusBadRequest, "No your specified water intake.")
+	}
+	if err := wh.Db.DeleteWaterIntake(selected); err != nil {
+		c.Logger().Errorf("Delete: ", err)
+		return c.String(http.StatusBadRequest, "Failed delete the water intake.")
+	}
+	c.Logger().Infof("Deleted: %#v", selected)
+	return c.String(http.StatusOK, "")
+}
@@ -0,0 +1,301 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/greytabby/meowapi/lib/webhook"
+	"github.com/labstack/echo"
+)
+
+const (
+	defaultWebhookDeliveryLimit = 50
+	maxWebhookDeliveryLimit     = 500
+	// webhookSecretPrefix 生成したsecretの接頭辞
+	webhookSecretPrefix = "whsec_"
+)
+
+// WebhookDbAccessor webhookと送信の記録を操作するinterface
+type WebhookDbAccessor interface {
+	GetWebhooks(hid int64) ([]model.Webhook, error)
+	GetWebhook(id, hid int64) (model.Webhook, error)
+	AddWebhook(w model.Webhook) (model.Webhook, error)
+	UpdateWebhook(w model.Webhook) error
+	DeleteWebhook(w model.Webhook) error
+	AddWebhookDelivery(d model.WebhookDelivery) (model.WebhookDelivery, error)
+	GetWebhookDeliveries(webhookid, hid int64, limit int) ([]model.WebhookDelivery, error)
+}
+
+// WebhookHandler /api/webhookへのリクエストを処理し、householdのイベントをwebhookへ送る
+type WebhookHandler struct {
+	Db WebhookDbAccessor
+	// Sender nilでなければイベントを記録した直後に1回目の送信を行う
+	// nilの場合は次の再送の処理で送信する
+	Sender *webhook.Sender
+	// sending 送信中の1回目の送信
+	sending sync.WaitGroup
+}
+
+// Wait 送信中の1回目の送信が終わるまで待つ
+// サーバを止めてリクエストの処理が全て終わった後に呼ぶ
+func (wh *WebhookHandler) Wait() {
+	wh.sending.Wait()
+}
+
+// GetWebhooks householdのwebhookをsecretを除いて返す
+func (wh *WebhookHandler) GetWebhooks(c echo.Context) error {
+	ws, err := wh.Db.GetWebhooks(HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	for i := range ws {
+		ws[i].Secret = ""
+	}
+	return c.JSON(http.StatusOK, ws)
+}
+
+// AddWebhook householdにwebhookを1件追加する
+// secretが指定されない場合は生成する。secretはこのレスポンスでのみ返す
+func (wh *WebhookHandler) AddWebhook(c echo.Context) error {
+	var w model.Webhook
+	if err := c.Bind(&w); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if err := validateWebhook(&w); err != nil {
+		return err
+	}
+	if w.Secret == "" {
+		secret, err := randomHex(24)
+		if err != nil {
+			c.Logger().Errorf("Secret: ", err)
+			return c.String(http.StatusInternalServerError, "Could not generate secret.")
+		}
+		w.Secret = webhookSecretPrefix + secret
+	}
+	w.Id = 0
+	w.UID = UserIdFromToken(c)
+	w.HouseholdId = HouseholdIdFromContext(c)
+	w, err := wh.Db.AddWebhook(w)
+	if err != nil {
+		c.Logger().Errorf("Insert: ", err)
+		return c.String(http.StatusInternalServerError, "Could not add webhook.")
+	}
+	return c.JSON(http.StatusOK, w)
+}
+
+// UpdateWebhook householdのwebhookを1件更新する
+// secretは指定された場合のみ変更する
+func (wh *WebhookHandler) UpdateWebhook(c echo.Context) error {
+	var w model.Webhook
+	if err := c.Bind(&w); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if w.Id == 0 {
+		return c.String(http.StatusBadRequest, "Webhook id not specified.")
+	}
+	if err := validateWebhook(&w); err != nil {
+		return err
+	}
+	selected, err := wh.Db.GetWebhook(w.Id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusNotFound, "No your specified webhook.")
+	}
+
+	selected.URL = w.URL
+	selected.Events = w.Events
+	selected.Enabled = w.Enabled
+	if w.Secret != "" {
+		selected.Secret = w.Secret
+	}
+	if err := wh.Db.UpdateWebhook(selected); err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusInternalServerError, "Could not update webhook.")
+	}
+	return c.String(http.StatusOK, "")
+}
+
+// DeleteWebhook householdのwebhookを送信の記録とともに1件削除する
+func (wh *WebhookHandler) DeleteWebhook(c echo.Context) error {
+	var w model.Webhook
+	if err := c.Bind(&w); err != nil {
+		c.Logger().Errorf("Bind: ", err)
+		return c.String(http.StatusBadRequest, "Bind: "+err.Error())
+	}
+	if w.Id == 0 {
+		return c.String(http.StatusBadRequest, "Webhook id not specified.")
+	}
+	selected, err := wh.Db.GetWebhook(w.Id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusNotFound, "No your specified webhook.")
+	}
+	if err := wh.Db.DeleteWebhook(selected); err != nil {
+		c.Logger().Errorf("Delete: ", err)
+		return c.String(http.StatusInternalServerError, "Could not delete webhook.")
+	}
+	return c.String(http.StatusOK, "")
+}
+
+// GetDeliveries webhookへの送信の記録を新しい順に返す
+func (wh *WebhookHandler) GetDeliveries(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid webhook id.")
+	}
+	limit, err := queryInt(c, "limit", defaultWebhookDeliveryLimit)
+	if err != nil || limit <= 0 || limit > maxWebhookDeliveryLimit {
+		return c.String(http.StatusBadRequest, "Invalid limit.")
+	}
+	hid := HouseholdIdFromContext(c)
+	if _, err := wh.Db.GetWebhook(id, hid); err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusNotFound, "No your specified webhook.")
+	}
+	ds, err := wh.Db.GetWebhookDeliveries(id, hid, limit)
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusInternalServerError, "Select: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, ds)
+}
+
+// SendTest webhookへ確認用のイベントを送り、送信の記録を返す
+// webhookが無効な場合や購読していない場合も送る
+func (wh *WebhookHandler) SendTest(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid webhook id.")
+	}
+	w, err := wh.Db.GetWebhook(id, HouseholdIdFromContext(c))
+	if err != nil {
+		c.Logger().Errorf("Select: ", err)
+		return c.String(http.StatusNotFound, "No your specified webhook.")
+	}
+	now := time.Now()
+	ev, err := newWebhookEvent(w.HouseholdId, model.WebhookEventTest, map[string]int64{"webhookid": w.Id}, now)
+	if err != nil {
+		c.Logger().Errorf("Webhook: ", err)
+		return c.String(http.StatusInternalServerError, "Could not create test event.")
+	}
+	d, err := wh.enqueue(w, ev)
+	if err != nil {
+		c.Logger().Errorf("Insert: ", err)
+		return c.String(http.StatusInternalServerError, "Could not queue test event.")
+	}
+	if wh.Sender == nil {
+		return c.JSON(http.StatusAccepted, d)
+	}
+	d, err = wh.Sender.Deliver(d, now)
+	if err != nil {
+		c.Logger().Errorf("Update: ", err)
+		return c.String(http.StatusInternalServerError, "Could not record test delivery.")
+	}
+	return c.JSON(http.StatusOK, d)
+}
+
+// emit householdのwebhookのうちeventを購読している有効なものへイベントを送る
+// 失敗してもリクエストは失敗させず、ログに残す
+func (wh *WebhookHandler) emit(c echo.Context, event string, data interface{}) {
+	hid := HouseholdIdFromContext(c)
+	ws, err := wh.Db.GetWebhooks(hid)
+	if err != nil {
+		c.Logger().Errorf("Webhook: ", err)
+		return
+	}
+	now := time.Now()
+	var ev model.WebhookEvent
+	for _, w := range ws {
+		if !w.Enabled || !w.Wants(event) {
+			continue
+		}
+		if ev.Id == "" {
+			if ev, err = newWebhookEvent(hid, event, data, now); err != nil {
+				c.Logger().Errorf("Webhook: ", err)
+				return
+			}
+		}
+		d, err := wh.enqueue(w, ev)
+		if err != nil {
+			c.Logger().Errorf("Webhook: ", err)
+			continue
+		}
+		if wh.Sender != nil {
+			// the echo context is reused after the request, keep only the logger.
+			logger := c.Logger()
+			wh.sending.Add(1)
+			go func() {
+				defer wh.sending.Done()
+				if _, err := wh.Sender.Deliver(d, time.Now()); err != nil {
+					logger.Errorf("Webhook: ", err)
+				}
+			}()
+		}
+	}
+}
+
+// emitSandState toiletの砂の状態が変わった場合にイベントを送る
+func (wh *WebhookHandler) emitSandState(c echo.Context, st *model.SandStateTransition) {
+	if st == nil {
+		return
+	}
+	wh.emit(c, model.WebhookEventToiletStateChanged, st)
+}
+
+// enqueue wへ送るevを送信待ちとして記録する
+func (wh *WebhookHandler) enqueue(w model.Webhook, ev model.WebhookEvent) (model.WebhookDelivery, error) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return model.WebhookDelivery{}, err
+	}
+	return wh.Db.AddWebhookDelivery(model.WebhookDelivery{
+		WebhookId:   w.Id,
+		HouseholdId: w.HouseholdId,
+		EventId:     ev.Id,
+		Event:       ev.Type,
+		Payload:     string(payload),
+		Status:      model.NotificationStatusPending,
+		NextAttempt: ev.Created,
+	})
+}
+
+func newWebhookEvent(hid int64, event string, data interface{}, now time.Time) (model.WebhookEvent, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return model.WebhookEvent{}, err
+	}
+	return model.WebhookEvent{
+		Id:          "evt_" + id,
+		Type:        event,
+		HouseholdId: hid,
+		Created:     now,
+		Data:        data,
+	}, nil
+}
+
+// validateWebhook webhookを検証し、イベントの種類の一覧を正規化する
+// 不正な場合はそのままhandlerから返すerrorを返す
+func validateWebhook(w *model.Webhook) error {
+	if err := webhook.ValidateURL(w.URL); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid url.")
+	}
+	events := w.EventList()
+	for _, e := range events {
+		if !model.ValidWebhookEvent(e) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid event "+strconv.Quote(e)+".")
+		}
+	}
+	w.Events = strings.Join(events, ",")
+	if len(w.Secret) > 200 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Secret is too long.")
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package health
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// Config 異常を判定する閾値
+type Config struct {
+	// BaselineDays 基準値を求める過去の日数(今日を含まない)
+	BaselineDays int
+	// MinBaselineDays 基準値として使うのに必要な記録のある日数
+	MinBaselineDays int
+	// HighFactor 今日の回数が基準値のこの倍以上なら多すぎる
+	HighFactor float64
+	// MinExcess 多すぎると判定するために基準値から最低限増えている回数
+	MinExcess int
+	// LowFactor 経過時間から見込まれる回数のこの倍以下なら少なすぎる
+	LowFactor float64
+	// NoPeeHours この時間おしっこが無い場合に警告する
+	NoPeeHours int
+	// NoPoopHours この時間うんちが無い場合に警告する
+	NoPoopHours int
+}
+
+// DefaultConfig 既定の閾値
+var DefaultConfig = Config{
+	BaselineDays:    14,
+	MinBaselineDays: 3,
+	HighFactor:      2.0,
+	MinExcess:       3,
+	LowFactor:       0.3,
+	NoPeeHours:      24,
+	NoPoopHours:     48,
+}
+
+// Analyze 世帯の全てのcatのトイレの記録から異常を検出する
+// usetoiletsはnowから(BaselineDays+1)日前以降の記録、lastは種類ごとの最後の記録
+// 日の区切りはlocのタイムゾーンで判定する
+func (cfg Config) Analyze(cats []model.Cat, usetoilets []model.UseToilet, last []model.LastUseToilet, now time.Time, loc *time.Location) []model.HealthAlert {
+	now = now.In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	start := today.AddDate(0, 0, -cfg.BaselineDays)
+	elapsed := now.Sub(today).Hours() / 24
+
+	alerts := []model.HealthAlert{}
+	for _, cat := range cats {
+		s := newCatStats(cat.Id, usetoilets, start, today, loc)
+		alerts = append(alerts, cfg.frequencyAlerts(s, elapsed, now)...)
+		alerts = append(alerts, cfg.absenceAlerts(cat.Id, last, now)...)
+	}
+	sort.SliceStable(alerts, func(i, j int) bool {
+		return severityRank[alerts[i].Severity] > severityRank[alerts[j].Severity]
+	})
+	return alerts
+}
+
+var severityRank = map[string]int{
+	model.AlertSeverityCritical: 2,
+	model.AlertSeverityWarning:  1,
+}
+
+// catStats catの日ごとの回数
+type catStats struct {
+	catid int64
+	// past 基準期間の日ごとの回数
+	past map[string]map[string]int
+	// firstDay 基準期間中に記録のある最初の日
+	firstDay *time.Time
+	today    map[string]int
+	// smallClumps 今日の小さな尿の塊の数
+	smallClumps int
+	days        int
+}
+
+func newCatStats(catid int64, usetoilets []model.UseToilet, start, today time.Time, loc *time.Location) catStats {
+	s := catStats{catid: catid, past: map[string]map[string]int{}, today: map[string]int{}}
+	for _, ut := range usetoilets {
+		if ut.CatId != catid {
+			continue
+		}
+		t := ut.Created.In(loc)
+		if t.Before(start) {
+			continue
+		}
+		if !t.Before(today) {
+			s.today[ut.Type]++
+			if ut.Type == model.UseToiletTypePee && ut.ClumpSize == model.ClumpSizeSmall {
+				s.smallClumps++
+			}
+			continue
+		}
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		if s.firstDay == nil || day.Before(*s.firstDay) {
+			s.firstDay = &day
+		}
+		key := day.Format("2006-01-02")
+		if s.past[key] == nil {
+			s.past[key] = map[string]int{}
+		}
+		s.past[key][ut.Type]++
+	}
+	if s.firstDay != nil {
+		// days without any record after tracking started count as zero.
+		s.days = int(today.Sub(*s.firstDay).Hours()/24 + 0.5)
+	}
+	return s
+}
+
+// baseline 基準期間の1日あたりの平均回数
+func (s catStats) baseline(typ string) float64 {
+	if s.days == 0 {
+		return 0
+	}
+	total := 0
+	for _, counts := range s.past {
+		total += counts[typ]
+	}
+	return float64(total) / float64(s.days)
+}
+
+func (cfg Config) frequencyAlerts(s catStats, elapsed float64, now time.Time) []model.HealthAlert {
+	if s.days < cfg.MinBaselineDays {
+		return nil
+	}
+	var alerts []model.HealthAlert
+	for _, typ := range []string{model.UseToiletTypePee, model.UseToiletTypePoop} {
+		base := s.baseline(typ)
+		today := s.today[typ]
+		alert := model.HealthAlert{CatId: s.catid, Type: typ, Today: today, Baseline: base, Detected: now}
+
+		high := base * cfg.HighFactor
+		if min := base + float64(cfg.MinExcess); min > high {
+			high = min
+		}
+		if float64(today) >= high {
+			alert.Kind = model.AlertKindFrequent
+			alert.Severity = model.AlertSeverityWarning
+			alert.Message = fmt.Sprintf("%d %s visits today, usually %.1f a day", today, typ, base)
+			// many small pee clumps is a typical sign of a urinary blockage.
+			if typ == model.UseToiletTypePee && s.smallClumps*2 > today {
+				alert.Kind = model.AlertKindBlockage
+				alert.Severity = model.AlertSeverityCritical
+				alert.Message += ", mostly small clumps"
+			}
+			alerts = append(alerts, alert)
+			continue
+		}
+
+		// the count of the day is reliable only after half of the day,
+		// and a missing visit of a rare type is left to absenceAlerts.
+		expected := base * elapsed
+		if elapsed >= 0.5 && expected >= 2 && float64(today) <= expected*cfg.LowFactor {
+			alert.Kind = model.AlertKindLow
+			alert.Severity = model.AlertSeverityWarning
+			alert.Message = fmt.Sprintf("only %d %s visits today, %.1f expected by now", today, typ, expected)
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+func (cfg Config) absenceAlerts(catid int64, last []model.LastUseToilet, now time.Time) []model.HealthAlert {
+	var alerts []model.HealthAlert
+	for _, l := range last {
+		if l.CatId != catid {
+			continue
+		}
+		var hours int
+		severity := model.AlertSeverityWarning
+		switch l.Type {
+		case model.UseToiletTypePee:
+			hours = cfg.NoPeeHours
+			severity = model.AlertSeverityCritical
+		case model.UseToiletTypePoop:
+			hours = cfg.NoPoopHours
+		default:
+			continue
+		}
+		if hours <= 0 || now.Sub(l.Last) < time.Duration(hours)*time.Hour {
+			continue
+		}
+		since := l.Last
+		alerts = append(alerts, model.HealthAlert{
+			CatId:    catid,
+			Kind:     model.AlertKindAbsent,
+			Type:     l.Type,
+			Severity: severity,
+			Message:  fmt.Sprintf("no %s for %d hours", l.Type, int(now.Sub(l.Last).Hours())),
+			Since:    &since,
+			Detected: now,
+		})
+	}
+	return alerts
+}
@@ -0,0 +1,158 @@
+package job
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/greytabby/meowapi/lib/schedule"
+)
+
+const (
+	// DefaultTimeout Job.Timeoutが未設定の場合の実行時間の上限
+	DefaultTimeout = 10 * time.Minute
+	// DefaultTick 実行時刻になった処理を確認する間隔
+	DefaultTick = 10 * time.Second
+	// maxErrorLength 記録するエラーメッセージの長さの上限
+	maxErrorLength = 400
+)
+
+// Store 定期処理の実行状況を保存し、複数のサーバの間で実行権を調停する
+type Store interface {
+	RegisterJob(name, schedule string, nextRun time.Time) error
+	AcquireJob(name, owner string, now, until, nextRun time.Time) (bool, error)
+	FinishJob(name, owner string, started time.Time, duration time.Duration, status, lastError string) error
+}
+
+// Job 定期的に実行する処理
+type Job struct {
+	Name     string
+	Schedule *schedule.Cron
+	// Run ctxはTimeoutを過ぎるか、Schedulerが止まるとキャンセルされる
+	Run func(ctx context.Context) error
+	// Timeout 0の場合はDefaultTimeout。実行権はこの時間だけ保持する
+	Timeout time.Duration
+}
+
+// Scheduler 登録された処理を予定に従って実行する
+// 実行時刻ごとに、同じStoreを使う全てのサーバのうち1台だけが処理を実行する
+type Scheduler struct {
+	Store Store
+	// Owner 実行権の持ち主としてStoreに記録する名前。空の場合はホスト名などから作る
+	Owner string
+	// Tick 0の場合はDefaultTick
+	Tick time.Duration
+
+	jobs []Job
+}
+
+// Add 処理を登録する。Runの前に呼び出す
+func (s *Scheduler) Add(j Job) {
+	s.jobs = append(s.jobs, j)
+}
+
+// Run ctxがキャンセルされるまで処理を実行し続ける
+// キャンセルされると実行中の処理にキャンセルを伝え、全て終わるのを待って戻る
+func (s *Scheduler) Run(ctx context.Context) error {
+	if s.Owner == "" {
+		s.Owner = defaultOwner()
+	}
+	tick := s.Tick
+	if tick <= 0 {
+		tick = DefaultTick
+	}
+	now := time.Now()
+	for _, j := range s.jobs {
+		if err := s.Store.RegisterJob(j.Name, j.Schedule.Spec, j.Schedule.Next(now)); err != nil {
+			return fmt.Errorf("register job %s: %v", j.Name, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	running := map[string]bool{}
+	var mu sync.Mutex
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now = <-ticker.C:
+		}
+		for _, j := range s.jobs {
+			mu.Lock()
+			busy := running[j.Name]
+			mu.Unlock()
+			if busy {
+				continue
+			}
+			timeout := j.Timeout
+			if timeout <= 0 {
+				timeout = DefaultTimeout
+			}
+			ok, err := s.Store.AcquireJob(j.Name, s.Owner, now, now.Add(timeout), j.Schedule.Next(now))
+			if err != nil {
+				log.Printf("job %s: acquire: %v", j.Name, err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			mu.Lock()
+			running[j.Name] = true
+			mu.Unlock()
+			wg.Add(1)
+			go func(j Job, started time.Time) {
+				defer wg.Done()
+				s.run(ctx, j, started, timeout)
+				mu.Lock()
+				delete(running, j.Name)
+				mu.Unlock()
+			}(j, now)
+		}
+	}
+}
+
+// run 処理を1回実行して結果を記録する
+func (s *Scheduler) run(ctx context.Context, j Job, started time.Time, timeout time.Duration) {
+	jctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := safeRun(jctx, j)
+
+	status, text := model.JobStatusSuccess, ""
+	if err != nil {
+		status, text = model.JobStatusFailed, err.Error()
+		if len(text) > maxErrorLength {
+			text = text[:maxErrorLength]
+		}
+		log.Printf("job %s: %v", j.Name, err)
+	}
+	// record the result even when the server is shutting down.
+	if err := s.Store.FinishJob(j.Name, s.Owner, started, time.Since(started), status, text); err != nil {
+		log.Printf("job %s: finish: %v", j.Name, err)
+	}
+}
+
+// safeRun 処理のpanicをエラーにする
+func safeRun(ctx context.Context, j Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return j.Run(ctx)
+}
+
+func defaultOwner() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(b))
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"time"
+)
+
+// Alert kinds
+const (
+	// AlertKindFrequent 今日の回数が基準値より多い
+	AlertKindFrequent = "frequent"
+	// AlertKindLow 今日の回数が基準値より少ない
+	AlertKindLow = "low"
+	// AlertKindAbsent 一定時間記録が無い
+	AlertKindAbsent = "absent"
+	// AlertKindBlockage 小さな尿の塊が頻繁に出ている(尿路閉塞の疑い)
+	AlertKindBlockage = "blockage"
+)
+
+// Alert severities
+const (
+	AlertSeverityWarning  = "warning"
+	AlertSeverityCritical = "critical"
+)
+
+// HealthAlert トイレの記録から検出した異常
+type HealthAlert struct {
+	CatId    int64      `json:"catid"`
+	Kind     string     `json:"kind"`
+	Type     string     `json:"type"`
+	Severity string     `json:"severity"`
+	Message  string     `json:"message"`
+	Today    int        `json:"today"`
+	Baseline float64    `json:"baseline"`
+	Since    *time.Time `json:"since,omitempty"`
+	Detected time.Time  `json:"detected"`
+}
+
+// LastUseToilet catの種類ごとの最後のトイレの記録日時
+type LastUseToilet struct {
+	CatId int64     `db:"catid"`
+	Type  string    `db:"type"`
+	Last  time.Time `db:"last"`
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-gorp/gorp"
+)
+
+// ApiKey デバイスやスクリプトからapiを利用するための個人用のキー
+// キーそのものは保存せず、SHA-256のハッシュのみを保存する
+type ApiKey struct {
+	Id       int64      `json:"id"       db:"id,primarykey,autoincrement"`
+	UID      int64      `json:"uid"      db:"uid,notnull"`
+	Name     string     `json:"name"     db:"name,notnull,size:200"`
+	Prefix   string     `json:"prefix"   db:"prefix,notnull,size:20"`
+	Hash     string     `json:"-"        db:"hash,notnull,size:64"`
+	Scopes   string     `json:"scopes"   db:"scopes,notnull,size:400"`
+	LastUsed *time.Time `json:"lastused" db:"lastused"`
+	Created  time.Time  `json:"created"  db:"created,notnull"`
+	Updated  time.Time  `json:"updated"  db:"updated,notnull"`
+}
+
+func (k *ApiKey) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	k.Created = now
+	k.Updated = now
+	return nil
+}
+
+func (k *ApiKey) PreUpdate(s gorp.SqlExecutor) error {
+	k.Updated = time.Now()
+	return nil
+}
@@ -6,15 +6,22 @@ import (
 	"github.com/go-gorp/gorp"
 )
 
+// Cat 猫
+// Age, AgeMonthsはBirthDateから読み込み時に計算する(保存しない)
 type Cat struct {
-	Id      int64     `json:"id"      db:"id,primarykey,autoincrement"`
-	UID     int64     `json:"uid"     db:"uid,notnull"`
-	Name    string    `json:"name"    db:"name,notnull,size:200"`
-	Breed   string    `json:"breed"   db:"breed,size:200"`
-	Gender  string    `json:"gender"  db:"gender,size:200"`
-	Age     int64     `json:"age"     db:"age"`
-	Created time.Time `json:"created" db:"created,notnull"`
-	Updated time.Time `json:"updated" db:"updated,notnull"`
+	Id                 int64      `json:"id"                 db:"id,primarykey,autoincrement"`
+	UID                int64      `json:"uid"                db:"uid,notnull"`
+	HouseholdId        int64      `json:"householdid"        db:"householdid,notnull"`
+	Name               string     `json:"name"               db:"name,notnull,size:200"`
+	Breed              string     `json:"breed"              db:"breed,size:200"`
+	Gender             string     `json:"gender"             db:"gender,size:200"`
+	BirthDate          *time.Time `json:"birthdate"          db:"birthdate"`
+	BirthDateEstimated bool       `json:"birthdateestimated" db:"birthdateestimated"`
+	Age                int64      `json:"age"                db:"-"`
+	AgeMonths          int64      `json:"agemonths"          db:"-"`
+	PhotoId            int64      `json:"photoid"            db:"photoid"`
+	Created            time.Time  `json:"created"            db:"created,notnull"`
+	Updated            time.Time  `json:"updated"            db:"updated,notnull"`
 }
 
 func (c *Cat) PreInsert(s gorp.SqlExecutor) error {
@@ -28,3 +35,25 @@ func (c *Cat) PreUpdate(s gorp.SqlExecutor) error {
 	c.Updated = time.Now()
 	return nil
 }
+
+func (c *Cat) PostGet(s gorp.SqlExecutor) error {
+	c.Age, c.AgeMonths = AgeAt(c.BirthDate, time.Now())
+	return nil
+}
+
+// AgeAt birthからnowまでの年齢を年と月で返す
+// birthがnilの場合は0を返す
+func AgeAt(birth *time.Time, now time.Time) (years, months int64) {
+	if birth == nil {
+		return 0, 0
+	}
+	b := birth.In(now.Location())
+	total := (now.Year()-b.Year())*12 + int(now.Month()-b.Month())
+	if now.Day() < b.Day() {
+		total--
+	}
+	if total < 0 {
+		return 0, 0
+	}
+	return int64(total / 12), int64(total % 12)
+}
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-gorp/gorp"
+)
+
+// CatWeight catの体重の記録
+type CatWeight struct {
+	Id          int64     `json:"id"          db:"id,primarykey,autoincrement"`
+	UID         int64     `json:"uid"         db:"uid,notnull"`
+	HouseholdId int64     `json:"householdid" db:"householdid,notnull"`
+	CatId       int64     `json:"catid"       db:"catid,notnull"`
+	Date        time.Time `json:"date"        db:"date,notnull"`
+	Grams       int64     `json:"grams"       db:"grams,notnull"`
+	Created     time.Time `json:"created"     db:"created,notnull"`
+	Updated     time.Time `json:"updated"     db:"updated,notnull"`
+}
+
+func (cw *CatWeight) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	cw.Created = now
+	cw.Updated = now
+	return nil
+}
+
+func (cw *CatWeight) PreUpdate(s gorp.SqlExecutor) error {
+	cw.Updated = time.Now()
+	return nil
+}
+
+// CatWeightSummary catの体重の推移
+// 比較できる過去の記録が無い場合、変化率はnullになる
+type CatWeightSummary struct {
+	CatId     int64      `json:"catid"`
+	Current   *CatWeight `json:"current"`
+	Change30  *float64   `json:"change30"`
+	Change90  *float64   `json:"change90"`
+	Threshold float64    `json:"threshold"`
+	LossAlert bool       `json:"lossalert"`
+}
@@ -0,0 +1,13 @@
+package model
+
+import (
+	"time"
+)
+
+// RecordFilter catごとの記録を一覧する際の絞り込み条件
+// 0またはnilの条件は絞り込みに使わない
+type RecordFilter struct {
+	CatId int64
+	From  *time.Time
+	To    *time.Time
+}
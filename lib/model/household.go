@@ -0,0 +1,100 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-gorp/gorp"
+)
+
+// Household roles
+const (
+	// RoleOwner householdの管理(メンバーの招待、削除)ができる
+	RoleOwner = "owner"
+	// RoleMember householdのデータを参照、更新できる
+	RoleMember = "member"
+	// RoleViewer householdのデータを参照のみできる
+	RoleViewer = "viewer"
+)
+
+// ValidRole roleが定義済みの役割か判定する
+func ValidRole(role string) bool {
+	return role == RoleOwner || role == RoleMember || role == RoleViewer
+}
+
+// Household cat, toilet, usetoilet, washを共有するユーザの集まり
+type Household struct {
+	Id      int64     `json:"id"      db:"id,primarykey,autoincrement"`
+	Name    string    `json:"name"    db:"name,notnull,size:200"`
+	Created time.Time `json:"created" db:"created,notnull"`
+	Updated time.Time `json:"updated" db:"updated,notnull"`
+}
+
+func (h *Household) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	h.Created = now
+	h.Updated = now
+	return nil
+}
+
+func (h *Household) PreUpdate(s gorp.SqlExecutor) error {
+	h.Updated = time.Now()
+	return nil
+}
+
+// HouseholdMember householdに所属するユーザとその役割
+type HouseholdMember struct {
+	Id          int64     `json:"id"          db:"id,primarykey,autoincrement"`
+	HouseholdId int64     `json:"householdid" db:"householdid,notnull"`
+	UID         int64     `json:"uid"         db:"uid,notnull"`
+	Role        string    `json:"role"        db:"role,notnull,size:50"`
+	Created     time.Time `json:"created"     db:"created,notnull"`
+	Updated     time.Time `json:"updated"     db:"updated,notnull"`
+}
+
+func (m *HouseholdMember) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	m.Created = now
+	m.Updated = now
+	return nil
+}
+
+func (m *HouseholdMember) PreUpdate(s gorp.SqlExecutor) error {
+	m.Updated = time.Now()
+	return nil
+}
+
+// CanWrite 役割がhouseholdのデータを更新できるか判定する
+func (m HouseholdMember) CanWrite() bool {
+	return m.Role == RoleOwner || m.Role == RoleMember
+}
+
+// HouseholdMembership ユーザが所属するhouseholdの一覧表示用
+type HouseholdMembership struct {
+	HouseholdId int64  `json:"householdid" db:"householdid"`
+	Name        string `json:"name"        db:"name"`
+	Role        string `json:"role"        db:"role"`
+}
+
+// HouseholdInvitation householdへの招待コード
+type HouseholdInvitation struct {
+	Id          int64     `json:"id"          db:"id,primarykey,autoincrement"`
+	HouseholdId int64     `json:"householdid" db:"householdid,notnull"`
+	Code        string    `json:"code"        db:"code,notnull,size:50"`
+	Role        string    `json:"role"        db:"role,notnull,size:50"`
+	CreatedBy   int64     `json:"createdby"   db:"createdby,notnull"`
+	Expires     time.Time `json:"expires"     db:"expires,notnull"`
+	Created     time.Time `json:"created"     db:"created,notnull"`
+	Updated     time.Time `json:"updated"     db:"updated,notnull"`
+}
+
+func (i *HouseholdInvitation) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	i.Created = now
+	i.Updated = now
+	return nil
+}
+
+func (i *HouseholdInvitation) PreUpdate(s gorp.SqlExecutor) error {
+	i.Updated = time.Now()
+	return nil
+}
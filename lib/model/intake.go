@@ -0,0 +1,73 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-gorp/gorp"
+)
+
+// Food kinds
+const (
+	FoodKindWet = "wet"
+	FoodKindDry = "dry"
+)
+
+// Feeding 食事の記録
+type Feeding struct {
+	Id          int64     `json:"id"          db:"id,primarykey,autoincrement"`
+	UID         int64     `json:"uid"         db:"uid,notnull"`
+	HouseholdId int64     `json:"householdid" db:"householdid,notnull"`
+	CatId       int64     `json:"catid"       db:"catid,notnull"`
+	FoodType    string    `json:"foodtype"    db:"foodtype,size:200"`
+	Kind        string    `json:"kind"        db:"kind,notnull,size:50"`
+	Grams       int64     `json:"grams"       db:"grams,notnull"`
+	OccurredAt  time.Time `json:"occurredat"  db:"occurredat,notnull"`
+	Created     time.Time `json:"created"     db:"created,notnull"`
+	Updated     time.Time `json:"updated"     db:"updated,notnull"`
+}
+
+func (f *Feeding) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	f.Created = now
+	f.Updated = now
+	return nil
+}
+
+func (f *Feeding) PreUpdate(s gorp.SqlExecutor) error {
+	f.Updated = time.Now()
+	return nil
+}
+
+// WaterIntake 飲水の記録
+type WaterIntake struct {
+	Id          int64     `json:"id"          db:"id,primarykey,autoincrement"`
+	UID         int64     `json:"uid"         db:"uid,notnull"`
+	HouseholdId int64     `json:"householdid" db:"householdid,notnull"`
+	CatId       int64     `json:"catid"       db:"catid,notnull"`
+	Milliliters int64     `json:"ml"          db:"ml,notnull"`
+	OccurredAt  time.Time `json:"occurredat"  db:"occurredat,notnull"`
+	Created     time.Time `json:"created"     db:"created,notnull"`
+	Updated     time.Time `json:"updated"     db:"updated,notnull"`
+}
+
+func (w *WaterIntake) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	w.Created = now
+	w.Updated = now
+	return nil
+}
+
+func (w *WaterIntake) PreUpdate(s gorp.SqlExecutor) error {
+	w.Updated = time.Now()
+	return nil
+}
+
+// IntakeDay 1日の食事、飲水の量とトイレの回数
+type IntakeDay struct {
+	Date      string           `json:"date"`
+	Feedings  int64            `json:"feedings"`
+	WetGrams  int64            `json:"wetgrams"`
+	DryGrams  int64            `json:"drygrams"`
+	WaterMl   int64            `json:"waterml"`
+	UseToilet map[string]int64 `json:"usetoilet"`
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+)
+
+// Job run statuses
+const (
+	JobStatusRunning = "running"
+	JobStatusSuccess = "success"
+	JobStatusFailed  = "failed"
+)
+
+// Job 定期的に実行する処理の実行状況
+// LockOwnerはLockedUntilまで実行中のサーバを表し、他のサーバはその間実行しない
+type Job struct {
+	Name        string     `json:"name"        db:"name,primarykey,size:100"`
+	Schedule    string     `json:"schedule"    db:"schedule,notnull,size:100"`
+	NextRun     time.Time  `json:"nextrun"     db:"nextrun,notnull"`
+	LastRun     *time.Time `json:"lastrun"     db:"lastrun"`
+	LastStatus  string     `json:"laststatus"  db:"laststatus,size:50"`
+	LastError   string     `json:"lasterror"   db:"lasterror,size:400"`
+	DurationMs  int64      `json:"durationms"  db:"durationms,notnull"`
+	LockOwner   string     `json:"lockowner"   db:"lockowner,size:200"`
+	LockedUntil *time.Time `json:"lockeduntil" db:"lockeduntil"`
+}
@@ -0,0 +1,54 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-gorp/gorp"
+)
+
+// DefaultLowStockDays 砂の在庫が無くなるまでの日数がこれ以下になると在庫が少ないとみなす
+const DefaultLowStockDays = 14
+
+// Litter 砂の在庫
+// StockGramsは砂を入れる掃除(LitterWashKinds)の記録で減り、Restockedは最後に在庫を補充した日時
+// LowStockDaysは在庫が少ないとみなす残りの日数で、0の場合はDefaultLowStockDays
+type Litter struct {
+	Id           int64           `json:"id"           db:"id,primarykey,autoincrement"`
+	UID          int64           `json:"uid"          db:"uid,notnull"`
+	HouseholdId  int64           `json:"householdid"  db:"householdid,notnull"`
+	Brand        string          `json:"brand"        db:"brand,notnull,size:200"`
+	BagGrams     int64           `json:"baggrams"     db:"baggrams,notnull"`
+	StockGrams   int64           `json:"stockgrams"   db:"stockgrams,notnull"`
+	LowStockDays int             `json:"lowstockdays" db:"lowstockdays,notnull"`
+	Restocked    time.Time       `json:"restocked"    db:"restocked,notnull"`
+	Forecast     *LitterForecast `json:"forecast"     db:"-"`
+	Created      time.Time       `json:"created"      db:"created,notnull"`
+	Updated      time.Time       `json:"updated"      db:"updated,notnull"`
+}
+
+func (l *Litter) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	l.Created = now
+	l.Updated = now
+	return nil
+}
+
+func (l *Litter) PreUpdate(s gorp.SqlExecutor) error {
+	l.Updated = time.Now()
+	return nil
+}
+
+// LitterForecast 砂の消費量と在庫が無くなる日の予測
+// 消費していない場合はDaysLeftとRunOutがnil
+type LitterForecast struct {
+	WeeklyGrams float64    `json:"weeklygrams"`
+	DaysLeft    *float64   `json:"daysleft"`
+	RunOut      *time.Time `json:"runout"`
+	LowStock    bool       `json:"lowstock"`
+}
+
+// LitterUsage 砂の在庫ごとの消費量
+type LitterUsage struct {
+	LitterId int64 `db:"litterid"`
+	Grams    int64 `db:"grams"`
+}
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-gorp/gorp"
+)
+
+// VetVisit 動物病院の受診記録
+// Costは最小通貨単位(円)で記録する
+type VetVisit struct {
+	Id          int64     `json:"id"          db:"id,primarykey,autoincrement"`
+	UID         int64     `json:"uid"         db:"uid,notnull"`
+	HouseholdId int64     `json:"householdid" db:"householdid,notnull"`
+	CatId       int64     `json:"catid"       db:"catid,notnull"`
+	Date        time.Time `json:"date"        db:"date,notnull"`
+	Clinic      string    `json:"clinic"      db:"clinic,size:200"`
+	Reason      string    `json:"reason"      db:"reason,size:400"`
+	Diagnosis   string    `json:"diagnosis"   db:"diagnosis,size:1000"`
+	Cost        int64     `json:"cost"        db:"cost"`
+	Created     time.Time `json:"created"     db:"created,notnull"`
+	Updated     time.Time `json:"updated"     db:"updated,notnull"`
+}
+
+func (v *VetVisit) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	v.Created = now
+	v.Updated = now
+	return nil
+}
+
+func (v *VetVisit) PreUpdate(s gorp.SqlExecutor) error {
+	v.Updated = time.Now()
+	return nil
+}
+
+// Vaccination ワクチン接種の記録
+type Vaccination struct {
+	Id          int64      `json:"id"          db:"id,primarykey,autoincrement"`
+	UID         int64      `json:"uid"         db:"uid,notnull"`
+	HouseholdId int64      `json:"householdid" db:"householdid,notnull"`
+	CatId       int64      `json:"catid"       db:"catid,notnull"`
+	Date        time.Time  `json:"date"        db:"date,notnull"`
+	Vaccine     string     `json:"vaccine"     db:"vaccine,notnull,size:200"`
+	Clinic      string     `json:"clinic"      db:"clinic,size:200"`
+	NextDue     *time.Time `json:"nextdue"     db:"nextdue"`
+	Comment     string     `json:"comment"     db:"comment,size:400"`
+	Created     time.Time  `json:"created"     db:"created,notnull"`
+	Updated     time.Time  `json:"updated"     db:"updated,notnull"`
+}
+
+func (v *Vaccination) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	v.Created = now
+	v.Updated = now
+	return nil
+}
+
+func (v *Vaccination) PreUpdate(s gorp.SqlExecutor) error {
+	v.Updated = time.Now()
+	return nil
+}
+
+// Condition kinds
+const (
+	ConditionKindCondition = "condition"
+	ConditionKindAllergy   = "allergy"
+)
+
+// Condition 持病やアレルギー
+// Resolvedがnilのものは継続中とみなす
+type Condition struct {
+	Id          int64      `json:"id"          db:"id,primarykey,autoincrement"`
+	UID         int64      `json:"uid"         db:"uid,notnull"`
+	HouseholdId int64      `json:"householdid" db:"householdid,notnull"`
+	CatId       int64      `json:"catid"       db:"catid,notnull"`
+	Kind        string     `json:"kind"        db:"kind,notnull,size:50"`
+	Name        string     `json:"name"        db:"name,notnull,size:200"`
+	Diagnosed   *time.Time `json:"diagnosed"   db:"diagnosed"`
+	Resolved    *time.Time `json:"resolved"    db:"resolved"`
+	Comment     string     `json:"comment"     db:"comment,size:400"`
+	Created     time.Time  `json:"created"     db:"created,notnull"`
+	Updated     time.Time  `json:"updated"     db:"updated,notnull"`
+}
+
+func (cd *Condition) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	cd.Created = now
+	cd.Updated = now
+	return nil
+}
+
+func (cd *Condition) PreUpdate(s gorp.SqlExecutor) error {
+	cd.Updated = time.Now()
+	return nil
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-gorp/gorp"
+)
+
+// Medication 投薬計画
+// 予定はTimes(1日の投与時刻)またはRRuleのどちらかで表し、TimeZoneのタイムゾーンで解釈する
+type Medication struct {
+	Id          int64      `json:"id"          db:"id,primarykey,autoincrement"`
+	UID         int64      `json:"uid"         db:"uid,notnull"`
+	HouseholdId int64      `json:"householdid" db:"householdid,notnull"`
+	CatId       int64      `json:"catid"       db:"catid,notnull"`
+	Drug        string     `json:"drug"        db:"drug,notnull,size:200"`
+	Dose        string     `json:"dose"        db:"dose,notnull,size:200"`
+	TimesPerDay int        `json:"timesperday" db:"timesperday"`
+	Times       string     `json:"times"       db:"times,size:200"`
+	RRule       string     `json:"rrule"       db:"rrule,size:400"`
+	TimeZone    string     `json:"timezone"    db:"timezone,size:100"`
+	Start       time.Time  `json:"start"       db:"startdate,notnull"`
+	End         *time.Time `json:"end"         db:"enddate"`
+	Comment     string     `json:"comment"     db:"comment,size:400"`
+	Created     time.Time  `json:"created"     db:"created,notnull"`
+	Updated     time.Time  `json:"updated"     db:"updated,notnull"`
+}
+
+func (m *Medication) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	m.Created = now
+	m.Updated = now
+	return nil
+}
+
+func (m *Medication) PreUpdate(s gorp.SqlExecutor) error {
+	m.Updated = time.Now()
+	return nil
+}
+
+// Location 予定を解釈するタイムゾーンを返す
+// 未設定または不正な場合はUTC
+func (m Medication) Location() *time.Location {
+	if m.TimeZone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(m.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Dose statuses
+const (
+	DoseStatusGiven   = "given"
+	DoseStatusSkipped = "skipped"
+)
+
+// MedicationDose 予定された投与を実施したか、見送ったかの記録
+type MedicationDose struct {
+	Id           int64     `json:"id"           db:"id,primarykey,autoincrement"`
+	UID          int64     `json:"uid"          db:"uid,notnull"`
+	HouseholdId  int64     `json:"householdid"  db:"householdid,notnull"`
+	MedicationId int64     `json:"medicationid" db:"medicationid,notnull"`
+	CatId        int64     `json:"catid"        db:"catid,notnull"`
+	Scheduled    time.Time `json:"scheduled"    db:"scheduled,notnull"`
+	Status       string    `json:"status"       db:"status,notnull,size:50"`
+	Comment      string    `json:"comment"      db:"comment,size:400"`
+	Created      time.Time `json:"created"      db:"created,notnull"`
+	Updated      time.Time `json:"updated"      db:"updated,notnull"`
+}
+
+func (d *MedicationDose) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	d.Created = now
+	d.Updated = now
+	return nil
+}
+
+func (d *MedicationDose) PreUpdate(s gorp.SqlExecutor) error {
+	d.Updated = time.Now()
+	return nil
+}
+
+// OverdueDose 予定時刻を過ぎても記録されていない投与
+type OverdueDose struct {
+	MedicationId int64     `json:"medicationid"`
+	CatId        int64     `json:"catid"`
+	Drug         string    `json:"drug"`
+	Dose         string    `json:"dose"`
+	Scheduled    time.Time `json:"scheduled"`
+}
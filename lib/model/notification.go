@@ -0,0 +1,135 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-gorp/gorp"
+)
+
+// Notification rules
+const (
+	// NotifyRuleToiletDue 掃除の期限を過ぎたtoilet
+	NotifyRuleToiletDue = "toilet_due"
+	// NotifyRuleMedicationDue 投与漏れの薬
+	NotifyRuleMedicationDue = "medication_due"
+	// NotifyRuleHealthAlert トイレの記録から検出した異常
+	NotifyRuleHealthAlert = "health_alert"
+	// NotifyRuleLitterLow 在庫が少ない砂
+	NotifyRuleLitterLow = "litter_low"
+	// NotifyRuleTest 設定を確認するための通知
+	NotifyRuleTest = "test"
+)
+
+// ValidNotifyRule ruleが購読できる通知の種類か
+func ValidNotifyRule(rule string) bool {
+	switch rule {
+	case NotifyRuleToiletDue, NotifyRuleMedicationDue, NotifyRuleHealthAlert, NotifyRuleLitterLow:
+		return true
+	}
+	return false
+}
+
+// Notification channels
+const (
+	NotifyChannelEmail   = "email"
+	NotifyChannelWebhook = "webhook"
+	NotifyChannelWebPush = "webpush"
+)
+
+// Notification statuses
+const (
+	NotificationStatusPending = "pending"
+	NotificationStatusSent    = "sent"
+	NotificationStatusFailed  = "failed"
+)
+
+// NotificationPreference ユーザの通知の受け取り方
+// Rulesは受け取る通知の種類のカンマ区切りで、空の場合は全ての種類を受け取る
+// QuietStartからQuietEnd("HH:MM", ユーザのタイムゾーン)の間は緊急でない通知を遅らせる
+type NotificationPreference struct {
+	Id          int64     `json:"id"          db:"id,primarykey,autoincrement"`
+	UID         int64     `json:"uid"         db:"uid,notnull"`
+	Channel     string    `json:"channel"     db:"channel,notnull,size:50"`
+	Destination string    `json:"destination" db:"destination,notnull,size:2000"`
+	Rules       string    `json:"rules"       db:"rules,size:400"`
+	QuietStart  string    `json:"quietstart"  db:"quietstart,size:10"`
+	QuietEnd    string    `json:"quietend"    db:"quietend,size:10"`
+	Enabled     bool      `json:"enabled"     db:"enabled"`
+	Created     time.Time `json:"created"     db:"created,notnull"`
+	Updated     time.Time `json:"updated"     db:"updated,notnull"`
+}
+
+func (p *NotificationPreference) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	p.Created = now
+	p.Updated = now
+	return nil
+}
+
+func (p *NotificationPreference) PreUpdate(s gorp.SqlExecutor) error {
+	p.Updated = time.Now()
+	return nil
+}
+
+// RuleList Rulesを通知の種類の一覧にする
+func (p NotificationPreference) RuleList() []string {
+	var rules []string
+	for _, r := range strings.Split(p.Rules, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// Wants ruleの通知を受け取るか
+func (p NotificationPreference) Wants(rule string) bool {
+	if rule == NotifyRuleTest {
+		return true
+	}
+	rules := p.RuleList()
+	if len(rules) == 0 {
+		return true
+	}
+	for _, r := range rules {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// Notification 送信する通知(outbox)
+// 同じPreferenceIdとDedupKeyの通知は1度だけ送る
+type Notification struct {
+	Id           int64      `json:"id"           db:"id,primarykey,autoincrement"`
+	UID          int64      `json:"uid"          db:"uid,notnull"`
+	HouseholdId  int64      `json:"householdid"  db:"householdid,notnull"`
+	PreferenceId int64      `json:"preferenceid" db:"preferenceid,notnull"`
+	Rule         string     `json:"rule"         db:"rule,notnull,size:50"`
+	DedupKey     string     `json:"-"            db:"dedupkey,notnull,size:200"`
+	Channel      string     `json:"channel"      db:"channel,notnull,size:50"`
+	Destination  string     `json:"-"            db:"destination,notnull,size:2000"`
+	Subject      string     `json:"subject"      db:"subject,size:400"`
+	Body         string     `json:"body"         db:"body,size:4000"`
+	Status       string     `json:"status"       db:"status,notnull,size:50"`
+	Attempts     int        `json:"attempts"     db:"attempts,notnull"`
+	NextAttempt  time.Time  `json:"nextattempt"  db:"nextattempt,notnull"`
+	LastError    string     `json:"lasterror"    db:"lasterror,size:400"`
+	Sent         *time.Time `json:"sent"         db:"sent"`
+	Created      time.Time  `json:"created"      db:"created,notnull"`
+	Updated      time.Time  `json:"updated"      db:"updated,notnull"`
+}
+
+func (n *Notification) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	n.Created = now
+	n.Updated = now
+	return nil
+}
+
+func (n *Notification) PreUpdate(s gorp.SqlExecutor) error {
+	n.Updated = time.Now()
+	return nil
+}
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-gorp/gorp"
+)
+
+// Photo BlobStoreに保存した画像とそのサムネイル
+type Photo struct {
+	Id          int64     `json:"id"          db:"id,primarykey,autoincrement"`
+	UID         int64     `json:"uid"         db:"uid,notnull"`
+	HouseholdId int64     `json:"householdid" db:"householdid,notnull"`
+	Key         string    `json:"-"           db:"blobkey,notnull,size:400"`
+	ThumbKey    string    `json:"-"           db:"thumbkey,notnull,size:400"`
+	ContentType string    `json:"contenttype" db:"contenttype,notnull,size:100"`
+	Size        int64     `json:"size"        db:"size,notnull"`
+	Width       int       `json:"width"       db:"width"`
+	Height      int       `json:"height"      db:"height"`
+	Created     time.Time `json:"created"     db:"created,notnull"`
+	Updated     time.Time `json:"updated"     db:"updated,notnull"`
+}
+
+func (p *Photo) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	p.Created = now
+	p.Updated = now
+	return nil
+}
+
+func (p *Photo) PreUpdate(s gorp.SqlExecutor) error {
+	p.Updated = time.Now()
+	return nil
+}
+
+// ErrTooManyPhotos usetoiletに添付できる画像の数を超える
+var ErrTooManyPhotos = errors.New("too many photos")
+
+// UseToiletPhoto usetoiletに添付した画像
+type UseToiletPhoto struct {
+	Id          int64     `json:"id"          db:"id,primarykey,autoincrement"`
+	HouseholdId int64     `json:"householdid" db:"householdid,notnull"`
+	UseToiletId int64     `json:"usetoiletid" db:"usetoiletid,notnull"`
+	PhotoId     int64     `json:"photoid"     db:"photoid,notnull"`
+	Created     time.Time `json:"created"     db:"created,notnull"`
+}
+
+func (up *UseToiletPhoto) PreInsert(s gorp.SqlExecutor) error {
+	up.Created = time.Now()
+	return nil
+}
+
+// PhotoLinks 画像を取得するための期限付きURL
+type PhotoLinks struct {
+	Id           int64     `json:"id"`
+	Url          string    `json:"url"`
+	ThumbnailUrl string    `json:"thumbnailurl"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	Expires      time.Time `json:"expires"`
+}
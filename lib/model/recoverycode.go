@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-gorp/gorp"
+)
+
+// RecoveryCode TOTPを利用できない時のためのリカバリーコード
+// Codeには平文ではなくSHA-256のハッシュを保存する
+type RecoveryCode struct {
+	Id      int64     `json:"id"      db:"id,primarykey,autoincrement"`
+	UID     int64     `json:"uid"     db:"uid,notnull"`
+	Code    string    `json:"-"       db:"code,notnull,size:64"`
+	Used    bool      `json:"used"    db:"used"`
+	Created time.Time `json:"created" db:"created,notnull"`
+	Updated time.Time `json:"updated" db:"updated,notnull"`
+}
+
+func (rc *RecoveryCode) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	rc.Created = now
+	rc.Updated = now
+	return nil
+}
+
+func (rc *RecoveryCode) PreUpdate(s gorp.SqlExecutor) error {
+	rc.Updated = time.Now()
+	return nil
+}
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-gorp/gorp"
+)
+
+// Sand states
+const (
+	SandStateClean = "clean"
+	SandStateUsed  = "used"
+	SandStateDirty = "dirty"
+)
+
+// Reasons of sand state transitions
+const (
+	SandReasonUseToilet = "usetoilet"
+	SandReasonWash      = "wash"
+)
+
+// SandThresholds 砂の状態が変わる使用回数
+type SandThresholds struct {
+	// Used この回数以上使われるとused
+	Used int
+	// Dirty この回数以上使われるとdirty
+	Dirty int
+}
+
+// DefaultSandThresholds 既定の閾値
+var DefaultSandThresholds = SandThresholds{Used: 1, Dirty: 5}
+
+// State 前回の掃除からの使用回数に応じた砂の状態を返す
+func (th SandThresholds) State(count int) string {
+	switch {
+	case count >= th.Dirty:
+		return SandStateDirty
+	case count >= th.Used:
+		return SandStateUsed
+	}
+	return SandStateClean
+}
+
+// SandStateTransition toiletの砂の状態の変化の履歴
+type SandStateTransition struct {
+	Id          int64     `json:"id"          db:"id,primarykey,autoincrement"`
+	HouseholdId int64     `json:"householdid" db:"householdid,notnull"`
+	ToiletId    int64     `json:"toiletid"    db:"toiletid,notnull"`
+	From        string    `json:"from"        db:"fromstate,size:50"`
+	To          string    `json:"to"          db:"tostate,size:50"`
+	Reason      string    `json:"reason"      db:"reason,size:50"`
+	UsageCount  int       `json:"usagecount"  db:"usagecount,notnull"`
+	Created     time.Time `json:"created"     db:"created,notnull"`
+}
+
+func (t *SandStateTransition) PreInsert(s gorp.SqlExecutor) error {
+	t.Created = time.Now()
+	return nil
+}
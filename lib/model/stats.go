@@ -0,0 +1,39 @@
+package model
+
+// UseToiletCount 1日ごと、cat、toilet、種類ごとのトイレの回数
+type UseToiletCount struct {
+	Day      string `db:"day"`
+	CatId    int64  `db:"catid"`
+	ToiletId int64  `db:"toiletid"`
+	Type     string `db:"type"`
+	Count    int64  `db:"count"`
+}
+
+// Stats grouping
+const (
+	StatsGroupDay   = "day"
+	StatsGroupWeek  = "week"
+	StatsGroupMonth = "month"
+)
+
+// UseToiletStats catのトイレの回数の集計
+type UseToiletStats struct {
+	CatId   int64             `json:"catid"`
+	Buckets []UseToiletBucket `json:"buckets"`
+}
+
+// UseToiletBucket 集計期間1つ分のトイレの回数
+// Startは期間の初日(ユーザのタイムゾーンの日付)
+type UseToiletBucket struct {
+	Start   string              `json:"start"`
+	Total   int64               `json:"total"`
+	Types   map[string]int64    `json:"types"`
+	Toilets []ToiletUsageCounts `json:"toilets"`
+}
+
+// ToiletUsageCounts 集計期間1つ分のtoiletごとのトイレの回数
+type ToiletUsageCounts struct {
+	ToiletId int64            `json:"toiletid"`
+	Total    int64            `json:"total"`
+	Types    map[string]int64 `json:"types"`
+}
@@ -6,14 +6,25 @@ import (
 	"github.com/go-gorp/gorp"
 )
 
+// Toilet トイレ
+// SandChangedは最後に砂を全て入れ替えた日時、SandAgeDaysはそれからの日数
+// ScoopHoursはすくう掃除、ChangeDaysは砂の全交換の間隔で、0の場合はその掃除の予定を立てない
+// LitterIdはこのtoiletで使う砂の在庫で、掃除の記録で砂の在庫を指定しない場合に使う
 type Toilet struct {
-	Id        int64     `json:"id"              db:"id,primarykey,autoincrement"`
-	UID       int64     `json:"uid"             db:"uid,notnull"`
-	Name      string    `json:"name"            db:"name,notnull,size:200"`
-	Comment   string    `json:"comment"         db:"comment,size:400"`
-	SandState string    `json:"sandstate"       db:"sandstate,size:50"`
-	Created   time.Time `json:"created"         db:"created,notnull"`
-	Updated   time.Time `json:"updated"         db:"updated,notnull"`
+	Id          int64      `json:"id"              db:"id,primarykey,autoincrement"`
+	UID         int64      `json:"uid"             db:"uid,notnull"`
+	HouseholdId int64      `json:"householdid"     db:"householdid,notnull"`
+	Name        string     `json:"name"            db:"name,notnull,size:200"`
+	Comment     string     `json:"comment"         db:"comment,size:400"`
+	SandState   string     `json:"sandstate"       db:"sandstate,size:50"`
+	UsageCount  int        `json:"usagecount"      db:"usagecount,notnull"`
+	SandChanged *time.Time `json:"sandchanged"     db:"sandchanged"`
+	SandAgeDays *int       `json:"sandagedays"     db:"-"`
+	ScoopHours  int        `json:"scoophours"      db:"scoophours,notnull"`
+	ChangeDays  int        `json:"fullchangedays"  db:"fullchangedays,notnull"`
+	LitterId    int64      `json:"litterid"        db:"litterid,notnull"`
+	Created     time.Time  `json:"created"         db:"created,notnull"`
+	Updated     time.Time  `json:"updated"         db:"updated,notnull"`
 }
 
 func (t *Toilet) PreInsert(s gorp.SqlExecutor) error {
@@ -23,7 +34,39 @@ func (t *Toilet) PreInsert(s gorp.SqlExecutor) error {
 	return nil
 }
 
+// SetSandAge 最後に砂を全て入れ替えてからnowまでの日数を設定する
+func (t *Toilet) SetSandAge(now time.Time) {
+	if t.SandChanged == nil {
+		t.SandAgeDays = nil
+		return
+	}
+	days := int(now.Sub(*t.SandChanged).Hours() / 24)
+	t.SandAgeDays = &days
+}
+
 func (t *Toilet) PreUpdate(s gorp.SqlExecutor) error {
 	t.Updated = time.Now()
 	return nil
 }
+
+// Cleaning tasks
+const (
+	CleaningTaskScoop      = "scoop"
+	CleaningTaskFullChange = "full_change"
+)
+
+// LastWash toiletの掃除の種類ごとの最後の掃除の日時
+type LastWash struct {
+	ToiletId int64     `db:"toiletid"`
+	Kind     string    `db:"kind"`
+	Last     time.Time `db:"last"`
+}
+
+// ToiletDue 掃除の期限を過ぎたtoilet
+type ToiletDue struct {
+	ToiletId int64      `json:"toiletid"`
+	Name     string     `json:"name"`
+	Task     string     `json:"task"`
+	Last     *time.Time `json:"last"`
+	Due      time.Time  `json:"due"`
+}
@@ -6,12 +6,29 @@ import (
 	"github.com/go-gorp/gorp"
 )
 
+// User roles
+const (
+	// UserRoleAdmin サービス全体を管理できる
+	UserRoleAdmin = "admin"
+	// UserRoleUser 通常のユーザ
+	UserRoleUser = "user"
+	// UserRoleReadonly データの参照のみできる
+	UserRoleReadonly = "readonly"
+)
+
 type User struct {
-	Id       int64     `json:"id"       db:"id,primarykey,autoincrement"`
-	Name     string    `json:"name"     db:"name,notnull,size:200"`
-	Password string    `json:"password" db:"password,notnull,size:400"`
-	Created  time.Time `json:"created"  db:"created,notnull"`
-	Updated  time.Time `json:"updated"  db:"updated,notnull"`
+	Id          int64     `json:"id"          db:"id,primarykey,autoincrement"`
+	Name        string    `json:"name"        db:"name,notnull,size:200"`
+	Password    string    `json:"password"    db:"password,notnull,size:400"`
+	Role        string    `json:"role"        db:"role,notnull,size:50"`
+	TotpSecret  string    `json:"-"           db:"totpsecret,size:400"`
+	TotpEnabled bool      `json:"totpenabled" db:"totpenabled"`
+	TotpStep    int64     `json:"-"           db:"totpstep"`
+	Disabled    bool      `json:"disabled"    db:"disabled"`
+	LogoutAt    int64     `json:"-"           db:"logoutat"`
+	TimeZone    string    `json:"timezone"    db:"timezone,size:100"`
+	Created     time.Time `json:"created"     db:"created,notnull"`
+	Updated     time.Time `json:"updated"     db:"updated,notnull"`
 }
 
 func (u *User) PreInsert(s gorp.SqlExecutor) error {
@@ -24,3 +41,39 @@ func (u *User) PreUpdate(s gorp.SqlExecutor) error {
 	u.Updated = time.Now()
 	return nil
 }
+
+// Location ユーザのタイムゾーンを返す
+// 未設定または不明なタイムゾーンの場合はUTCとする
+func (u User) Location() *time.Location {
+	if u.TimeZone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(u.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// ValidTimeZone IANAのタイムゾーン名として解釈できるか判定する
+// 空文字は未設定として扱う
+func ValidTimeZone(tz string) bool {
+	if tz == "" {
+		return true
+	}
+	if tz == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(tz)
+	return err == nil
+}
+
+// UserDataCounts ユーザが登録したデータの件数
+type UserDataCounts struct {
+	Cats       int64 `json:"cats"       db:"cats"`
+	Toilets    int64 `json:"toilets"    db:"toilets"`
+	UseToilets int64 `json:"usetoilets" db:"usetoilets"`
+	Washes     int64 `json:"washes"     db:"washes"`
+	Households int64 `json:"households" db:"households"`
+	ApiKeys    int64 `json:"apikeys"    db:"apikeys"`
+}
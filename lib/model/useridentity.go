@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-gorp/gorp"
+)
+
+// UserIdentity 外部のIdentity Providerのアカウントとユーザの紐付け
+type UserIdentity struct {
+	Id       int64     `json:"id"       db:"id,primarykey,autoincrement"`
+	UID      int64     `json:"uid"      db:"uid,notnull"`
+	Provider string    `json:"provider" db:"provider,notnull,size:100"`
+	Subject  string    `json:"subject"  db:"subject,notnull,size:255"`
+	Email    string    `json:"email"    db:"email,size:255"`
+	Created  time.Time `json:"created"  db:"created,notnull"`
+	Updated  time.Time `json:"updated"  db:"updated,notnull"`
+}
+
+func (ui *UserIdentity) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	ui.Created = now
+	ui.Updated = now
+	return nil
+}
+
+func (ui *UserIdentity) PreUpdate(s gorp.SqlExecutor) error {
+	ui.Updated = time.Now()
+	return nil
+}
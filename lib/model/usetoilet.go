@@ -1,19 +1,60 @@
 package model
 
 import (
+	"errors"
 	"time"
 
 	"github.com/go-gorp/gorp"
 )
 
+// UseToilet types
+const (
+	UseToiletTypePee  = "pee"
+	UseToiletTypePoop = "poop"
+)
+
+// Clump sizes
+const (
+	ClumpSizeSmall  = "small"
+	ClumpSizeMedium = "medium"
+	ClumpSizeLarge  = "large"
+)
+
+// StoolColors 便の色として記録できる値
+var StoolColors = map[string]bool{
+	"brown": true, "dark_brown": true, "light_brown": true, "yellow": true,
+	"green": true, "black": true, "red": true, "gray": true,
+}
+
+// UrineColors 尿の色として記録できる値
+var UrineColors = map[string]bool{
+	"clear": true, "pale_yellow": true, "yellow": true, "dark_yellow": true,
+	"orange": true, "red": true, "brown": true,
+}
+
+// MaxUseToiletDuration トイレの所要時間として記録できる上限(秒)
+const MaxUseToiletDuration = 3600
+
+// UseToilet 猫がトイレを使用した記録
+// Photosは添付した画像で、一覧の取得時のみ設定する(保存しない)
+// StoolScore以降は任意の観察記録で、Typeごとに記録できる項目が異なる
 type UseToilet struct {
-	Id       int64     `json:"id"       db:"id,primarykey,autoincrement"`
-	UID      int64     `json:"uid"      db:"uid,notnull"`
-	ToiletId int64     `json:"toiletid" db:"toiletid,notnull"`
-	CatId    int64     `json:"catid"    db:"catid,notnull"`
-	Type     string    `json:"type"     db:"type,notnull,size:200"`
-	Created  time.Time `json:"created"  db:"created,notnull"`
-	Updated  time.Time `json:"updated"  db:"updated,notnull"`
+	Id          int64        `json:"id"               db:"id,primarykey,autoincrement"`
+	UID         int64        `json:"uid"              db:"uid,notnull"`
+	HouseholdId int64        `json:"householdid"      db:"householdid,notnull"`
+	ToiletId    int64        `json:"toiletid"         db:"toiletid,notnull"`
+	CatId       int64        `json:"catid"            db:"catid,notnull"`
+	Type        string       `json:"type"             db:"type,notnull,size:200"`
+	StoolScore  *int         `json:"stoolscore"       db:"stoolscore"`
+	Color       string       `json:"color"            db:"color,size:50"`
+	Blood       *bool        `json:"blood"            db:"blood"`
+	Mucus       *bool        `json:"mucus"            db:"mucus"`
+	ClumpSize   string       `json:"clumpsize"        db:"clumpsize,size:50"`
+	Straining   *bool        `json:"straining"        db:"straining"`
+	DurationSec *int         `json:"durationsec"      db:"durationsec"`
+	Photos      []PhotoLinks `json:"photos,omitempty" db:"-"`
+	Created     time.Time    `json:"created"          db:"created,notnull"`
+	Updated     time.Time    `json:"updated"          db:"updated,notnull"`
 }
 
 func (ut *UseToilet) PreInsert(s gorp.SqlExecutor) error {
@@ -27,3 +68,64 @@ func (ut *UseToilet) PreUpdate(s gorp.SqlExecutor) error {
 	ut.Updated = time.Now()
 	return nil
 }
+
+// ValidateObservation Typeに応じて観察記録の項目を検証する
+// 便(poop)はstoolscore, mucus、尿(pee)はclumpsizeを記録でき、
+// color, blood, straining, durationsecはどちらにも記録できる
+// それ以外のTypeには観察記録を付けられない
+func (ut UseToilet) ValidateObservation() error {
+	var colors map[string]bool
+	switch ut.Type {
+	case UseToiletTypePoop:
+		colors = StoolColors
+		if ut.ClumpSize != "" {
+			return errors.New("clumpsize can be recorded only for pee")
+		}
+		if ut.StoolScore != nil && (*ut.StoolScore < 1 || *ut.StoolScore > 7) {
+			return errors.New("stoolscore must be between 1 and 7")
+		}
+	case UseToiletTypePee:
+		colors = UrineColors
+		if ut.StoolScore != nil || ut.Mucus != nil {
+			return errors.New("stoolscore and mucus can be recorded only for poop")
+		}
+		switch ut.ClumpSize {
+		case "", ClumpSizeSmall, ClumpSizeMedium, ClumpSizeLarge:
+		default:
+			return errors.New("clumpsize must be small, medium or large")
+		}
+	default:
+		if ut.HasObservation() {
+			return errors.New("observations can be recorded only for pee or poop")
+		}
+		return nil
+	}
+	if ut.Color != "" && !colors[ut.Color] {
+		return errors.New("invalid color for " + ut.Type)
+	}
+	if ut.DurationSec != nil && (*ut.DurationSec < 0 || *ut.DurationSec > MaxUseToiletDuration) {
+		return errors.New("durationsec is out of range")
+	}
+	return nil
+}
+
+// HasObservation 観察記録が1つでも記録されているか判定する
+func (ut UseToilet) HasObservation() bool {
+	return ut.StoolScore != nil || ut.Color != "" || ut.Blood != nil || ut.Mucus != nil ||
+		ut.ClumpSize != "" || ut.Straining != nil || ut.DurationSec != nil
+}
+
+// UseToiletFilter usetoiletの一覧の絞り込み条件
+// 0、空文字、nilの条件は絞り込みに使わない
+type UseToiletFilter struct {
+	RecordFilter
+	ToiletId      int64
+	Type          string
+	StoolScoreMin int
+	StoolScoreMax int
+	Color         string
+	Blood         *bool
+	Mucus         *bool
+	ClumpSize     string
+	Straining     *bool
+}
@@ -6,13 +6,64 @@ import (
 	"github.com/go-gorp/gorp"
 )
 
+// Wash kinds
+const (
+	// WashKindScoop 固まった砂とうんちを取り除く
+	WashKindScoop = "scoop"
+	// WashKindFullChange 砂を全て入れ替える
+	WashKindFullChange = "full_change"
+	// WashKindDeepClean 砂を全て入れ替え、トイレ本体も洗う
+	WashKindDeepClean = "deep_clean"
+	// WashKindTopUp 減った砂を継ぎ足す
+	WashKindTopUp = "top_up"
+)
+
+// ValidWashKind kindが掃除の種類として正しいか
+func ValidWashKind(kind string) bool {
+	switch kind {
+	case WashKindScoop, WashKindFullChange, WashKindDeepClean, WashKindTopUp:
+		return true
+	}
+	return false
+}
+
+// SandWashKinds 砂を全て入れ替える掃除の種類
+var SandWashKinds = []string{WashKindFullChange, WashKindDeepClean}
+
+// ReplacesSand 砂を全て入れ替える掃除か
+func (w Wash) ReplacesSand() bool {
+	return w.Kind == WashKindFullChange || w.Kind == WashKindDeepClean
+}
+
+// LitterWashKinds 砂を入れる掃除の種類
+var LitterWashKinds = []string{WashKindFullChange, WashKindDeepClean, WashKindTopUp}
+
+// AddsLitter 砂を入れる掃除か。LitterGramsだけ砂の在庫から減らす
+// 在庫が足りない場合は在庫の分だけ減らし、減らした量をLitterTakenに記録する
+func (w Wash) AddsLitter() bool {
+	for _, k := range LitterWashKinds {
+		if w.Kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+// Wash トイレの掃除
+// LitterIdは入れた砂の在庫で、0の場合はtoiletのLitterIdを使う
+// LitterTakenは実際に砂の在庫から減らした量で、掃除を更新、削除する際にこの量を在庫に戻す
 type Wash struct {
-	Id       int64     `json:"id"       db:"id,primarykey,autoincrement"`
-	UID      int64     `json:"uid"      db:"uid,notnull"`
-	ToiletId int64     `json:"toiletid" db:"toiletid,notnull"`
-	Comment  string    `json:"comment"  db:"comment,size:400"`
-	Created  time.Time `json:"created"  db:"created,notnull"`
-	Updated  time.Time `json:"updated"  db:"updated,notnull"`
+	Id          int64     `json:"id"          db:"id,primarykey,autoincrement"`
+	UID         int64     `json:"uid"         db:"uid,notnull"`
+	HouseholdId int64     `json:"householdid" db:"householdid,notnull"`
+	ToiletId    int64     `json:"toiletid"    db:"toiletid,notnull"`
+	Kind        string    `json:"kind"        db:"kind,notnull,size:50"`
+	LitterGrams int64     `json:"littergrams" db:"littergrams,notnull"`
+	LitterId    int64     `json:"litterid"    db:"litterid,notnull"`
+	LitterTaken int64     `json:"littertaken" db:"littertaken,notnull"`
+	Comment     string    `json:"comment"     db:"comment,size:400"`
+	Created     time.Time `json:"created"     db:"created,notnull"`
+	Updated     time.Time `json:"updated"     db:"updated,notnull"`
 }
 
 func (w *Wash) PreInsert(s gorp.SqlExecutor) error {
@@ -0,0 +1,134 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-gorp/gorp"
+)
+
+// Webhook events
+const (
+	WebhookEventCatCreated         = "cat.created"
+	WebhookEventCatUpdated         = "cat.updated"
+	WebhookEventCatDeleted         = "cat.deleted"
+	WebhookEventUseToiletCreated   = "usetoilet.created"
+	WebhookEventWashCreated        = "wash.created"
+	WebhookEventToiletStateChanged = "toilet.state_changed"
+	// WebhookEventTest 購読している種類に関わらず送る確認用のイベント
+	WebhookEventTest = "webhook.test"
+)
+
+// WebhookEvents 購読できるイベントの種類
+var WebhookEvents = []string{
+	WebhookEventCatCreated,
+	WebhookEventCatUpdated,
+	WebhookEventCatDeleted,
+	WebhookEventUseToiletCreated,
+	WebhookEventWashCreated,
+	WebhookEventToiletStateChanged,
+}
+
+// ValidWebhookEvent eventが購読できるイベントの種類か
+func ValidWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook householdのイベントを送るURL
+// Eventsは購読するイベントの種類のカンマ区切りで、空の場合は全ての種類を購読する
+// SecretはペイロードのHMAC-SHA256署名に使い、作成時以外は返さない
+type Webhook struct {
+	Id          int64     `json:"id"          db:"id,primarykey,autoincrement"`
+	UID         int64     `json:"uid"         db:"uid,notnull"`
+	HouseholdId int64     `json:"householdid" db:"householdid,notnull"`
+	URL         string    `json:"url"         db:"url,notnull,size:2000"`
+	Secret      string    `json:"secret,omitempty" db:"secret,notnull,size:200"`
+	Events      string    `json:"events"      db:"events,size:1000"`
+	Enabled     bool      `json:"enabled"     db:"enabled"`
+	Created     time.Time `json:"created"     db:"created,notnull"`
+	Updated     time.Time `json:"updated"     db:"updated,notnull"`
+}
+
+func (w *Webhook) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	w.Created = now
+	w.Updated = now
+	return nil
+}
+
+func (w *Webhook) PreUpdate(s gorp.SqlExecutor) error {
+	w.Updated = time.Now()
+	return nil
+}
+
+// EventList Eventsをイベントの種類の一覧にする
+func (w Webhook) EventList() []string {
+	var events []string
+	for _, e := range strings.Split(w.Events, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// Wants eventを購読しているか
+func (w Webhook) Wants(event string) bool {
+	if event == WebhookEventTest {
+		return true
+	}
+	events := w.EventList()
+	if len(events) == 0 {
+		return true
+	}
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEvent webhookで送るペイロード
+type WebhookEvent struct {
+	Id          string      `json:"id"`
+	Type        string      `json:"type"`
+	HouseholdId int64       `json:"householdid"`
+	Created     time.Time   `json:"created"`
+	Data        interface{} `json:"data"`
+}
+
+// WebhookDelivery webhookへのイベントの送信と、その結果の記録
+type WebhookDelivery struct {
+	Id             int64      `json:"id"             db:"id,primarykey,autoincrement"`
+	WebhookId      int64      `json:"webhookid"      db:"webhookid,notnull"`
+	HouseholdId    int64      `json:"householdid"    db:"householdid,notnull"`
+	EventId        string     `json:"eventid"        db:"eventid,notnull,size:64"`
+	Event          string     `json:"event"          db:"event,notnull,size:100"`
+	Payload        string     `json:"payload"        db:"payload,notnull,size:65535"`
+	Status         string     `json:"status"         db:"status,notnull,size:50"`
+	Attempts       int        `json:"attempts"       db:"attempts,notnull"`
+	NextAttempt    time.Time  `json:"nextattempt"    db:"nextattempt,notnull"`
+	ResponseStatus int        `json:"responsestatus" db:"responsestatus,notnull"`
+	LastError      string     `json:"lasterror"      db:"lasterror,size:400"`
+	Sent           *time.Time `json:"sent"           db:"sent"`
+	Created        time.Time  `json:"created"        db:"created,notnull"`
+	Updated        time.Time  `json:"updated"        db:"updated,notnull"`
+}
+
+func (d *WebhookDelivery) PreInsert(s gorp.SqlExecutor) error {
+	now := time.Now()
+	d.Created = now
+	d.Updated = now
+	return nil
+}
+
+func (d *WebhookDelivery) PreUpdate(s gorp.SqlExecutor) error {
+	d.Updated = time.Now()
+	return nil
+}
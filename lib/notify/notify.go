@@ -0,0 +1,169 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxAttempts 送信に失敗した通知を再送する回数の上限
+	MaxAttempts = 8
+	// maxBackoff 再送までの間隔の上限
+	maxBackoff = 6 * time.Hour
+)
+
+// Message 通知の内容
+type Message struct {
+	Rule    string `json:"rule"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Channel 通知を届ける手段
+type Channel interface {
+	// Validate destがこのChannelの宛先として正しいか検証する
+	Validate(dest string) error
+	// Send destへmを送る
+	Send(dest string, m Message) error
+}
+
+// permanentError 再送しても成功しない送信エラー
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Permanent 再送しても成功しないエラーとしてerrを包む
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// IsPermanent errが再送しても成功しないエラーか
+func IsPermanent(err error) bool {
+	var pe permanentError
+	return errors.As(err, &pe)
+}
+
+// Backoff attempts回目の失敗の後、再送するまでの間隔
+func Backoff(attempts int) time.Duration {
+	d := time.Minute
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// ValidQuietHours 通知を控える時間帯 "HH:MM" の組を検証する
+// 両方が空の場合は時間帯を設定しない
+func ValidQuietHours(start, end string) error {
+	if start == "" && end == "" {
+		return nil
+	}
+	if _, err := time.Parse("15:04", start); err != nil {
+		return fmt.Errorf("invalid quiet hours start %q", start)
+	}
+	if _, err := time.Parse("15:04", end); err != nil {
+		return fmt.Errorf("invalid quiet hours end %q", end)
+	}
+	return nil
+}
+
+// QuietUntil tがlocのstartからendまでの通知を控える時間帯に含まれる場合、時間帯の終わりを返す
+// endがstartより前の場合は日付をまたぐ時間帯とみなす
+func QuietUntil(start, end string, t time.Time, loc *time.Location) (time.Time, bool) {
+	s, err1 := time.Parse("15:04", start)
+	e, err2 := time.Parse("15:04", end)
+	if err1 != nil || err2 != nil {
+		return time.Time{}, false
+	}
+	sm, em := s.Hour()*60+s.Minute(), e.Hour()*60+e.Minute()
+	lt := t.In(loc)
+	m := lt.Hour()*60 + lt.Minute()
+	endOn := func(days int) time.Time {
+		return time.Date(lt.Year(), lt.Month(), lt.Day()+days, e.Hour(), e.Minute(), 0, 0, loc)
+	}
+	switch {
+	case sm < em && sm <= m && m < em:
+		return endOn(0), true
+	case sm > em && m >= sm:
+		return endOn(1), true
+	case sm > em && m < em:
+		return endOn(0), true
+	}
+	return time.Time{}, false
+}
+
+// Sent Recorderが受け取った通知
+type Sent struct {
+	Dest    string
+	Message Message
+}
+
+// Recorder 受け取った通知を記録するだけのChannel
+// 実際のChannelの代わりに動作確認に使う
+type Recorder struct {
+	// Err nilでなければSendはこのエラーを返す
+	Err error
+
+	mu   sync.Mutex
+	sent []Sent
+}
+
+// Validate 空でない宛先を受け付ける
+func (r *Recorder) Validate(dest string) error {
+	if dest == "" {
+		return errors.New("destination is empty")
+	}
+	return nil
+}
+
+// Send 通知を記録する
+func (r *Recorder) Send(dest string, m Message) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, Sent{dest, m})
+	return nil
+}
+
+// Sent これまでに記録した通知を返す
+func (r *Recorder) Sent() []Sent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Sent(nil), r.sent...)
+}
+
+// LogChannel 通知をログに出力するだけのChannel
+// 宛先の検証はNextがあればNextに任せる
+type LogChannel struct {
+	Name string
+	Next Channel
+}
+
+// Validate 宛先を検証する
+func (l *LogChannel) Validate(dest string) error {
+	if l.Next != nil {
+		return l.Next.Validate(dest)
+	}
+	if dest == "" {
+		return errors.New("destination is empty")
+	}
+	return nil
+}
+
+// Send 通知をログに出力する
+func (l *LogChannel) Send(dest string, m Message) error {
+	log.Printf("notify: %s to %s: [%s] %s: %s", l.Name, dest, m.Rule, m.Subject, m.Body)
+	return nil
+}
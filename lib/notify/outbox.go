@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+const (
+	// deliverBatch 1回のDeliverで送信する通知の数の上限
+	deliverBatch = 100
+	// maxErrorLength 保存するエラーメッセージの長さの上限
+	maxErrorLength = 400
+)
+
+// Outbox 送信待ちの通知を保存する
+type Outbox interface {
+	GetPendingNotifications(now time.Time, limit int) ([]model.Notification, error)
+	UpdateNotification(n model.Notification) error
+}
+
+// Deliver nowの時点で送信時刻になった通知をchannelsで送信し、結果を保存する
+// 失敗した通知はBackoffの後に再送し、MaxAttempts回失敗すると諦める
+// ctxが終了した場合は残りの通知を送らずに終える。送信した通知の数を返す
+func Deliver(ctx context.Context, ob Outbox, channels map[string]Channel, now time.Time) (int, error) {
+	ns, err := ob.GetPendingNotifications(now, deliverBatch)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, n := range ns {
+		if err := ctx.Err(); err != nil {
+			return sent, err
+		}
+		err := fmt.Errorf("channel %q is not available", n.Channel)
+		if ch, ok := channels[n.Channel]; ok {
+			err = ch.Send(n.Destination, Message{Rule: n.Rule, Subject: n.Subject, Body: n.Body})
+		} else {
+			err = Permanent(err)
+		}
+		n.Attempts++
+		switch {
+		case err == nil:
+			t := now
+			n.Status = model.NotificationStatusSent
+			n.Sent = &t
+			n.LastError = ""
+			sent++
+		case IsPermanent(err) || n.Attempts >= MaxAttempts:
+			n.Status = model.NotificationStatusFailed
+			n.LastError = errorText(err)
+		default:
+			n.NextAttempt = now.Add(Backoff(n.Attempts))
+			n.LastError = errorText(err)
+		}
+		if err := ob.UpdateNotification(n); err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+func errorText(err error) string {
+	s := err.Error()
+	if len(s) > maxErrorLength {
+		s = s[:maxErrorLength]
+	}
+	return s
+}
@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+)
+
+// memOutbox 送信待ちの通知をメモリに持つOutbox
+type memOutbox struct {
+	ns map[int64]model.Notification
+}
+
+func (o *memOutbox) GetPendingNotifications(now time.Time, limit int) ([]model.Notification, error) {
+	var ns []model.Notification
+	for id := int64(1); id <= int64(len(o.ns)); id++ {
+		n := o.ns[id]
+		if n.Status == model.NotificationStatusPending && !n.NextAttempt.After(now) && len(ns) < limit {
+			ns = append(ns, n)
+		}
+	}
+	return ns, nil
+}
+
+func (o *memOutbox) UpdateNotification(n model.Notification) error {
+	o.ns[n.Id] = n
+	return nil
+}
+
+func newMemOutbox(ns ...model.Notification) *memOutbox {
+	o := &memOutbox{ns: map[int64]model.Notification{}}
+	for i, n := range ns {
+		n.Id = int64(i + 1)
+		n.Status = model.NotificationStatusPending
+		if n.Destination == "" {
+			n.Destination = "owner@example.com"
+		}
+		o.ns[n.Id] = n
+	}
+	return o
+}
+
+func TestBackoff(t *testing.T) {
+	for _, tt := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{8, 128 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, maxBackoff},
+		{100, maxBackoff},
+	} {
+		if got := Backoff(tt.attempts); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	now := time.Date(2020, 5, 1, 9, 0, 0, 0, time.UTC)
+	ok := &Recorder{}
+	ob := newMemOutbox(
+		model.Notification{Channel: "ok", Subject: "sent"},
+		model.Notification{Channel: "missing", Subject: "no channel"},
+		model.Notification{Channel: "ok", Subject: "not yet", NextAttempt: now.Add(time.Minute)},
+	)
+	sent, err := Deliver(context.Background(), ob, map[string]Channel{"ok": ok}, now)
+	if err != nil || sent != 1 {
+		t.Fatalf("Deliver = %d, %v", sent, err)
+	}
+
+	if n := ob.ns[1]; n.Status != model.NotificationStatusSent || n.Attempts != 1 || n.Sent == nil || !n.Sent.Equal(now) {
+		t.Errorf("sent notification = %+v", n)
+	}
+	if got := ok.Sent(); len(got) != 1 || got[0].Dest != "owner@example.com" || got[0].Message.Subject != "sent" {
+		t.Errorf("channel received %+v", got)
+	}
+	if n := ob.ns[2]; n.Status != model.NotificationStatusFailed || n.LastError == "" {
+		t.Errorf("notification for a missing channel = %+v", n)
+	}
+	if n := ob.ns[3]; n.Status != model.NotificationStatusPending || n.Attempts != 0 {
+		t.Errorf("notification before its time = %+v", n)
+	}
+}
+
+func TestDeliverRetriesWithBackoff(t *testing.T) {
+	now := time.Date(2020, 5, 1, 9, 0, 0, 0, time.UTC)
+	ch := &Recorder{Err: errors.New("421 service not available")}
+	channels := map[string]Channel{"smtp": ch}
+	ob := newMemOutbox(model.Notification{Channel: "smtp", NextAttempt: now})
+
+	for attempt := 1; attempt < MaxAttempts; attempt++ {
+		if sent, err := Deliver(context.Background(), ob, channels, now); err != nil || sent != 0 {
+			t.Fatalf("attempt %d: Deliver = %d, %v", attempt, sent, err)
+		}
+		n := ob.ns[1]
+		if n.Status != model.NotificationStatusPending || n.Attempts != attempt || n.LastError != ch.Err.Error() {
+			t.Fatalf("attempt %d: notification = %+v", attempt, n)
+		}
+		if want := now.Add(Backoff(attempt)); !n.NextAttempt.Equal(want) {
+			t.Fatalf("attempt %d: next attempt = %v, want %v", attempt, n.NextAttempt, want)
+		}
+		// nothing is sent again before the backoff has passed.
+		if _, err := Deliver(context.Background(), ob, channels, n.NextAttempt.Add(-time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		if ob.ns[1].Attempts != attempt {
+			t.Fatalf("attempt %d: retried before the backoff", attempt)
+		}
+		now = n.NextAttempt
+	}
+
+	if _, err := Deliver(context.Background(), ob, channels, now); err != nil {
+		t.Fatal(err)
+	}
+	if n := ob.ns[1]; n.Status != model.NotificationStatusFailed || n.Attempts != MaxAttempts {
+		t.Errorf("after %d attempts: notification = %+v", MaxAttempts, n)
+	}
+}
+
+func TestDeliverGivesUpOnPermanentError(t *testing.T) {
+	now := time.Date(2020, 5, 1, 9, 0, 0, 0, time.UTC)
+	channels := map[string]Channel{"push": &Recorder{Err: Permanent(errors.New("subscription expired"))}}
+	ob := newMemOutbox(model.Notification{Channel: "push", NextAttempt: now})
+
+	if _, err := Deliver(context.Background(), ob, channels, now); err != nil {
+		t.Fatal(err)
+	}
+	if n := ob.ns[1]; n.Status != model.NotificationStatusFailed || n.Attempts != 1 || n.LastError != "subscription expired" {
+		t.Errorf("notification = %+v", n)
+	}
+}
+
+func TestDeliverStopsWhenCanceled(t *testing.T) {
+	now := time.Date(2020, 5, 1, 9, 0, 0, 0, time.UTC)
+	ch := &Recorder{}
+	ob := newMemOutbox(model.Notification{Channel: "smtp"}, model.Notification{Channel: "smtp"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	sent, err := Deliver(ctx, ob, map[string]Channel{"smtp": ch}, now)
+	if err != context.Canceled || sent != 0 || len(ch.Sent()) != 0 {
+		t.Errorf("Deliver = %d, %v; sent %d", sent, err, len(ch.Sent()))
+	}
+	if n := ob.ns[1]; n.Status != model.NotificationStatusPending || n.Attempts != 0 {
+		t.Errorf("notification = %+v", n)
+	}
+}
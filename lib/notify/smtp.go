@@ -0,0 +1,65 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+// SMTPChannel 通知をメールで送るChannel
+// 宛先はメールアドレス
+type SMTPChannel struct {
+	// Addr SMTPサーバの "host:port"
+	Addr string
+	From string
+	// Username 空の場合は認証しない
+	Username string
+	Password string
+}
+
+// Validate destがメールアドレスか検証する
+func (s *SMTPChannel) Validate(dest string) error {
+	a, err := mail.ParseAddress(dest)
+	if err != nil || a.Name != "" || a.Address != dest {
+		return errors.New("invalid email address")
+	}
+	return nil
+}
+
+// Send destへメールを送る
+func (s *SMTPChannel) Send(dest string, m Message) error {
+	if err := s.Validate(dest); err != nil {
+		return Permanent(err)
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return Permanent(err)
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", dest)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+	body := base64.StdEncoding.EncodeToString([]byte(m.Body))
+	for len(body) > 76 {
+		msg.WriteString(body[:76] + "\r\n")
+		body = body[76:]
+	}
+	msg.WriteString(body + "\r\n")
+
+	return smtp.SendMail(s.Addr, auth, s.From, []string{dest}, msg.Bytes())
+}
@@ -0,0 +1,161 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// smtpStub 1通ずつメールを受け取るSMTPサーバ
+// rejectRcptが空でなければRCPT TOにその応答を返す
+type smtpStub struct {
+	ln         net.Listener
+	rejectRcpt string
+
+	mu   sync.Mutex
+	auth []string
+	from []string
+	rcpt []string
+	data []string
+}
+
+func newSMTPStub(t *testing.T, rejectRcpt string) *smtpStub {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStub{ln: ln, rejectRcpt: rejectRcpt}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStub) Close() { s.ln.Close() }
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP stub")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		s.mu.Lock()
+		switch verb {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			s.auth = append(s.auth, line)
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL":
+			s.from = append(s.from, line)
+			reply("250 OK")
+		case "RCPT":
+			if s.rejectRcpt != "" {
+				reply(s.rejectRcpt)
+				break
+			}
+			s.rcpt = append(s.rcpt, line)
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					s.mu.Unlock()
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.data = append(s.data, data.String())
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			s.mu.Unlock()
+			return
+		default:
+			reply("250 OK")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func TestSMTPChannelSend(t *testing.T) {
+	stub := newSMTPStub(t, "")
+	defer stub.Close()
+
+	ch := &SMTPChannel{Addr: stub.ln.Addr().String(), From: "meowapi@example.com", Username: "user", Password: "pass"}
+	m := Message{Rule: "litter_low", Subject: "砂が少なくなりました", Body: "残り3日分です"}
+	if err := ch.Send("owner@example.com", m); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	wantAuth := "AUTH PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00user\x00pass"))
+	if len(stub.auth) != 1 || stub.auth[0] != wantAuth {
+		t.Errorf("auth = %q, want %q", stub.auth, wantAuth)
+	}
+	if len(stub.from) != 1 || stub.from[0] != "MAIL FROM:<meowapi@example.com>" {
+		t.Errorf("from = %q", stub.from)
+	}
+	if len(stub.rcpt) != 1 || stub.rcpt[0] != "RCPT TO:<owner@example.com>" {
+		t.Errorf("rcpt = %q", stub.rcpt)
+	}
+	if len(stub.data) != 1 {
+		t.Fatalf("received %d messages", len(stub.data))
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(stub.data[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != m.Subject {
+		t.Errorf("subject = %q, %v", subject, err)
+	}
+	if to := msg.Header.Get("To"); to != "owner@example.com" {
+		t.Errorf("to = %q", to)
+	}
+	body, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, msg.Body))
+	if err != nil || string(body) != m.Body {
+		t.Errorf("body = %q, %v", body, err)
+	}
+}
+
+func TestSMTPChannelSendErrors(t *testing.T) {
+	stub := newSMTPStub(t, "550 5.1.1 No such user")
+	defer stub.Close()
+
+	ch := &SMTPChannel{Addr: stub.ln.Addr().String(), From: "meowapi@example.com"}
+	err := ch.Send("nobody@example.com", Message{Subject: "s", Body: "b"})
+	if err == nil || !strings.Contains(err.Error(), "No such user") {
+		t.Errorf("Send to a rejected recipient: err = %v", err)
+	}
+
+	err = ch.Send("Owner <owner@example.com>", Message{Subject: "s", Body: "b"})
+	if !IsPermanent(err) {
+		t.Errorf("Send to an invalid address: err = %v, want a permanent error", err)
+	}
+}
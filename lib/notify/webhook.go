@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/safehttp"
+)
+
+// WebhookChannel 通知をJSONでURLへPOSTするChannel
+// 宛先は内部のネットワークを指さないhttpまたはhttpsのURL
+type WebhookChannel struct {
+	// Client nilの場合は内部のネットワークへ接続しないタイムアウト10秒のclientを使う
+	Client *http.Client
+}
+
+var defaultClient = safehttp.NewClient(10 * time.Second)
+
+// checkHost 宛先のホストを検証する。テストではローカルのサーバを許可するために差し替える
+var checkHost = safehttp.CheckHost
+
+// Validate destが内部のネットワークを指さないhttpまたはhttpsのURLか検証する
+func (w *WebhookChannel) Validate(dest string) error {
+	return validateURL(dest)
+}
+
+// Send destへ通知をPOSTする
+func (w *WebhookChannel) Send(dest string, m Message) error {
+	if err := w.Validate(dest); err != nil {
+		return Permanent(err)
+	}
+	body, err := json.Marshal(struct {
+		Message
+		Sent time.Time `json:"sent"`
+	}{m, time.Now()})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, dest, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doRequest(w.Client, req)
+}
+
+func validateURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("invalid url")
+	}
+	// hosts which can not be resolved now are checked again when connecting.
+	if err := checkHost(u.Hostname()); err == safehttp.ErrForbiddenAddress {
+		return err
+	}
+	return nil
+}
+
+// doRequest reqを送り、2xx以外の応答をエラーにする
+// 4xx(408と429を除く)は再送しても成功しないエラーとする
+func doRequest(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = defaultClient
+	}
+	req.Header.Set("User-Agent", "meowapi")
+	res, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, safehttp.ErrForbiddenAddress) {
+			return Permanent(safehttp.ErrForbiddenAddress)
+		}
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("%s responded %s", req.URL.Host, res.Status)
+	if res.StatusCode >= 400 && res.StatusCode < 500 &&
+		res.StatusCode != http.StatusRequestTimeout && res.StatusCode != http.StatusTooManyRequests {
+		return Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/greytabby/meowapi/lib/safehttp"
+)
+
+// allowLocalHosts 宛先の検証でローカルのテストサーバを許可する。戻すための関数を返す
+func allowLocalHosts() func() {
+	checkHost = func(string) error { return nil }
+	return func() { checkHost = safehttp.CheckHost }
+}
+
+func TestWebhookChannelSend(t *testing.T) {
+	defer allowLocalHosts()()
+	var got Message
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	m := Message{Rule: "toilet_due", Subject: "掃除の時間", Body: "トイレの砂を全て入れ替えてください"}
+	if err := (&WebhookChannel{Client: srv.Client()}).Send(srv.URL, m); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got != m {
+		t.Errorf("received %+v", got)
+	}
+}
+
+func TestWebhookChannelRefusesInternalAddress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached an internal address")
+	}))
+	defer srv.Close()
+
+	ch := &WebhookChannel{}
+	for _, dest := range []string{srv.URL, "http://169.254.169.254/latest/meta-data", "http://10.0.0.1/", "http://[::1]/"} {
+		if err := ch.Validate(dest); err == nil {
+			t.Errorf("Validate(%q) accepted an internal address", dest)
+		}
+	}
+	if err := ch.Validate("https://hooks.example.com/meow"); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	if err := ch.Send(srv.URL, Message{Subject: "s"}); !IsPermanent(err) {
+		t.Errorf("Send: err = %v, want a permanent error", err)
+	}
+}
@@ -0,0 +1,219 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// webPushTTL push serviceが通知を保持する秒数
+	webPushTTL = 24 * 60 * 60
+	// webPushRecordSize aes128gcmのレコードサイズ
+	webPushRecordSize = 4096
+)
+
+var b64 = base64.RawURLEncoding
+
+// Subscription ブラウザのPushSubscriptionをJSONにしたもの
+type Subscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// WebPushChannel 通知をWeb Push(RFC 8030, 8291, 8292)で送るChannel
+// 宛先はSubscriptionのJSON
+type WebPushChannel struct {
+	// Key VAPIDの秘密鍵(P-256)
+	Key *ecdsa.PrivateKey
+	// Subject VAPIDのsub ("mailto:" または "https:" のURL)
+	Subject string
+	// Client nilの場合は内部のネットワークへ接続しないタイムアウト10秒のclientを使う
+	Client *http.Client
+}
+
+// ParseVAPIDKey base64url形式の32バイトの秘密鍵を読み込む
+func ParseVAPIDKey(s string) (*ecdsa.PrivateKey, error) {
+	d, err := b64.DecodeString(s)
+	if err != nil || len(d) != 32 {
+		return nil, errors.New("vapid private key must be 32 bytes in base64url")
+	}
+	curve := elliptic.P256()
+	k := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	k.PublicKey.Curve = curve
+	k.PublicKey.X, k.PublicKey.Y = curve.ScalarBaseMult(d)
+	return k, nil
+}
+
+// PublicKey ブラウザがsubscribeする際のapplicationServerKeyを返す
+func (w *WebPushChannel) PublicKey() string {
+	return b64.EncodeToString(elliptic.Marshal(w.Key.Curve, w.Key.X, w.Key.Y))
+}
+
+// Validate destがSubscriptionのJSONか検証する
+func (w *WebPushChannel) Validate(dest string) error {
+	_, _, _, err := parseSubscription(dest)
+	return err
+}
+
+func parseSubscription(dest string) (Subscription, []byte, []byte, error) {
+	var sub Subscription
+	if err := json.Unmarshal([]byte(dest), &sub); err != nil {
+		return sub, nil, nil, errors.New("invalid subscription")
+	}
+	if err := validateURL(sub.Endpoint); err != nil {
+		return sub, nil, nil, errors.New("invalid subscription endpoint")
+	}
+	pub, err := b64.DecodeString(sub.Keys.P256dh)
+	if err != nil {
+		return sub, nil, nil, errors.New("invalid subscription p256dh")
+	}
+	if x, _ := elliptic.Unmarshal(elliptic.P256(), pub); x == nil {
+		return sub, nil, nil, errors.New("invalid subscription p256dh")
+	}
+	auth, err := b64.DecodeString(sub.Keys.Auth)
+	if err != nil || len(auth) != 16 {
+		return sub, nil, nil, errors.New("invalid subscription auth")
+	}
+	return sub, pub, auth, nil
+}
+
+// Send destのsubscriptionへ通知を暗号化して送る
+// subscriptionが無効になっている場合(404, 410)は再送しない
+func (w *WebPushChannel) Send(dest string, m Message) error {
+	sub, pub, auth, err := parseSubscription(dest)
+	if err != nil {
+		return Permanent(err)
+	}
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	body, err := encryptPayload(payload, pub, auth)
+	if err != nil {
+		return err
+	}
+	token, err := w.vapidToken(sub.Endpoint, time.Now().Add(12*time.Hour))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(webPushTTL))
+	req.Header.Set("Authorization", "vapid t="+token+", k="+w.PublicKey())
+	return doRequest(w.Client, req)
+}
+
+// vapidToken endpointのoriginに対するES256のJWTを作る (RFC 8292)
+func (w *WebPushChannel) vapidToken(endpoint string, exp time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header := b64.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": exp.Unix(),
+		"sub": w.Subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	input := header + "." + b64.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, w.Key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	sig := append(padded(r, 32), padded(s, 32)...)
+	return input + "." + b64.EncodeToString(sig), nil
+}
+
+// encryptPayload plaintextを受信者の公開鍵uaPublicと認証秘密authで暗号化する (RFC 8291)
+func encryptPayload(plaintext, uaPublic, auth []byte) ([]byte, error) {
+	curve := elliptic.P256()
+	ux, uy := elliptic.Unmarshal(curve, uaPublic)
+	if ux == nil {
+		return nil, errors.New("invalid public key")
+	}
+	as, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := elliptic.Marshal(curve, as.X, as.Y)
+	sx, _ := curve.ScalarMult(ux, uy, as.D.Bytes())
+	secret := padded(sx, 32)
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := hkdf(auth, secret, keyInfo, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// a single record terminated by the last record delimiter.
+	record := append(append([]byte{}, plaintext...), 0x02)
+	if len(record)+gcm.Overhead() > webPushRecordSize {
+		return nil, Permanent(errors.New("payload is too large"))
+	}
+
+	header := make([]byte, 16+4+1)
+	copy(header, salt)
+	binary.BigEndian.PutUint32(header[16:], webPushRecordSize)
+	header[20] = byte(len(asPublic))
+	header = append(header, asPublic...)
+	return gcm.Seal(header, nonce, record, nil), nil
+}
+
+// hkdf HKDF-SHA256で長さlength(32以下)の鍵を導出する
+func hkdf(salt, ikm, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	prk := extract.Sum(nil)
+	expand := hmac.New(sha256.New, prk)
+	expand.Write(info)
+	expand.Write([]byte{0x01})
+	return expand.Sum(nil)[:length]
+}
+
+// padded nを先頭を0で埋めたsizeバイトのbig-endianで返す
+func padded(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out
+}
@@ -0,0 +1,198 @@
+package notify
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// pushReceiver ブラウザの代わりにsubscriptionの鍵を持ち、届いた通知を復号する
+type pushReceiver struct {
+	key  *ecdsa.PrivateKey
+	auth []byte
+}
+
+func newPushReceiver(t *testing.T) *pushReceiver {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	return &pushReceiver{key: key, auth: auth}
+}
+
+func (p *pushReceiver) subscription(endpoint string) string {
+	var sub Subscription
+	sub.Endpoint = endpoint
+	sub.Keys.P256dh = b64.EncodeToString(elliptic.Marshal(elliptic.P256(), p.key.X, p.key.Y))
+	sub.Keys.Auth = b64.EncodeToString(p.auth)
+	b, _ := json.Marshal(sub)
+	return string(b)
+}
+
+// decrypt aes128gcmで暗号化された1レコードのbodyを復号する (RFC 8291)
+func (p *pushReceiver) decrypt(body []byte) ([]byte, error) {
+	curve := elliptic.P256()
+	salt := body[:16]
+	idlen := int(body[20])
+	asPublic := body[21 : 21+idlen]
+	if rs := binary.BigEndian.Uint32(body[16:20]); rs != webPushRecordSize {
+		return nil, fmt.Errorf("record size %d", rs)
+	}
+	ax, ay := elliptic.Unmarshal(curve, asPublic)
+	sx, _ := curve.ScalarMult(ax, ay, p.key.D.Bytes())
+	uaPublic := elliptic.Marshal(curve, p.key.X, p.key.Y)
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := hkdf(p.auth, padded(sx, 32), keyInfo, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	record, err := gcm.Open(nil, nonce, body[21+idlen:], nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(record) == 0 || record[len(record)-1] != 0x02 {
+		return nil, fmt.Errorf("missing record delimiter")
+	}
+	return record[:len(record)-1], nil
+}
+
+// verifyVAPID Authorizationヘッダのvapidのtokenをヘッダの公開鍵で検証し、claimsを返す (RFC 8292)
+func verifyVAPID(t *testing.T, header string) map[string]interface{} {
+	if !strings.HasPrefix(header, "vapid t=") {
+		t.Fatalf("Authorization = %q", header)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(header, "vapid t="), ", k=", 2)
+	if len(parts) != 2 {
+		t.Fatalf("Authorization = %q", header)
+	}
+	token, k := parts[0], parts[1]
+	pub, err := b64.DecodeString(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), pub)
+	seg := strings.Split(token, ".")
+	if x == nil || len(seg) != 3 {
+		t.Fatalf("invalid vapid token %q", header)
+	}
+	sig, err := b64.DecodeString(seg[2])
+	if err != nil || len(sig) != 64 {
+		t.Fatalf("invalid vapid signature %q", seg[2])
+	}
+	digest := sha256.Sum256([]byte(seg[0] + "." + seg[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, digest[:], r, s) {
+		t.Fatal("vapid signature mismatch")
+	}
+	claims := map[string]interface{}{}
+	b, _ := b64.DecodeString(seg[1])
+	if err := json.Unmarshal(b, &claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func newWebPushChannel(t *testing.T, client *http.Client) *WebPushChannel {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &WebPushChannel{Key: key, Subject: "mailto:admin@example.com", Client: client}
+}
+
+func TestWebPushChannelSend(t *testing.T) {
+	defer allowLocalHosts()()
+	ua := newPushReceiver(t)
+	var got []byte
+	var claims map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "aes128gcm" || r.Header.Get("TTL") == "" {
+			t.Errorf("headers = %v", r.Header)
+		}
+		claims = verifyVAPID(t, r.Header.Get("Authorization"))
+		body, _ := ioutil.ReadAll(r.Body)
+		var err error
+		if got, err = ua.decrypt(body); err != nil {
+			t.Errorf("decrypt: %v", err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	ch := newWebPushChannel(t, srv.Client())
+	m := Message{Rule: "medication_due", Subject: "お薬の時間", Body: "タマにお薬をあげてください"}
+	if err := ch.Send(ua.subscription(srv.URL+"/push/abc"), m); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	var sent Message
+	if err := json.Unmarshal(got, &sent); err != nil || sent != m {
+		t.Errorf("received %q, %v", got, err)
+	}
+	if claims["aud"] != srv.URL || claims["sub"] != ch.Subject {
+		t.Errorf("claims = %v", claims)
+	}
+}
+
+func TestWebPushChannelResponses(t *testing.T) {
+	defer allowLocalHosts()()
+	ua := newPushReceiver(t)
+	for _, tt := range []struct {
+		status    int
+		wantErr   bool
+		permanent bool
+	}{
+		{http.StatusCreated, false, false},
+		{http.StatusGone, true, true},
+		{http.StatusNotFound, true, true},
+		{http.StatusTooManyRequests, true, false},
+		{http.StatusInternalServerError, true, false},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+		}))
+		err := newWebPushChannel(t, srv.Client()).Send(ua.subscription(srv.URL), Message{Subject: "s"})
+		srv.Close()
+		if (err != nil) != tt.wantErr || IsPermanent(err) != tt.permanent {
+			t.Errorf("status %d: err = %v, permanent %v", tt.status, err, IsPermanent(err))
+		}
+	}
+}
+
+func TestWebPushChannelRefusesInternalEndpoint(t *testing.T) {
+	ua := newPushReceiver(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached an internal endpoint")
+	}))
+	defer srv.Close()
+
+	ch := newWebPushChannel(t, nil)
+	if err := ch.Validate(ua.subscription(srv.URL)); err == nil {
+		t.Error("Validate accepted a loopback endpoint")
+	}
+	if err := ch.Send(ua.subscription(srv.URL), Message{Subject: "s"}); !IsPermanent(err) {
+		t.Errorf("Send: err = %v, want a permanent error", err)
+	}
+}
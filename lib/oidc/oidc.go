@@ -0,0 +1,331 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// ErrInvalidIDToken id_tokenの検証に失敗した
+var ErrInvalidIDToken = errors.New("oidc: invalid id token")
+
+// Provider OpenID Connect の Identity Provider
+// IssuerURLからdiscoveryでエンドポイントを取得する
+type Provider struct {
+	Name         string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// Client IdPへのリクエストに使うhttp.Client(nilの場合はhttp.DefaultClient)
+	Client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]*rsa.PublicKey
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+// Claims id_tokenから取得するユーザの情報
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// Valid jwt.Claimsの実装。有効期限のみ確認し、それ以外はVerifyIDTokenで確認する
+func (c *Claims) Valid() error {
+	if c.ExpiresAt == 0 || time.Now().Unix() > c.ExpiresAt {
+		return errors.New("token is expired")
+	}
+	return nil
+}
+
+// audience "aud" は文字列と文字列の配列のどちらの場合もある
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthRequest 認可リクエストの開始時に生成し、callbackまで保持する値
+type AuthRequest struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewAuthRequest state, nonce, PKCEのcode_verifierを生成する
+func NewAuthRequest() (AuthRequest, error) {
+	var ar AuthRequest
+	var err error
+	if ar.State, err = randomString(24); err != nil {
+		return AuthRequest{}, err
+	}
+	if ar.Nonce, err = randomString(24); err != nil {
+		return AuthRequest{}, err
+	}
+	if ar.Verifier, err = randomString(32); err != nil {
+		return AuthRequest{}, err
+	}
+	return ar, nil
+}
+
+// AuthURL IdPの認可エンドポイントへのURLを返す
+func (p *Provider) AuthURL(ctx context.Context, ar AuthRequest) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(ar.Verifier))
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("scope", strings.Join(p.scopes(), " "))
+	v.Set("state", ar.State)
+	v.Set("nonce", ar.Nonce)
+	v.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange 認可コードをトークンに交換し、id_tokenを検証してClaimsを返す
+func (p *Provider) Exchange(ctx context.Context, code string, ar AuthRequest) (Claims, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("client_id", p.ClientID)
+	v.Set("code_verifier", ar.Verifier)
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	var tr struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.do(req.WithContext(ctx), &tr); err != nil {
+		return Claims{}, err
+	}
+	if tr.IDToken == "" {
+		return Claims{}, errors.New("oidc: token response has no id_token")
+	}
+	return p.VerifyIDToken(ctx, tr.IDToken, ar.Nonce)
+}
+
+// VerifyIDToken id_tokenの署名、issuer、audience、nonce、有効期限を検証する
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (Claims, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	var claims Claims
+	_, err = jwt.ParseWithClaims(raw, &claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, d, kid)
+	})
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if claims.Issuer != d.Issuer {
+		return Claims{}, fmt.Errorf("%w: unexpected issuer %s", ErrInvalidIDToken, claims.Issuer)
+	}
+	if !claims.Audience.contains(p.ClientID) {
+		return Claims{}, fmt.Errorf("%w: unexpected audience", ErrInvalidIDToken)
+	}
+	if claims.Nonce != nonce {
+		return Claims{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	if claims.Subject == "" {
+		return Claims{}, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+func (p *Provider) scopes() []string {
+	if len(p.Scopes) == 0 {
+		return []string{"openid", "profile", "email"}
+	}
+	return p.Scopes
+}
+
+// discover discoveryの結果を返す。取得済みの場合はキャッシュを返す
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	d := p.discovery
+	p.mu.Unlock()
+	if d != nil {
+		return d, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet,
+		strings.TrimSuffix(p.IssuerURL, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	d = &discovery{}
+	if err := p.do(req.WithContext(ctx), d); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != strings.TrimSuffix(p.IssuerURL, "/") {
+		return nil, fmt.Errorf("oidc: issuer mismatch, expected %s got %s", p.IssuerURL, d.Issuer)
+	}
+
+	p.mu.Lock()
+	p.discovery = d
+	p.mu.Unlock()
+	return d, nil
+}
+
+// key kidに対応する公開鍵を返す。未知のkidの場合はjwksを取得し直す
+func (p *Provider) key(ctx context.Context, d *discovery, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	k, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, d.JwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.do(req.WithContext(ctx), &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	k, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("oidc: unknown key id %q", kid)
+	}
+	return k, nil
+}
+
+// do リクエストを送信し、JSONのレスポンスをvにデコードする
+func (p *Provider) do(req *http.Request, v interface{}) error {
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s %s returned %d: %s", req.Method, req.URL, res.StatusCode, body)
+	}
+	return json.Unmarshal(body, v)
+}
+
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
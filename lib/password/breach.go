@@ -0,0 +1,91 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// BreachChecker 漏洩済みパスワードを照合する
+type BreachChecker interface {
+	// Breached pwが漏洩データに現れた回数を返す
+	Breached(pw string) (int, error)
+}
+
+// prefixLength k-anonymity range のprefixの長さ(Have I Been Pwned と同じ)
+const prefixLength = 5
+
+// BreachList Have I Been Pwned 形式の SHA-1 ハッシュリストをメモリ上に保持する
+// ハッシュは先頭5文字のprefixごとのrangeに分けて保持し、照合時もrange単位で参照する
+type BreachList struct {
+	ranges map[string]map[string]int
+}
+
+// LoadBreachListFile pathのファイルからBreachListを読み込む
+func LoadBreachListFile(path string) (*BreachList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadBreachList(f)
+}
+
+// LoadBreachList rからBreachListを読み込む
+// 1行に "<SHA-1 40文字>:<出現回数>" を記述する。出現回数は省略できる
+func LoadBreachList(r io.Reader) (*BreachList, error) {
+	bl := &BreachList{ranges: map[string]map[string]int{}}
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		hash, count := text, 1
+		if i := strings.IndexByte(text, ':'); i >= 0 {
+			hash = text[:i]
+			n, err := strconv.Atoi(text[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("breach list line %d: %v", line, err)
+			}
+			count = n
+		}
+		if len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("breach list line %d: invalid hash", line)
+		}
+		bl.add(strings.ToUpper(hash), count)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return bl, nil
+}
+
+func (bl *BreachList) add(hash string, count int) {
+	prefix, suffix := hash[:prefixLength], hash[prefixLength:]
+	rng, ok := bl.ranges[prefix]
+	if !ok {
+		rng = map[string]int{}
+		bl.ranges[prefix] = rng
+	}
+	rng[suffix] += count
+}
+
+// Range prefixに一致するハッシュのsuffixと出現回数を返す
+func (bl *BreachList) Range(prefix string) map[string]int {
+	return bl.ranges[strings.ToUpper(prefix)]
+}
+
+// Breached pwが漏洩データに現れた回数を返す
+func (bl *BreachList) Breached(pw string) (int, error) {
+	sum := sha1.Sum([]byte(pw))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return bl.Range(hash[:prefixLength])[hash[prefixLength:]], nil
+}
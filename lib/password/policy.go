@@ -0,0 +1,72 @@
+package password
+
+import (
+	"fmt"
+)
+
+// Violation パスワードがPolicyを満たさない理由
+type Violation struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Violation codes
+const (
+	CodeRequired = "required"
+	CodeTooShort = "too_short"
+	CodeTooLong  = "too_long"
+	CodeTooWeak  = "too_weak"
+	CodeBreached = "breached"
+)
+
+// bcrypt ignores bytes after the 72nd.
+const bcryptMaxLength = 72
+
+// Policy パスワードの要件
+type Policy struct {
+	// MinLength 最小の文字数
+	MinLength int
+	// MinScore Strengthで推定した強度(0-4)の最小値
+	MinScore int
+	// Breached 漏洩済みパスワードの照合先(nilの場合は照合しない)
+	Breached BreachChecker
+}
+
+// DefaultPolicy 設定が無い場合のPolicy
+var DefaultPolicy = Policy{
+	MinLength: 8,
+	MinScore:  2,
+}
+
+// Validate pwがPolicyを満たすか検証し、満たさない理由を全て返す
+// userInputsにはユーザ名等、パスワードに含まれるべきでない文字列を渡す
+func (p Policy) Validate(pw string, userInputs ...string) ([]Violation, error) {
+	var vs []Violation
+	if pw == "" {
+		return []Violation{{"password", CodeRequired, "password is required"}}, nil
+	}
+	if len([]rune(pw)) < p.MinLength {
+		vs = append(vs, Violation{"password", CodeTooShort,
+			fmt.Sprintf("password must be at least %d characters", p.MinLength)})
+	}
+	if len(pw) > bcryptMaxLength {
+		vs = append(vs, Violation{"password", CodeTooLong,
+			fmt.Sprintf("password must be %d bytes or less", bcryptMaxLength)})
+	}
+	if s := Strength(pw, userInputs...); s.Score < p.MinScore {
+		vs = append(vs, Violation{"password", CodeTooWeak,
+			fmt.Sprintf("password is too easy to guess (score %d, required %d)", s.Score, p.MinScore)})
+	}
+	if p.Breached != nil {
+		n, err := p.Breached.Breached(pw)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			vs = append(vs, Violation{"password", CodeBreached,
+				"password has appeared in a data breach"})
+		}
+	}
+	return vs, nil
+}
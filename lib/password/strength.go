@@ -0,0 +1,202 @@
+package password
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Estimate パスワード強度の推定結果
+type Estimate struct {
+	// Guesses 推測に必要な試行回数の推定値(log10)
+	Guesses float64 `json:"guesses_log10"`
+	// Score zxcvbnと同じ0-4の強度
+	Score int `json:"score"`
+}
+
+// commonWords よく使われるパスワードと単語
+// 順位が高いほど推測されやすいものとして扱う
+var commonWords = []string{
+	"password", "123456", "qwerty", "letmein", "welcome", "admin", "login",
+	"iloveyou", "monkey", "dragon", "master", "sunshine", "princess", "football",
+	"baseball", "shadow", "superman", "michael", "trustno1", "hello", "freedom",
+	"whatever", "starwars", "computer", "secret", "summer", "winter", "spring",
+	"autumn", "love", "test", "pass", "user", "root", "guest", "changeme",
+	"cat", "cats", "kitty", "kitten", "meow", "neko", "tama", "mike", "toilet",
+	"litter", "meowapi", "dog", "puppy", "pokemon", "naruto", "abc", "qwe",
+}
+
+var keyboardRows = []string{
+	"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./",
+}
+
+var leet = strings.NewReplacer("4", "a", "@", "a", "3", "e", "1", "i", "!", "i",
+	"0", "o", "$", "s", "5", "s", "7", "t", "+", "t")
+
+// Strength zxcvbnと同様の考え方でパスワードの推測されにくさを推定する
+// 辞書の単語、繰り返し、連番、キーボード配列をそれぞれ1つの塊とみなし、
+// 塊ごとの推測回数の積を全体の推測回数とする
+func Strength(pw string, userInputs ...string) Estimate {
+	rs := []rune(pw)
+	card := math.Log10(float64(cardinality(rs)))
+	lower := []rune(strings.ToLower(pw))
+	normalized := []rune(leet.Replace(strings.ToLower(pw)))
+
+	words := make([]string, 0, len(commonWords)+len(userInputs))
+	words = append(words, commonWords...)
+	for _, in := range userInputs {
+		if len([]rune(in)) >= 3 {
+			words = append(words, strings.ToLower(in))
+		}
+	}
+
+	var guesses float64
+	for i := 0; i < len(rs); {
+		n, g := longestPattern(lower, normalized, i, words, card)
+		if hasUpper(rs[i : i+n]) {
+			// capitalization roughly doubles the guesses
+			g += math.Log10(2)
+		}
+		guesses += g
+		i += n
+	}
+
+	return Estimate{Guesses: guesses, Score: score(guesses)}
+}
+
+// longestPattern i文字目から始まる最も長い塊の長さと推測回数(log10)を返す
+func longestPattern(lower, normalized []rune, i int, words []string, card float64) (int, float64) {
+	n, g := 1, card
+
+	for rank, w := range words {
+		wr := []rune(w)
+		if len(wr) <= n || i+len(wr) > len(lower) {
+			continue
+		}
+		seg := string(normalized[i : i+len(wr)])
+		if seg == w || string(lower[i:i+len(wr)]) == w {
+			n, g = len(wr), math.Log10(float64(rank+2))
+		}
+	}
+
+	if l := repeatLength(lower, i); l >= 3 && l > n {
+		n, g = l, card+math.Log10(float64(l))
+	}
+	if l := sequenceLength(lower, i); l >= 3 && l > n {
+		n, g = l, math.Log10(26)+math.Log10(float64(l))
+	}
+	if l := keyboardLength(lower, i); l >= 4 && l > n {
+		n, g = l, math.Log10(float64(len(keyboardRows)*10))+math.Log10(float64(l))
+	}
+	return n, g
+}
+
+// repeatLength i文字目から同じ文字が続く長さ
+func repeatLength(rs []rune, i int) int {
+	j := i + 1
+	for j < len(rs) && rs[j] == rs[i] {
+		j++
+	}
+	return j - i
+}
+
+// sequenceLength i文字目から abc, 321 のように文字コードが1ずつ変化する長さ
+func sequenceLength(rs []rune, i int) int {
+	if i+1 >= len(rs) {
+		return 1
+	}
+	d := rs[i+1] - rs[i]
+	if d != 1 && d != -1 {
+		return 1
+	}
+	j := i + 1
+	for j < len(rs) && rs[j]-rs[j-1] == d {
+		j++
+	}
+	return j - i
+}
+
+// keyboardLength i文字目からキーボードの同じ列を順に辿る長さ
+func keyboardLength(rs []rune, i int) int {
+	best := 1
+	for _, row := range keyboardRows {
+		rr := []rune(row)
+		for k := range rr {
+			if rr[k] != rs[i] {
+				continue
+			}
+			l := 1
+			for i+l < len(rs) && k+l < len(rr) && rr[k+l] == rs[i+l] {
+				l++
+			}
+			if l > best {
+				best = l
+			}
+		}
+	}
+	return best
+}
+
+// cardinality 使われている文字種から1文字あたりの候補数を求める
+func cardinality(rs []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range rs {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	n := 0
+	if lower {
+		n += 26
+	}
+	if upper {
+		n += 26
+	}
+	if digit {
+		n += 10
+	}
+	if symbol {
+		n += 33
+	}
+	if other {
+		n += 100
+	}
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+func hasUpper(rs []rune) bool {
+	for _, r := range rs {
+		if unicode.IsUpper(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// score zxcvbnの閾値で推測回数を0-4の強度に変換する
+func score(guesses float64) int {
+	switch {
+	case guesses < 3:
+		return 0
+	case guesses < 6:
+		return 1
+	case guesses < 8:
+		return 2
+	case guesses < 10:
+		return 3
+	default:
+		return 4
+	}
+}
@@ -0,0 +1,136 @@
+package photo
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/webp"
+)
+
+// Content types
+const (
+	TypeJPEG = "image/jpeg"
+	TypePNG  = "image/png"
+	TypeWebP = "image/webp"
+)
+
+const (
+	// ThumbnailSize サムネイルの長辺(px)
+	ThumbnailSize = 320
+	// MaxPixels 受け付ける画像の最大画素数
+	MaxPixels = 50 * 1000 * 1000
+
+	thumbnailQuality = 80
+	reencodeQuality  = 92
+)
+
+var (
+	// ErrUnsupportedType JPEG, PNG, WebP以外の画像
+	ErrUnsupportedType = errors.New("unsupported image type")
+	// ErrTooLarge 画素数がMaxPixelsを超える画像
+	ErrTooLarge = errors.New("image is too large")
+)
+
+// Result Processの結果
+type Result struct {
+	// Data メタデータを取り除いた画像
+	Data        []byte
+	ContentType string
+	// Thumbnail JPEGのサムネイル
+	Thumbnail []byte
+	Width     int
+	Height    int
+}
+
+// DetectType 先頭のバイト列から画像の種類を判定する
+func DetectType(data []byte) (string, error) {
+	switch ct := http.DetectContentType(data); ct {
+	case TypeJPEG, TypePNG, TypeWebP:
+		return ct, nil
+	}
+	return "", ErrUnsupportedType
+}
+
+// Process アップロードされた画像を検証し、EXIFなどのメタデータを取り除いてサムネイルを作る
+// JPEGのEXIFの向きは画素に反映してから取り除く
+func Process(data []byte) (Result, error) {
+	ct, err := DetectType(data)
+	if err != nil {
+		return Result{}, err
+	}
+	cfg, err := decodeConfig(ct, data)
+	if err != nil {
+		return Result{}, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+		return Result{}, ErrTooLarge
+	}
+	img, err := decode(ct, data)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res := Result{ContentType: ct}
+	switch ct {
+	case TypeJPEG:
+		orientation := jpegOrientation(data)
+		if orientation > 1 {
+			// the pixels are rotated, so the image has to be encoded again.
+			img = orient(img, orientation)
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: reencodeQuality}); err != nil {
+				return Result{}, err
+			}
+			res.Data = buf.Bytes()
+		} else {
+			res.Data, err = stripJPEG(data)
+		}
+	case TypePNG:
+		res.Data, err = stripPNG(data)
+	case TypeWebP:
+		res.Data, err = stripWebP(data)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+
+	b := img.Bounds()
+	res.Width, res.Height = b.Dx(), b.Dy()
+	var buf bytes.Buffer
+	thumb := Resize(img, ThumbnailSize)
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return Result{}, err
+	}
+	res.Thumbnail = buf.Bytes()
+	return res, nil
+}
+
+func decodeConfig(ct string, data []byte) (image.Config, error) {
+	r := bytes.NewReader(data)
+	switch ct {
+	case TypeJPEG:
+		return jpeg.DecodeConfig(r)
+	case TypePNG:
+		return png.DecodeConfig(r)
+	case TypeWebP:
+		return webp.DecodeConfig(r)
+	}
+	return image.Config{}, ErrUnsupportedType
+}
+
+func decode(ct string, data []byte) (image.Image, error) {
+	r := bytes.NewReader(data)
+	switch ct {
+	case TypeJPEG:
+		return jpeg.Decode(r)
+	case TypePNG:
+		return png.Decode(r)
+	case TypeWebP:
+		return webp.Decode(r)
+	}
+	return nil, ErrUnsupportedType
+}
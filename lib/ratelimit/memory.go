@@ -0,0 +1,96 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryItem struct {
+	entry   Entry
+	expires time.Time
+}
+
+// MemoryStore プロセス内のmapにEntryを保存するStore
+// 複数台構成ではプロセスごとに独立して制限される
+type MemoryStore struct {
+	mu    sync.Mutex
+	items map[string]memoryItem
+	// lastSweep 期限切れのEntryを最後に掃除した時刻
+	lastSweep time.Time
+}
+
+// NewMemoryStore MemoryStoreを返す
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{items: map[string]memoryItem{}}
+}
+
+// Get keyのEntryを返す
+// 存在しない場合は空のEntryを返す
+func (ms *MemoryStore) Get(key string) (Entry, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	now := time.Now()
+	ms.sweep(now)
+	item, ok := ms.items[key]
+	if !ok || now.After(item.expires) {
+		return Entry{}, nil
+	}
+	return item.entry, nil
+}
+
+// Incr keyの失敗回数を1増やす
+func (ms *MemoryStore) Incr(key string, now time.Time, window time.Duration) (Entry, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	item, ok := ms.items[key]
+	if !ok || now.After(item.expires) || now.Sub(item.entry.LastFailure) > window {
+		item = memoryItem{}
+	}
+	item.entry.Failures++
+	item.entry.LastFailure = now
+	if expires := now.Add(window); expires.After(item.expires) {
+		item.expires = expires
+	}
+	ms.items[key] = item
+	return item.entry, nil
+}
+
+// Block keyのBlockedUntilを延ばす
+func (ms *MemoryStore) Block(key string, until time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	item, ok := ms.items[key]
+	if !ok {
+		item = memoryItem{expires: until}
+	}
+	if until.After(item.entry.BlockedUntil) {
+		item.entry.BlockedUntil = until
+	}
+	if until.After(item.expires) {
+		item.expires = until
+	}
+	ms.items[key] = item
+	return nil
+}
+
+// Delete keyのEntryを削除する
+func (ms *MemoryStore) Delete(key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.items, key)
+	return nil
+}
+
+// sweep 期限切れのEntryを削除する
+// 呼び出し元でロックを取得していること
+func (ms *MemoryStore) sweep(now time.Time) {
+	if now.Sub(ms.lastSweep) < time.Minute {
+		return
+	}
+	ms.lastSweep = now
+	for k, item := range ms.items {
+		if now.After(item.expires) {
+			delete(ms.items, k)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"time"
+)
+
+// Entry keyごとの失敗回数とブロック状態
+type Entry struct {
+	Failures     int
+	LastFailure  time.Time
+	BlockedUntil time.Time
+}
+
+// Store Entryの保存先
+// 複数台構成で共有する場合はこのinterfaceを実装する
+type Store interface {
+	Get(key string) (Entry, error)
+	// Incr keyの失敗回数を原子的に1増やし、LastFailureをnowにして更新後のEntryを返す
+	// 最後の失敗からwindowを過ぎていた場合は失敗回数を数え直す
+	// 最後の失敗からwindow経過後、かつBlockedUntilを過ぎた後にEntryは破棄されてよい
+	Incr(key string, now time.Time, window time.Duration) (Entry, error)
+	// Block keyのBlockedUntilをuntilまで延ばす。既により後まで延びている場合は変更しない
+	Block(key string, until time.Time) error
+	Delete(key string) error
+}
+
+// Policy 失敗回数に応じたブロック時間の決め方
+type Policy struct {
+	// FreeAttempts この回数までの失敗ではブロックしない
+	FreeAttempts int
+	// BaseDelay FreeAttemptsを超えた最初の失敗でのブロック時間
+	// 以降失敗するごとに倍になる
+	BaseDelay time.Duration
+	// MaxDelay ブロック時間の上限
+	MaxDelay time.Duration
+	// Window 最後の失敗からこの時間が経過すると失敗回数をリセットする
+	Window time.Duration
+}
+
+// Limiter Policyに従ってkeyごとの試行を制限する
+type Limiter struct {
+	Store  Store
+	Policy Policy
+	// Prefix 同じStoreを複数のLimiterで共有するためのkeyのprefix
+	Prefix string
+}
+
+// NewLimiter Limiterを返す
+func NewLimiter(store Store, prefix string, policy Policy) *Limiter {
+	return &Limiter{Store: store, Policy: policy, Prefix: prefix}
+}
+
+// Allow keyが試行可能か判定する
+// ブロック中の場合は再試行までの待ち時間を返す
+func (l *Limiter) Allow(key string) (time.Duration, error) {
+	e, err := l.Store.Get(l.Prefix + key)
+	if err != nil {
+		return 0, err
+	}
+	if wait := time.Until(e.BlockedUntil); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// Fail keyの失敗を記録し、必要であればブロックする
+// ブロックされた場合は再試行までの待ち時間を返す
+func (l *Limiter) Fail(key string) (time.Duration, error) {
+	now := time.Now()
+	e, err := l.Store.Incr(l.Prefix+key, now, l.Policy.Window)
+	if err != nil {
+		return 0, err
+	}
+	delay := l.delay(e.Failures)
+	if delay > 0 {
+		if err := l.Store.Block(l.Prefix+key, now.Add(delay)); err != nil {
+			return 0, err
+		}
+	}
+	return delay, nil
+}
+
+// Reset keyの失敗回数を消去する
+func (l *Limiter) Reset(key string) error {
+	return l.Store.Delete(l.Prefix + key)
+}
+
+// delay failures回目の失敗に対するブロック時間を返す
+func (l *Limiter) delay(failures int) time.Duration {
+	over := failures - l.Policy.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+	d := l.Policy.BaseDelay
+	for i := 1; i < over; i++ {
+		d *= 2
+		if d >= l.Policy.MaxDelay {
+			break
+		}
+	}
+	if d > l.Policy.MaxDelay {
+		d = l.Policy.MaxDelay
+	}
+	return d
+}
@@ -0,0 +1,96 @@
+package safehttp
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress 内部のネットワークのアドレスへ接続しようとした
+var ErrForbiddenAddress = errors.New("forbidden address")
+
+// forbiddenNets IsLoopbackなどで判定できない内部のネットワーク
+var forbiddenNets = mustParseCIDRs(
+	"0.0.0.0/8",      // this network
+	"10.0.0.0/8",     // private
+	"100.64.0.0/10",  // carrier-grade NAT
+	"172.16.0.0/12",  // private
+	"192.168.0.0/16", // private
+	"fc00::/7",       // unique local
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// CheckIP ipがループバック、プライベート、リンクローカル、未指定のアドレスでないか検証する
+func CheckIP(ip net.IP) error {
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return ErrForbiddenAddress
+	}
+	for _, n := range forbiddenNets {
+		if n.Contains(ip) {
+			return ErrForbiddenAddress
+		}
+	}
+	return nil
+}
+
+// CheckHost hostのアドレスを全て解決し、CheckIPで検証する
+// 接続時にも検証するため、ここでは設定の時点で明らかに誤った宛先を弾く
+func CheckHost(host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		return CheckIP(ip)
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		if err := CheckIP(ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// control 名前解決した後の接続先のアドレスを検証する
+// 検証と接続の間に名前解決の結果が変わる(DNS rebinding)場合も防げる
+func control(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	return CheckIP(net.ParseIP(host))
+}
+
+// NewDialer 内部のネットワークへの接続を拒否するDialerを返す
+func NewDialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second, Control: control}
+}
+
+// NewClient 内部のネットワークへの接続を拒否するタイムアウトtimeoutのclientを返す
+// リダイレクト先への接続も同じDialerで検証する。環境変数のproxyは使わない
+func NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           NewDialer(timeout).DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+}
@@ -0,0 +1,335 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxIterations 発生時刻を求める際の繰り返し回数の上限
+const maxIterations = 100000
+
+// Schedule 繰り返し発生する予定
+type Schedule interface {
+	// Between from以上to未満の発生時刻を昇順に返す
+	Between(from, to time.Time) []time.Time
+}
+
+// Clock 1日のうちの時刻
+type Clock struct {
+	Hour   int
+	Minute int
+}
+
+func (c Clock) String() string {
+	return fmt.Sprintf("%02d:%02d", c.Hour, c.Minute)
+}
+
+// ParseClocks "08:00,20:30" 形式の時刻の一覧を解析する
+func ParseClocks(s string) ([]Clock, error) {
+	var cs []Clock
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		t, err := time.Parse("15:04", part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %q", part)
+		}
+		cs = append(cs, Clock{t.Hour(), t.Minute()})
+	}
+	if len(cs) == 0 {
+		return nil, errors.New("no time is specified")
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		return cs[i].Hour*60+cs[i].Minute < cs[j].Hour*60+cs[j].Minute
+	})
+	return cs, nil
+}
+
+// FormatClocks ParseClocksで解析できる形式で返す
+func FormatClocks(cs []Clock) string {
+	s := make([]string, len(cs))
+	for i, c := range cs {
+		s[i] = c.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// DefaultClocks 1日n回の時刻を8:00から20:00の間に均等に割り振る
+func DefaultClocks(n int) []Clock {
+	if n <= 0 {
+		return nil
+	}
+	if n == 1 {
+		return []Clock{{8, 0}}
+	}
+	span := 12 * 60 / (n - 1)
+	cs := make([]Clock, n)
+	for i := range cs {
+		m := 8*60 + span*i
+		cs[i] = Clock{m / 60, m % 60}
+	}
+	return cs
+}
+
+// Daily 毎日決まった時刻に発生する予定
+type Daily struct {
+	Start time.Time
+	Clock []Clock
+	Loc   *time.Location
+}
+
+// Between from以上to未満の発生時刻を昇順に返す
+func (d Daily) Between(from, to time.Time) []time.Time {
+	if from.Before(d.Start) {
+		from = d.Start
+	}
+	var ts []time.Time
+	f := from.In(d.Loc)
+	day := time.Date(f.Year(), f.Month(), f.Day(), 0, 0, 0, 0, d.Loc)
+	for i := 0; i < maxIterations && day.Before(to); i++ {
+		for _, c := range d.Clock {
+			t := time.Date(day.Year(), day.Month(), day.Day(), c.Hour, c.Minute, 0, 0, d.Loc)
+			if !t.Before(from) && t.Before(to) {
+				ts = append(ts, t)
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return ts
+}
+
+// Frequencies supported by RRule
+const (
+	FreqHourly = "HOURLY"
+	FreqDaily  = "DAILY"
+	FreqWeekly = "WEEKLY"
+)
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// RRule RFC 5545 のRRULEのうちFREQ, INTERVAL, COUNT, UNTIL, BYDAY, BYHOUR, BYMINUTE に対応する予定
+// 発生時刻はDtStartから数え、DtStartのタイムゾーンで解釈する
+type RRule struct {
+	DtStart  time.Time
+	Freq     string
+	Interval int
+	Count    int
+	Until    *time.Time
+	ByDay    []time.Weekday
+	ByHour   []int
+	ByMinute []int
+}
+
+// ParseRRule "FREQ=DAILY;BYHOUR=8,20" 形式の文字列を解析する
+// 先頭の "RRULE:" は省略できる
+func ParseRRule(s string, dtstart time.Time) (*RRule, error) {
+	r := &RRule{DtStart: dtstart, Interval: 1}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	for _, part := range strings.Split(s, ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid rrule part %q", part)
+		}
+		name, value := strings.ToUpper(kv[0]), kv[1]
+		var err error
+		switch name {
+		case "FREQ":
+			r.Freq = strings.ToUpper(value)
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(value)
+			if err == nil && r.Interval <= 0 {
+				err = errors.New("must be positive")
+			}
+		case "COUNT":
+			r.Count, err = strconv.Atoi(value)
+			if err == nil && r.Count <= 0 {
+				err = errors.New("must be positive")
+			}
+		case "UNTIL":
+			var t time.Time
+			t, err = parseUntil(value, dtstart.Location())
+			r.Until = &t
+		case "BYDAY":
+			for _, d := range strings.Split(value, ",") {
+				wd, ok := weekdays[strings.ToUpper(d)]
+				if !ok {
+					err = fmt.Errorf("unsupported day %q", d)
+					break
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYHOUR":
+			r.ByHour, err = parseInts(value, 0, 23)
+		case "BYMINUTE":
+			r.ByMinute, err = parseInts(value, 0, 59)
+		default:
+			err = errors.New("unsupported")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rrule %s: %v", name, err)
+		}
+	}
+	switch r.Freq {
+	case FreqHourly, FreqDaily, FreqWeekly:
+	case "":
+		return nil, errors.New("rrule FREQ is required")
+	default:
+		return nil, fmt.Errorf("rrule FREQ=%s is not supported", r.Freq)
+	}
+	return r, nil
+}
+
+func parseUntil(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("20060102T150405", s, loc); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("20060102", s, loc)
+}
+
+func parseInts(s string, min, max int) ([]int, error) {
+	var ns []int
+	for _, p := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < min || n > max {
+			return nil, fmt.Errorf("invalid value %q", p)
+		}
+		ns = append(ns, n)
+	}
+	sort.Ints(ns)
+	return ns, nil
+}
+
+// Between from以上to未満の発生時刻を昇順に返す
+func (r *RRule) Between(from, to time.Time) []time.Time {
+	var ts []time.Time
+	n := 0
+	r.each(func(t time.Time) bool {
+		if t.Before(r.DtStart) {
+			return true
+		}
+		n++
+		if r.Count > 0 && n > r.Count {
+			return false
+		}
+		if r.Until != nil && t.After(*r.Until) {
+			return false
+		}
+		if !t.Before(to) {
+			return false
+		}
+		if !t.Before(from) {
+			ts = append(ts, t)
+		}
+		return true
+	})
+	return ts
+}
+
+// each 候補となる時刻を昇順にfnへ渡す。fnがfalseを返すと終了する
+func (r *RRule) each(fn func(time.Time) bool) {
+	start := r.DtStart
+	loc := start.Location()
+	hours := r.ByHour
+	if len(hours) == 0 {
+		hours = []int{start.Hour()}
+	}
+	minutes := r.ByMinute
+	if len(minutes) == 0 {
+		minutes = []int{start.Minute()}
+	}
+
+	if r.Freq == FreqHourly {
+		// BYHOUR limits the hours, BYMINUTE expands each hour into its minutes.
+		// hours are counted in elapsed time so that they stay evenly spaced across DST changes.
+		base := time.Date(start.Year(), start.Month(), start.Day(), start.Hour(), 0, 0, 0, loc)
+		for i := 0; i < maxIterations; i++ {
+			h := base.Add(time.Duration(i*r.Interval) * time.Hour)
+			if !r.matchDay(h.Weekday()) || !r.matchHour(h.Hour()) {
+				continue
+			}
+			for _, m := range minutes {
+				if !fn(h.Add(time.Duration(m) * time.Minute)) {
+					return
+				}
+			}
+		}
+		return
+	}
+
+	// days of the period in the order of occurrence.
+	var days []int
+	period := 1
+	first := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+	if r.Freq == FreqWeekly {
+		period = 7
+		// weeks start on monday.
+		first = first.AddDate(0, 0, -(int(first.Weekday())+6)%7)
+		byDay := r.ByDay
+		if len(byDay) == 0 {
+			byDay = []time.Weekday{start.Weekday()}
+		}
+		for _, wd := range byDay {
+			days = append(days, (int(wd)+6)%7)
+		}
+		sort.Ints(days)
+	} else {
+		days = []int{0}
+	}
+
+	for i := 0; i < maxIterations; i++ {
+		base := first.AddDate(0, 0, i*period*r.Interval)
+		for _, d := range days {
+			day := base.AddDate(0, 0, d)
+			if r.Freq == FreqDaily && !r.matchDay(day.Weekday()) {
+				continue
+			}
+			for _, h := range hours {
+				for _, m := range minutes {
+					t := time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, loc)
+					if !fn(t) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+func (r *RRule) matchDay(wd time.Weekday) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, d := range r.ByDay {
+		if d == wd {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RRule) matchHour(h int) bool {
+	if len(r.ByHour) == 0 {
+		return true
+	}
+	for _, v := range r.ByHour {
+		if v == h {
+			return true
+		}
+	}
+	return false
+}
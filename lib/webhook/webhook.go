@@ -0,0 +1,219 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greytabby/meowapi/lib/model"
+	"github.com/greytabby/meowapi/lib/notify"
+	"github.com/greytabby/meowapi/lib/safehttp"
+)
+
+// Headers sent with every delivery
+const (
+	// SignatureHeader "t=<unix time>,v1=<hex>" の形式の署名
+	// v1は "<unix time>.<body>" のsecretによるHMAC-SHA256
+	SignatureHeader = "X-Meowapi-Signature"
+	EventHeader     = "X-Meowapi-Event"
+	// DeliveryHeader 送信ごとのid。同じイベントの再送では同じ値になる
+	DeliveryHeader = "X-Meowapi-Delivery"
+)
+
+const (
+	// claimLease 送信中の通知を他の送信処理が扱わない時間
+	claimLease = time.Minute
+	// deliverBatch 1回のDeliverPendingで送信する数の上限
+	deliverBatch = 100
+	// maxErrorLength 記録するエラーメッセージの長さの上限
+	maxErrorLength = 400
+)
+
+// Sign secretでtとbodyの署名を作り、SignatureHeaderの値を返す
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify SignatureHeaderの値headerがbodyの正しい署名で、
+// 署名した時刻がnowからtolerance以内か検証する。受信側の実装の参考にする
+func Verify(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			ts = kv[1]
+		case "v1":
+			sig = kv[1]
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return errors.New("malformed signature")
+	}
+	t := time.Unix(unix, 0)
+	if d := now.Sub(t); d > tolerance || d < -tolerance {
+		return errors.New("signature is too old")
+	}
+	want := Sign(secret, t, body)
+	if !hmac.Equal([]byte(want), []byte("t="+ts+",v1="+sig)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// Store webhookと送信の記録を保存する
+type Store interface {
+	GetWebhookById(id int64) (model.Webhook, error)
+	GetPendingWebhookDeliveries(now time.Time, limit int) ([]model.WebhookDelivery, error)
+	ClaimWebhookDelivery(id int64, now, until time.Time) (bool, error)
+	UpdateWebhookDelivery(d model.WebhookDelivery) error
+}
+
+// Sender webhookへイベントを送信する
+// 失敗した送信はnotify.Backoffの間隔で再送し、notify.MaxAttempts回失敗すると諦める
+type Sender struct {
+	Store Store
+	// Client nilの場合は内部のネットワークへ接続しないタイムアウト10秒のclientを使う
+	Client *http.Client
+}
+
+var defaultClient = safehttp.NewClient(10 * time.Second)
+
+// DeliverPending 再送の時刻になった送信を全て行う
+// 送信に成功した数を返す
+func (s *Sender) DeliverPending(now time.Time) (int, error) {
+	ds, err := s.Store.GetPendingWebhookDeliveries(now, deliverBatch)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, d := range ds {
+		d, err := s.Deliver(d, now)
+		if err != nil {
+			return sent, err
+		}
+		if d.Status == model.NotificationStatusSent {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// Deliver dを1回送信して結果を記録し、記録したdを返す
+// 他の送信処理が扱っている場合は何もせずdをそのまま返す
+func (s *Sender) Deliver(d model.WebhookDelivery, now time.Time) (model.WebhookDelivery, error) {
+	claimed, err := s.Store.ClaimWebhookDelivery(d.Id, now, now.Add(claimLease))
+	if err != nil || !claimed {
+		return d, err
+	}
+
+	w, err := s.Store.GetWebhookById(d.WebhookId)
+	// test events are sent to disabled webhooks too, to check them before enabling.
+	if err == nil && !w.Enabled && d.Event != model.WebhookEventTest {
+		err = errors.New("webhook is disabled")
+	}
+	if err == nil {
+		d.ResponseStatus, err = s.post(w, d, now)
+	} else {
+		err = notify.Permanent(err)
+	}
+
+	d.Attempts++
+	switch {
+	case err == nil:
+		t := now
+		d.Status = model.NotificationStatusSent
+		d.Sent = &t
+		d.LastError = ""
+	case notify.IsPermanent(err) || d.Attempts >= notify.MaxAttempts:
+		d.Status = model.NotificationStatusFailed
+		d.LastError = errorText(err)
+	default:
+		d.NextAttempt = now.Add(notify.Backoff(d.Attempts))
+		d.LastError = errorText(err)
+	}
+	return d, s.Store.UpdateWebhookDelivery(d)
+}
+
+// post ペイロードに署名してPOSTし、応答のステータスコードを返す
+// 2xx以外の応答は再送する
+func (s *Sender) post(w model.Webhook, d model.WebhookDelivery, now time.Time) (int, error) {
+	body := []byte(d.Payload)
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, notify.Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "meowapi")
+	req.Header.Set(EventHeader, d.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(d.Id, 10))
+	req.Header.Set(SignatureHeader, Sign(w.Secret, now, body))
+
+	client := s.Client
+	if client == nil {
+		client = defaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, transportError(err)
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("responded %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// transportError 接続の失敗を、送信先のネットワークの様子が分からない短いエラーにする
+// 内部のネットワークへの接続は再送しても成功しない
+func transportError(err error) error {
+	if errors.Is(err, safehttp.ErrForbiddenAddress) {
+		return notify.Permanent(safehttp.ErrForbiddenAddress)
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return errors.New("timed out")
+	}
+	return errors.New("could not connect")
+}
+
+func errorText(err error) string {
+	s := err.Error()
+	if len(s) > maxErrorLength {
+		s = s[:maxErrorLength]
+	}
+	return s
+}
+
+// ValidateURL sがwebhookの送信先にできるhttpまたはhttpsのURLか検証する
+// 内部のネットワークのアドレスやそこへ解決されるホストは送信先にできない
+func ValidateURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("invalid url")
+	}
+	// hosts which can not be resolved now are checked again when connecting.
+	if err := safehttp.CheckHost(u.Hostname()); err == safehttp.ErrForbiddenAddress {
+		return err
+	}
+	return nil
+}
@@ -1,32 +1,52 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/greytabby/meowapi/lib/blob"
+	"github.com/greytabby/meowapi/lib/crypt"
 	"github.com/greytabby/meowapi/lib/db"
 	"github.com/greytabby/meowapi/lib/handler"
+	"github.com/greytabby/meowapi/lib/health"
+	"github.com/greytabby/meowapi/lib/job"
 	"github.com/greytabby/meowapi/lib/model"
+	"github.com/greytabby/meowapi/lib/notify"
+	"github.com/greytabby/meowapi/lib/oidc"
+	"github.com/greytabby/meowapi/lib/password"
+	"github.com/greytabby/meowapi/lib/ratelimit"
+	"github.com/greytabby/meowapi/lib/schedule"
+	"github.com/greytabby/meowapi/lib/webhook"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		os.Exit(runAdmin(os.Args[2:]))
+	}
 	exitCode := run()
 	os.Exit(exitCode)
 }
 
-func run() int {
+// openDatabase データベースに接続し、テーブルの作成とmigrationを行う
+func openDatabase() (*db.MysqlDbAccessor, error) {
 	// Initialize database connection
 	dsn := os.Getenv("DATA_SOURCE_NAME")
 	dbAccessor, err := db.NewMysqlDbAccessor(dsn)
 	if err != nil {
-		log.Fatalf("Can not create db accessor. %v\n", err)
-		return 1
+		return nil, err
 	}
-	defer dbAccessor.Db.Db.Close()
 
 	// Prepare database
 	dbAccessor.Db.AddTableWithName(model.Cat{}, "cat")
@@ -34,6 +54,29 @@ func run() int {
 	dbAccessor.Db.AddTableWithName(model.UseToilet{}, "usetoilet")
 	dbAccessor.Db.AddTableWithName(model.Wash{}, "wash")
 	dbAccessor.Db.AddTableWithName(model.User{}, "user")
+	dbAccessor.Db.AddTableWithName(model.RecoveryCode{}, "recoverycode")
+	dbAccessor.Db.AddTableWithName(model.ApiKey{}, "apikey").ColMap("Hash").SetUnique(true)
+	dbAccessor.Db.AddTableWithName(model.Household{}, "household")
+	dbAccessor.Db.AddTableWithName(model.HouseholdMember{}, "householdmember").SetUniqueTogether("householdid", "uid")
+	dbAccessor.Db.AddTableWithName(model.HouseholdInvitation{}, "householdinvitation").ColMap("Code").SetUnique(true)
+	dbAccessor.Db.AddTableWithName(model.UserIdentity{}, "useridentity").SetUniqueTogether("provider", "subject")
+	dbAccessor.Db.AddTableWithName(model.CatWeight{}, "catweight")
+	dbAccessor.Db.AddTableWithName(model.VetVisit{}, "vetvisit")
+	dbAccessor.Db.AddTableWithName(model.Vaccination{}, "vaccination")
+	dbAccessor.Db.AddTableWithName(model.Condition{}, "catcondition")
+	dbAccessor.Db.AddTableWithName(model.Medication{}, "medication")
+	dbAccessor.Db.AddTableWithName(model.Feeding{}, "feeding")
+	dbAccessor.Db.AddTableWithName(model.WaterIntake{}, "waterintake")
+	dbAccessor.Db.AddTableWithName(model.Photo{}, "photo")
+	dbAccessor.Db.AddTableWithName(model.UseToiletPhoto{}, "usetoiletphoto")
+	dbAccessor.Db.AddTableWithName(model.SandStateTransition{}, "sandstatetransition")
+	dbAccessor.Db.AddTableWithName(model.NotificationPreference{}, "notificationpref")
+	dbAccessor.Db.AddTableWithName(model.Notification{}, "notification").SetUniqueTogether("preferenceid", "dedupkey")
+	dbAccessor.Db.AddTableWithName(model.Job{}, "job")
+	dbAccessor.Db.AddTableWithName(model.Webhook{}, "webhook")
+	dbAccessor.Db.AddTableWithName(model.WebhookDelivery{}, "webhookdelivery")
+	dbAccessor.Db.AddTableWithName(model.Litter{}, "litter")
+	dbAccessor.Db.AddTableWithName(model.MedicationDose{}, "medicationdose").SetUniqueTogether("medicationid", "scheduled")
 
 	for i := 0; i < 10; i++ {
 		err = dbAccessor.Db.CreateTablesIfNotExists()
@@ -48,6 +91,20 @@ func run() int {
 		log.Printf("Can not create table. %v\n", err)
 	}
 
+	if err = dbAccessor.Migrate(); err != nil {
+		log.Printf("Can not migrate database. %v\n", err)
+	}
+	return dbAccessor, nil
+}
+
+func run() int {
+	dbAccessor, err := openDatabase()
+	if err != nil {
+		log.Fatalf("Can not create db accessor. %v\n", err)
+		return 1
+	}
+	defer dbAccessor.Db.Db.Close()
+
 	// prepare middleware
 	e := echo.New()
 	e.Use(middleware.Logger())
@@ -60,49 +117,641 @@ func run() int {
 	// Create api request handler
 	catHandler := handler.CatHandler{Db: dbAccessor}
 	toiletHandler := handler.ToiletHandler{Db: dbAccessor}
-	useToiletHandler := handler.UseToiletHandler{Db: dbAccessor}
-	washHandler := handler.WashHandler{Db: dbAccessor}
-	authHandler := handler.AuthHandler{Db: dbAccessor}
+	sand := model.DefaultSandThresholds
+	if v, err := strconv.Atoi(os.Getenv("TOILET_USED_THRESHOLD")); err == nil && v > 0 {
+		sand.Used = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("TOILET_DIRTY_THRESHOLD")); err == nil && v > 0 {
+		sand.Dirty = v
+	}
+	if sand.Used >= sand.Dirty {
+		log.Fatalf("TOILET_USED_THRESHOLD (%d) must be less than TOILET_DIRTY_THRESHOLD (%d).\n", sand.Used, sand.Dirty)
+		return 1
+	}
+	useToiletHandler := handler.UseToiletHandler{Db: dbAccessor, Sand: sand}
+	washHandler := handler.WashHandler{Db: dbAccessor, Sand: sand}
+	litterHandler := handler.LitterHandler{Db: dbAccessor}
+	if v, err := strconv.Atoi(os.Getenv("LITTER_CONSUMPTION_WEEKS")); err == nil && v > 0 {
+		litterHandler.Weeks = v
+	}
+	apiKeyHandler := handler.ApiKeyHandler{Db: dbAccessor}
+	householdHandler := handler.HouseholdHandler{Db: dbAccessor}
+	adminHandler := handler.AdminHandler{Db: dbAccessor}
+	oidcHandler := handler.OidcHandler{Db: dbAccessor, Providers: oidcProviders()}
+	catWeightHandler := handler.CatWeightHandler{Db: dbAccessor}
+	if v, err := strconv.ParseFloat(os.Getenv("CAT_WEIGHT_LOSS_THRESHOLD"), 64); err == nil {
+		catWeightHandler.LossThreshold = v
+	}
+	medicalHandler := handler.MedicalHandler{Db: dbAccessor}
+	medicationHandler := handler.MedicationHandler{Db: dbAccessor}
+	if v, err := time.ParseDuration(os.Getenv("MEDICATION_DOSE_GRACE")); err == nil {
+		medicationHandler.Grace = v
+	}
+	userHandler := handler.UserHandler{Db: dbAccessor}
+	feedingHandler := handler.FeedingHandler{Db: dbAccessor}
+	waterIntakeHandler := handler.WaterIntakeHandler{Db: dbAccessor}
+	intakeHandler := handler.IntakeHandler{Db: dbAccessor}
+	statsHandler := handler.StatsHandler{Db: dbAccessor}
+	alertHandler := handler.AlertHandler{Db: dbAccessor, Health: healthConfig()}
+
+	// Notifications
+	channels, err := notifyChannels()
+	if err != nil {
+		log.Fatalf("Can not prepare notification channels. %v\n", err)
+		return 1
+	}
+	notificationHandler := handler.NotificationHandler{Db: dbAccessor, Channels: channels}
+	notifier := handler.Notifier{
+		Db:          dbAccessor,
+		Channels:    channels,
+		Health:      alertHandler.Health,
+		DoseGrace:   medicationHandler.Grace,
+		LitterWeeks: litterHandler.Weeks,
+	}
+
+	// Outgoing webhooks
+	webhookSender := webhook.Sender{Store: dbAccessor}
+	webhookHandler := handler.WebhookHandler{Db: dbAccessor, Sender: &webhookSender}
+	catHandler.Hooks = &webhookHandler
+	useToiletHandler.Hooks = &webhookHandler
+	washHandler.Hooks = &webhookHandler
+
+	// Background jobs
+	scheduler := job.Scheduler{Store: dbAccessor}
+	if err := addJobs(&scheduler, &notifier, &webhookSender, dbAccessor); err != nil {
+		log.Fatalf("Can not prepare background jobs. %v\n", err)
+		return 1
+	}
+
+	// Photo storage
+	blobs, err := blobStore()
+	if err != nil {
+		log.Fatalf("Can not prepare blob store. %v\n", err)
+		return 1
+	}
+	photoHandler := handler.PhotoHandler{Db: dbAccessor, Store: blobs}
+	if v, err := strconv.ParseInt(os.Getenv("PHOTO_MAX_BYTES"), 10, 64); err == nil {
+		photoHandler.MaxBytes = v
+	}
+	catHandler.Photos = &photoHandler
+	useToiletHandler.Photos = &photoHandler
+	adminHandler.Photos = &photoHandler
+
+	// Password policy for signup
+	passwordPolicy := password.DefaultPolicy
+	if v, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil {
+		passwordPolicy.MinLength = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_SCORE")); err == nil {
+		passwordPolicy.MinScore = v
+	}
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		breached, err := password.LoadBreachListFile(path)
+		if err != nil {
+			log.Printf("Can not load breached password list. %v\n", err)
+		} else {
+			passwordPolicy.Breached = breached
+		}
+	}
+
+	// Brute-force protection for the public auth endpoints
+	// Forwarded client addresses are trusted only from the listed reverse proxies.
+	trustedProxies, err := handler.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES. %v\n", err)
+	}
+	limitStore := ratelimit.NewMemoryStore()
+	authHandler := handler.AuthHandler{
+		Db:             dbAccessor,
+		PasswordPolicy: &passwordPolicy,
+		TrustedProxies: trustedProxies,
+		IPLimiter: ratelimit.NewLimiter(limitStore, "login-ip:", ratelimit.Policy{
+			FreeAttempts: 10,
+			BaseDelay:    time.Second,
+			MaxDelay:     15 * time.Minute,
+			Window:       time.Hour,
+		}),
+		AccountLimiter: ratelimit.NewLimiter(limitStore, "login-account:", ratelimit.Policy{
+			FreeAttempts: 5,
+			BaseDelay:    15 * time.Minute,
+			MaxDelay:     15 * time.Minute,
+			Window:       time.Hour,
+		}),
+		SignupLimiter: ratelimit.NewLimiter(limitStore, "signup-ip:", ratelimit.Policy{
+			FreeAttempts: 5,
+			BaseDelay:    time.Minute,
+			MaxDelay:     time.Hour,
+			Window:       24 * time.Hour,
+		}),
+	}
+
+	// TOTP secrets are stored encrypted, so 2fa is available only when the key is set.
+	totpCipher, err := crypt.NewCipher(os.Getenv("TOTP_ENCRYPTION_KEY"))
+	if err != nil {
+		log.Printf("TOTP is disabled. %v\n", err)
+	}
+	totpHandler := handler.TotpHandler{
+		Db:      dbAccessor,
+		Cipher:  totpCipher,
+		Issuer:  "meowapi",
+		Limiter: ratelimit.NewLimiter(limitStore, "totp:", authHandler.AccountLimiter.Policy),
+	}
 
 	// Routing
-	// Cat Endpoint
-	// Use JWT authentication
+	// Permission required for each route under /api.
+	// Routes which are not listed here are rejected.
+	permissions := handler.PermissionTable{
+		"GET /api/cat":                             handler.PermCatRead,
+		"POST /api/cat":                            handler.PermCatWrite,
+		"PUT /api/cat":                             handler.PermCatWrite,
+		"DELETE /api/cat":                          handler.PermCatWrite,
+		"GET /api/cat/:id/weights":                 handler.PermCatRead,
+		"POST /api/cat/:id/weights":                handler.PermCatWrite,
+		"GET /api/cat/:id/photo":                   handler.PermCatRead,
+		"POST /api/cat/:id/photo":                  handler.PermCatWrite,
+		"DELETE /api/cat/:id/photo":                handler.PermCatWrite,
+		"DELETE /api/cat/:id/weights":              handler.PermCatWrite,
+		"GET /api/cat/:id/weights/summary":         handler.PermCatRead,
+		"GET /api/feeding":                         handler.PermFeedingRead,
+		"POST /api/feeding":                        handler.PermFeedingWrite,
+		"PUT /api/feeding":                         handler.PermFeedingWrite,
+		"DELETE /api/feeding":                      handler.PermFeedingWrite,
+		"GET /api/water":                           handler.PermFeedingRead,
+		"POST /api/water":                          handler.PermFeedingWrite,
+		"PUT /api/water":                           handler.PermFeedingWrite,
+		"DELETE /api/water":                        handler.PermFeedingWrite,
+		"GET /api/intake/summary":                  handler.PermFeedingRead,
+		"GET /api/medical/visit":                   handler.PermMedicalRead,
+		"POST /api/medical/visit":                  handler.PermMedicalWrite,
+		"PUT /api/medical/visit":                   handler.PermMedicalWrite,
+		"DELETE /api/medical/visit":                handler.PermMedicalWrite,
+		"GET /api/medical/vaccination":             handler.PermMedicalRead,
+		"GET /api/medical/vaccination/upcoming":    handler.PermMedicalRead,
+		"POST /api/medical/vaccination":            handler.PermMedicalWrite,
+		"PUT /api/medical/vaccination":             handler.PermMedicalWrite,
+		"DELETE /api/medical/vaccination":          handler.PermMedicalWrite,
+		"GET /api/medical/condition":               handler.PermMedicalRead,
+		"POST /api/medical/condition":              handler.PermMedicalWrite,
+		"PUT /api/medical/condition":               handler.PermMedicalWrite,
+		"DELETE /api/medical/condition":            handler.PermMedicalWrite,
+		"GET /api/medication":                      handler.PermMedicalRead,
+		"POST /api/medication":                     handler.PermMedicalWrite,
+		"PUT /api/medication":                      handler.PermMedicalWrite,
+		"DELETE /api/medication":                   handler.PermMedicalWrite,
+		"GET /api/medication/overdue":              handler.PermMedicalRead,
+		"GET /api/medication/:id/dose":             handler.PermMedicalRead,
+		"POST /api/medication/:id/dose":            handler.PermMedicalWrite,
+		"GET /api/toilet":                          handler.PermToiletRead,
+		"POST /api/toilet":                         handler.PermToiletWrite,
+		"PUT /api/toilet":                          handler.PermToiletWrite,
+		"DELETE /api/toilet":                       handler.PermToiletWrite,
+		"GET /api/toilet/:id/history":              handler.PermToiletRead,
+		"GET /api/toilet/due":                      handler.PermToiletRead,
+		"GET /api/usetoilet":                       handler.PermUseToiletRead,
+		"GET /api/alerts":                          handler.PermUseToiletRead,
+		"GET /api/stats/usetoilet":                 handler.PermUseToiletRead,
+		"POST /api/usetoilet":                      handler.PermUseToiletWrite,
+		"PUT /api/usetoilet":                       handler.PermUseToiletWrite,
+		"GET /api/usetoilet/export":                handler.PermUseToiletRead,
+		"POST /api/usetoilet/:id/photo":            handler.PermUseToiletWrite,
+		"DELETE /api/usetoilet/:id/photo/:photoid": handler.PermUseToiletWrite,
+		"DELETE /api/usetoilet":                    handler.PermUseToiletWrite,
+		"GET /api/litter":                          handler.PermToiletRead,
+		"GET /api/litter/low":                      handler.PermToiletRead,
+		"POST /api/litter":                         handler.PermToiletWrite,
+		"PUT /api/litter":                          handler.PermToiletWrite,
+		"DELETE /api/litter":                       handler.PermToiletWrite,
+		"POST /api/litter/:id/restock":             handler.PermToiletWrite,
+		"GET /api/wash":                            handler.PermWashRead,
+		"GET /api/wash/:toiletid":                  handler.PermWashRead,
+		"POST /api/wash":                           handler.PermWashWrite,
+		"PUT /api/wash":                            handler.PermWashWrite,
+		"DELETE /api/wash":                         handler.PermWashWrite,
+		"GET /api/household":                       handler.PermHouseholdRead,
+		"POST /api/household":                      handler.PermHouseholdWrite,
+		"POST /api/household/join":                 handler.PermHouseholdWrite,
+		"GET /api/household/member":                handler.PermHouseholdRead,
+		"PUT /api/household/member":                handler.PermHouseholdWrite,
+		"DELETE /api/household/member":             handler.PermHouseholdWrite,
+		"POST /api/household/invitation":           handler.PermHouseholdWrite,
+		"GET /api/webhook":                         handler.PermWebhookRead,
+		"POST /api/webhook":                        handler.PermWebhookWrite,
+		"PUT /api/webhook":                         handler.PermWebhookWrite,
+		"DELETE /api/webhook":                      handler.PermWebhookWrite,
+		"GET /api/webhook/:id/delivery":            handler.PermWebhookRead,
+		"POST /api/webhook/:id/test":               handler.PermWebhookWrite,
+		"GET /api/user":                            handler.PermProfileManage,
+		"PUT /api/user":                            handler.PermProfileManage,
+		"GET /api/notification":                    handler.PermProfileManage,
+		"GET /api/notification/channels":           handler.PermProfileManage,
+		"GET /api/notification/preference":         handler.PermProfileManage,
+		"POST /api/notification/preference":        handler.PermProfileManage,
+		"PUT /api/notification/preference":         handler.PermProfileManage,
+		"DELETE /api/notification/preference":      handler.PermProfileManage,
+		"POST /api/notification/test":              handler.PermProfileManage,
+		"GET /api/apikey":                          handler.PermApiKeyManage,
+		"POST /api/apikey":                         handler.PermApiKeyManage,
+		"DELETE /api/apikey":                       handler.PermApiKeyManage,
+		"POST /api/totp/enroll":                    handler.PermTotpManage,
+		"POST /api/totp/confirm":                   handler.PermTotpManage,
+		"POST /api/totp/disable":                   handler.PermTotpManage,
+		"GET /admin/user":                          handler.PermAdminUsers,
+		"GET /admin/user/:id":                      handler.PermAdminUsers,
+		"PUT /admin/user/:id/disable":              handler.PermAdminUsers,
+		"PUT /admin/user/:id/enable":               handler.PermAdminUsers,
+		"PUT /admin/user/:id/logout":               handler.PermAdminUsers,
+		"DELETE /admin/user/:id":                   handler.PermAdminUsers,
+		"GET /admin/job":                           handler.PermAdminJobs,
+	}
+
+	// Use api key or JWT authentication
 	r := e.Group("/api")
+	r.Use(apiKeyHandler.Middleware)
 	r.Use(middleware.JWTWithConfig(handler.JWTConfig))
-	r.GET("/cat", catHandler.GetAllCats)
-	r.POST("/cat", catHandler.AddCat)
-	r.PUT("/cat", catHandler.UpdateCat)
-	r.DELETE("/cat", catHandler.DeleteCat)
+	r.Use(authHandler.Active)
+	r.Use(handler.Authorize(permissions))
+
+	// Cat, Toilet, UseToilet and Wash belong to the household
+	// selected by X-Household-Id header.
+	hr := r.Group("", householdHandler.Middleware, householdHandler.Writable)
+
+	// Cat Endpoint
+	hr.GET("/cat", catHandler.GetAllCats)
+	hr.POST("/cat", catHandler.AddCat)
+	hr.PUT("/cat", catHandler.UpdateCat)
+	hr.DELETE("/cat", catHandler.DeleteCat)
+	hr.GET("/cat/:id/weights", catWeightHandler.GetCatWeights)
+	hr.POST("/cat/:id/weights", catWeightHandler.AddCatWeight)
+	hr.DELETE("/cat/:id/weights", catWeightHandler.DeleteCatWeight)
+	hr.GET("/cat/:id/weights/summary", catWeightHandler.GetCatWeightSummary)
+	hr.GET("/cat/:id/photo", photoHandler.GetCatPhoto)
+	hr.POST("/cat/:id/photo", photoHandler.UploadCatPhoto)
+	hr.DELETE("/cat/:id/photo", photoHandler.DeleteCatPhoto)
+
+	// Feeding and water intake Endpoint
+	hr.GET("/feeding", feedingHandler.GetFeedings)
+	hr.POST("/feeding", feedingHandler.AddFeeding)
+	hr.PUT("/feeding", feedingHandler.UpdateFeeding)
+	hr.DELETE("/feeding", feedingHandler.DeleteFeeding)
+	hr.GET("/water", waterIntakeHandler.GetWaterIntakes)
+	hr.POST("/water", waterIntakeHandler.AddWaterIntake)
+	hr.PUT("/water", waterIntakeHandler.UpdateWaterIntake)
+	hr.DELETE("/water", waterIntakeHandler.DeleteWaterIntake)
+	hr.GET("/intake/summary", intakeHandler.GetIntakeSummary)
+
+	// Medical record Endpoint
+	hr.GET("/medical/visit", medicalHandler.GetVetVisits)
+	hr.POST("/medical/visit", medicalHandler.AddVetVisit)
+	hr.PUT("/medical/visit", medicalHandler.UpdateVetVisit)
+	hr.DELETE("/medical/visit", medicalHandler.DeleteVetVisit)
+	hr.GET("/medical/vaccination", medicalHandler.GetVaccinations)
+	hr.GET("/medical/vaccination/upcoming", medicalHandler.GetUpcomingVaccinations)
+	hr.POST("/medical/vaccination", medicalHandler.AddVaccination)
+	hr.PUT("/medical/vaccination", medicalHandler.UpdateVaccination)
+	hr.DELETE("/medical/vaccination", medicalHandler.DeleteVaccination)
+	hr.GET("/medical/condition", medicalHandler.GetConditions)
+	hr.POST("/medical/condition", medicalHandler.AddCondition)
+	hr.PUT("/medical/condition", medicalHandler.UpdateCondition)
+	hr.DELETE("/medical/condition", medicalHandler.DeleteCondition)
+
+	// Medication Endpoint
+	hr.GET("/medication", medicationHandler.GetMedications)
+	hr.POST("/medication", medicationHandler.AddMedication)
+	hr.PUT("/medication", medicationHandler.UpdateMedication)
+	hr.DELETE("/medication", medicationHandler.DeleteMedication)
+	hr.GET("/medication/overdue", medicationHandler.GetOverdueDoses)
+	hr.GET("/medication/:id/dose", medicationHandler.GetDoses)
+	hr.POST("/medication/:id/dose", medicationHandler.AddDose)
 
 	// Toilet Endpoint
-	r.GET("/toilet", toiletHandler.GetAllToilets)
-	r.POST("/toilet", toiletHandler.AddToilet)
-	r.PUT("/toilet", toiletHandler.UpdateToilet)
-	r.DELETE("/toilet", toiletHandler.DeleteToilet)
+	hr.GET("/toilet", toiletHandler.GetAllToilets)
+	hr.POST("/toilet", toiletHandler.AddToilet)
+	hr.PUT("/toilet", toiletHandler.UpdateToilet)
+	hr.DELETE("/toilet", toiletHandler.DeleteToilet)
+	hr.GET("/toilet/due", toiletHandler.GetDueToilets)
+	hr.GET("/toilet/:id/history", toiletHandler.GetSandStateHistory)
 
 	// UseToilet Endpoint
-	r.GET("/usetoilet", useToiletHandler.GetAllUseToilets)
-	r.POST("/usetoilet", useToiletHandler.AddUseToilet)
-	r.PUT("/usetoilet", useToiletHandler.UpdateUseToilet)
-	r.DELETE("/usetoilet", useToiletHandler.DeleteUseToilet)
+	hr.GET("/usetoilet", useToiletHandler.GetAllUseToilets)
+	hr.GET("/usetoilet/export", useToiletHandler.ExportUseToilets)
+	hr.POST("/usetoilet", useToiletHandler.AddUseToilet)
+	hr.PUT("/usetoilet", useToiletHandler.UpdateUseToilet)
+	hr.DELETE("/usetoilet", useToiletHandler.DeleteUseToilet)
+	hr.POST("/usetoilet/:id/photo", photoHandler.AttachUseToiletPhotos)
+	hr.DELETE("/usetoilet/:id/photo/:photoid", photoHandler.DetachUseToiletPhoto)
+
+	// Health alert Endpoint
+	hr.GET("/alerts", alertHandler.GetAlerts)
+
+	// Statistics Endpoint
+	hr.GET("/stats/usetoilet", statsHandler.GetUseToiletStats)
 
 	// Wash Endpoint
-	r.GET("/wash", washHandler.GetAllWashes)
-	r.GET("/wash/:toiletid", washHandler.GetWashesByToiletId)
-	r.POST("/wash", washHandler.AddWash)
-	r.PUT("/wash", washHandler.UpdateWash)
-	r.DELETE("/wash", washHandler.DeleteWash)
+	hr.GET("/wash", washHandler.GetAllWashes)
+	hr.GET("/wash/:toiletid", washHandler.GetWashesByToiletId)
+	hr.POST("/wash", washHandler.AddWash)
+	hr.PUT("/wash", washHandler.UpdateWash)
+	hr.DELETE("/wash", washHandler.DeleteWash)
+
+	// Litter inventory Endpoint
+	hr.GET("/litter", litterHandler.GetLitters)
+	hr.GET("/litter/low", litterHandler.GetLowStock)
+	hr.POST("/litter", litterHandler.AddLitter)
+	hr.PUT("/litter", litterHandler.UpdateLitter)
+	hr.DELETE("/litter", litterHandler.DeleteLitter)
+	hr.POST("/litter/:id/restock", litterHandler.Restock)
+
+	// Webhook Endpoint
+	hr.GET("/webhook", webhookHandler.GetWebhooks)
+	hr.POST("/webhook", webhookHandler.AddWebhook)
+	hr.PUT("/webhook", webhookHandler.UpdateWebhook)
+	hr.DELETE("/webhook", webhookHandler.DeleteWebhook)
+	hr.GET("/webhook/:id/delivery", webhookHandler.GetDeliveries)
+	hr.POST("/webhook/:id/test", webhookHandler.SendTest)
+
+	// Household Endpoint
+	// The group has to be created before the routes on the same path.
+	hm := r.Group("/household", householdHandler.Middleware)
+	r.GET("/household", householdHandler.GetHouseholds)
+	r.POST("/household", householdHandler.AddHousehold)
+	r.POST("/household/join", householdHandler.Join)
+	hm.GET("/member", householdHandler.GetMembers)
+	hm.PUT("/member", householdHandler.UpdateMember)
+	hm.DELETE("/member", householdHandler.DeleteMember)
+	hm.POST("/invitation", householdHandler.AddInvitation)
+
+	// User Endpoint
+	r.GET("/user", userHandler.GetProfile)
+	r.PUT("/user", userHandler.UpdateProfile)
+
+	// Notification Endpoint
+	r.GET("/notification", notificationHandler.GetNotifications)
+	r.GET("/notification/channels", notificationHandler.GetChannels)
+	r.GET("/notification/preference", notificationHandler.GetPreferences)
+	r.POST("/notification/preference", notificationHandler.AddPreference)
+	r.PUT("/notification/preference", notificationHandler.UpdatePreference)
+	r.DELETE("/notification/preference", notificationHandler.DeletePreference)
+	r.POST("/notification/test", notificationHandler.SendTest)
+
+	// ApiKey Endpoint
+	r.GET("/apikey", apiKeyHandler.GetAllApiKeys)
+	r.POST("/apikey", apiKeyHandler.AddApiKey)
+	r.DELETE("/apikey", apiKeyHandler.DeleteApiKey)
+
+	// TOTP Endpoint
+	if totpCipher != nil {
+		r.POST("/totp/enroll", totpHandler.Enroll)
+		r.POST("/totp/confirm", totpHandler.Confirm)
+		r.POST("/totp/disable", totpHandler.Disable)
+	}
+
+	// Admin Endpoint
+	// Only JWT authentication, api keys can not be used for administration.
+	a := e.Group("/admin")
+	a.Use(middleware.JWTWithConfig(handler.JWTConfig))
+	a.Use(authHandler.Active)
+	a.Use(handler.Authorize(permissions))
+	a.GET("/user", adminHandler.SearchUsers)
+	a.GET("/user/:id", adminHandler.GetUser)
+	a.PUT("/user/:id/disable", adminHandler.DisableUser)
+	a.PUT("/user/:id/enable", adminHandler.EnableUser)
+	a.PUT("/user/:id/logout", adminHandler.LogoutUser)
+	a.DELETE("/user/:id", adminHandler.DeleteUser)
+	a.GET("/job", adminHandler.GetJobs)
 
 	// Auth Endpiont
 	e.POST("/signup", authHandler.Signup)
 	e.POST("/login", authHandler.Login)
+	// users who enabled TOTP get a 503 instead of a 404 when the key is missing.
+	e.POST("/login/totp", totpHandler.Login)
+	e.GET("/auth/oidc/:provider/start", oidcHandler.Start)
+	e.GET("/auth/oidc/:provider/callback", oidcHandler.Callback)
+
+	// Photos are served by signed URLs issued under /api.
+	e.GET("/photo/:id/:variant", photoHandler.Serve)
+
+	// Background jobs run until the server shuts down.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jobsDone := make(chan struct{})
+	go func() {
+		defer close(jobsDone)
+		if err := scheduler.Run(ctx); err != nil {
+			log.Printf("Background jobs stopped. %v\n", err)
+		}
+	}()
+
+	// Shut down gracefully on SIGINT and SIGTERM.
+	// e.Start returns as soon as Shutdown is called, so wait for the requests in flight.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		select {
+		case <-sig:
+		case <-ctx.Done():
+			// the server stopped by itself.
+			return
+		}
+		cancel()
+		sctx, scancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer scancel()
+		if err := e.Shutdown(sctx); err != nil {
+			e.Logger.Error(err)
+		}
+		// requests have finished, so no more first deliveries are started.
+		webhookHandler.Wait()
+	}()
 
 	// Service Start
 	port := os.Getenv("BIND_PORT")
 	err = e.Start(":" + port)
-	if err != nil {
+	cancel()
+	<-shutdownDone
+	<-jobsDone
+	if err != nil && err != http.ErrServerClosed {
 		e.Logger.Fatal(err)
 		return 1
 	}
 	return 0
 }
+
+// oidcProviders 環境変数からOpenID ConnectのIdentity Providerの設定を読み込む
+// OIDC_PROVIDERS にカンマ区切りでprovider名を指定し、providerごとに
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET, OIDC_<NAME>_REDIRECT_URL を指定する
+func oidcProviders() map[string]*oidc.Provider {
+	providers := map[string]*oidc.Provider{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		p := &oidc.Provider{
+			Name:         name,
+			IssuerURL:    os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Client:       &http.Client{Timeout: 10 * time.Second},
+		}
+		if p.IssuerURL == "" || p.ClientID == "" || p.RedirectURL == "" {
+			log.Printf("OIDC provider %s is not configured. Set %sISSUER, %sCLIENT_ID and %sREDIRECT_URL.\n",
+				name, prefix, prefix, prefix)
+			continue
+		}
+		providers[name] = p
+	}
+	return providers
+}
+
+// blobStore BLOB_STOREの設定に従って画像の保存先を返す
+// fileの場合はBLOB_DIR、s3の場合はS3_*の環境変数を使う
+func blobStore() (blob.Store, error) {
+	switch os.Getenv("BLOB_STORE") {
+	case "", "file":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "data/blobs"
+		}
+		return blob.NewFileStore(dir)
+	case "s3":
+		s := &blob.S3Store{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyId:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		}
+		if s.Endpoint == "" || s.Bucket == "" {
+			return nil, errors.New("S3_ENDPOINT and S3_BUCKET are required")
+		}
+		if s.Region == "" {
+			s.Region = "us-east-1"
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown BLOB_STORE %q", os.Getenv("BLOB_STORE"))
+}
+
+// healthConfig 環境変数で上書きした異常検出の閾値を返す
+func healthConfig() health.Config {
+	cfg := health.DefaultConfig
+	ints := map[string]*int{
+		"HEALTH_BASELINE_DAYS":     &cfg.BaselineDays,
+		"HEALTH_MIN_BASELINE_DAYS": &cfg.MinBaselineDays,
+		"HEALTH_MIN_EXCESS":        &cfg.MinExcess,
+		"HEALTH_NO_PEE_HOURS":      &cfg.NoPeeHours,
+		"HEALTH_NO_POOP_HOURS":     &cfg.NoPoopHours,
+	}
+	for name, p := range ints {
+		if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v >= 0 {
+			*p = v
+		}
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("HEALTH_HIGH_FACTOR"), 64); err == nil && v > 0 {
+		cfg.HighFactor = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("HEALTH_LOW_FACTOR"), 64); err == nil && v >= 0 {
+		cfg.LowFactor = v
+	}
+	return cfg
+}
+
+// notifyChannels 環境変数で設定された通知の手段を返す
+// メールはSMTP_ADDR、web pushはVAPID_PRIVATE_KEYを設定した場合に使える
+// NOTIFY_STANDIN=1の場合は全ての手段を実際には送らずログに出力する代替に置き換える
+func notifyChannels() (map[string]notify.Channel, error) {
+	channels := map[string]notify.Channel{
+		model.NotifyChannelWebhook: &notify.WebhookChannel{},
+	}
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		from := os.Getenv("SMTP_FROM")
+		if from == "" {
+			return nil, errors.New("SMTP_FROM is required")
+		}
+		channels[model.NotifyChannelEmail] = &notify.SMTPChannel{
+			Addr:     addr,
+			From:     from,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	}
+	if key := os.Getenv("VAPID_PRIVATE_KEY"); key != "" {
+		k, err := notify.ParseVAPIDKey(key)
+		if err != nil {
+			return nil, err
+		}
+		subject := os.Getenv("VAPID_SUBJECT")
+		if subject == "" {
+			return nil, errors.New("VAPID_SUBJECT is required")
+		}
+		channels[model.NotifyChannelWebPush] = &notify.WebPushChannel{Key: k, Subject: subject}
+	}
+
+	if os.Getenv("NOTIFY_STANDIN") == "1" {
+		for _, name := range []string{model.NotifyChannelEmail, model.NotifyChannelWebhook, model.NotifyChannelWebPush} {
+			channels[name] = &notify.LogChannel{Name: name, Next: channels[name]}
+		}
+	}
+	return channels, nil
+}
+
+// addJobs 定期処理を登録する
+// 予定は JOB_<NAME>_SCHEDULE (例: JOB_NOTIFY_SCHEDULE) にcron形式で指定して変更できる
+func addJobs(s *job.Scheduler, n *handler.Notifier, ws *webhook.Sender, mda *db.MysqlDbAccessor) error {
+	retention := 90
+	if v, err := strconv.Atoi(os.Getenv("NOTIFY_RETENTION_DAYS")); err == nil && v > 0 {
+		retention = v
+	}
+	webhookRetention := 30
+	if v, err := strconv.Atoi(os.Getenv("WEBHOOK_RETENTION_DAYS")); err == nil && v > 0 {
+		webhookRetention = v
+	}
+	jobs := []struct {
+		name string
+		spec string
+		run  func(ctx context.Context) error
+	}{
+		{"notify", "* * * * *", func(ctx context.Context) error {
+			now := time.Now()
+			evalErr := n.Evaluate(ctx, now)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if _, err := n.Deliver(ctx, now); err != nil {
+				return err
+			}
+			return evalErr
+		}},
+		{"purge_notifications", "30 3 * * *", func(ctx context.Context) error {
+			_, err := mda.PurgeNotifications(time.Now().AddDate(0, 0, -retention))
+			return err
+		}},
+		{"webhooks", "* * * * *", func(ctx context.Context) error {
+			_, err := ws.DeliverPending(time.Now())
+			return err
+		}},
+		{"purge_webhook_deliveries", "45 3 * * *", func(ctx context.Context) error {
+			_, err := mda.PurgeWebhookDeliveries(time.Now().AddDate(0, 0, -webhookRetention))
+			return err
+		}},
+	}
+	for _, j := range jobs {
+		spec := j.spec
+		if v := os.Getenv("JOB_" + strings.ToUpper(j.name) + "_SCHEDULE"); v != "" {
+			spec = v
+		}
+		c, err := schedule.ParseCron(spec, time.Local)
+		if err != nil {
+			return fmt.Errorf("job %s: %v", j.name, err)
+		}
+		if c.Next(time.Now()).IsZero() {
+			return fmt.Errorf("job %s: schedule %q never occurs", j.name, spec)
+		}
+		s.Add(job.Job{Name: j.name, Schedule: c, Run: j.run})
+	}
+	return nil
+}